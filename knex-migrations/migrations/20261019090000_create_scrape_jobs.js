@@ -0,0 +1,36 @@
+exports.up = async function(knex) {
+  await knex.schema.createTable('scrape_jobs', function(table) {
+    table.increments('id').primary();
+    table.text('term').notNullable().unique();
+    table.text('status').notNullable().defaultTo('pending')
+      .checkIn(['pending', 'running', 'done', 'dead']);
+    table.integer('attempts').notNullable().defaultTo(0);
+    table.text('last_error');
+    table.timestamp('next_attempt_at').notNullable().defaultTo(knex.fn.now());
+    table.timestamp('created_at').notNullable().defaultTo(knex.fn.now());
+    table.timestamp('updated_at').notNullable().defaultTo(knex.fn.now());
+    table.index(['status', 'next_attempt_at']);
+  });
+
+  // Terms that were already scraped successfully carry over as finished jobs.
+  if (await knex.schema.hasTable('processed_searches')) {
+    await knex.raw(`
+      INSERT INTO scrape_jobs (term, status, created_at, updated_at)
+      SELECT search_term, 'done', processed_at, processed_at FROM processed_searches
+      ON CONFLICT (term) DO NOTHING
+    `);
+    await knex.schema.dropTable('processed_searches');
+  }
+};
+
+exports.down = async function(knex) {
+  await knex.schema.createTable('processed_searches', function(table) {
+    table.text('search_term').primary();
+    table.timestamp('processed_at').defaultTo(knex.fn.now());
+  });
+  await knex.raw(`
+    INSERT INTO processed_searches (search_term, processed_at)
+    SELECT term, updated_at FROM scrape_jobs WHERE status = 'done'
+  `);
+  await knex.schema.dropTableIfExists('scrape_jobs');
+};
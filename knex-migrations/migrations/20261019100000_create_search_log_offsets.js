@@ -0,0 +1,12 @@
+exports.up = function(knex) {
+  return knex.schema.createTable('search_log_offsets', function(table) {
+    table.text('path').primary();
+    table.bigInteger('inode').notNullable().defaultTo(0);
+    table.bigInteger('byte_offset').notNullable().defaultTo(0);
+    table.timestamp('updated_at').notNullable().defaultTo(knex.fn.now());
+  });
+};
+
+exports.down = function(knex) {
+  return knex.schema.dropTableIfExists('search_log_offsets');
+};
@@ -0,0 +1,18 @@
+exports.up = function(knex) {
+  return knex.schema.alterTable('pages', function(table) {
+    table.text('etag');
+    table.text('http_last_modified');
+    table.timestamp('last_crawled_at');
+    // Set when the source answers 404/410; the row is kept as a tombstone.
+    table.timestamp('gone_at');
+  });
+};
+
+exports.down = function(knex) {
+  return knex.schema.alterTable('pages', function(table) {
+    table.dropColumn('etag');
+    table.dropColumn('http_last_modified');
+    table.dropColumn('last_crawled_at');
+    table.dropColumn('gone_at');
+  });
+};
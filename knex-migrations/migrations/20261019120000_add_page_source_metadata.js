@@ -0,0 +1,15 @@
+exports.up = function(knex) {
+  return knex.schema.alterTable('pages', function(table) {
+    table.integer('page_id');
+    table.bigInteger('revision_id');
+    table.specificType('categories', 'TEXT[]').notNullable().defaultTo('{}');
+  });
+};
+
+exports.down = function(knex) {
+  return knex.schema.alterTable('pages', function(table) {
+    table.dropColumn('page_id');
+    table.dropColumn('revision_id');
+    table.dropColumn('categories');
+  });
+};
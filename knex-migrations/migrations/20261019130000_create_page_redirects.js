@@ -0,0 +1,15 @@
+exports.up = function(knex) {
+  return knex.schema.createTable('page_redirects', function(table) {
+    table.text('language').notNullable();
+    table.text('title').notNullable();
+    table.text('url').notNullable()
+      .references('url').inTable('pages').onDelete('CASCADE');
+    table.timestamp('created_at').notNullable().defaultTo(knex.fn.now());
+    table.primary(['language', 'title']);
+    table.index(['url']);
+  });
+};
+
+exports.down = function(knex) {
+  return knex.schema.dropTableIfExists('page_redirects');
+};
@@ -0,0 +1,19 @@
+exports.up = function(knex) {
+  return knex.schema.alterTable('pages', function(table) {
+    table.text('summary');
+    table.specificType('sections', 'TEXT[]').notNullable().defaultTo('{}');
+    table.jsonb('infobox').notNullable().defaultTo('{}');
+    table.specificType('image_captions', 'TEXT[]').notNullable().defaultTo('{}');
+    table.specificType('outgoing_links', 'TEXT[]').notNullable().defaultTo('{}');
+  });
+};
+
+exports.down = function(knex) {
+  return knex.schema.alterTable('pages', function(table) {
+    table.dropColumn('summary');
+    table.dropColumn('sections');
+    table.dropColumn('infobox');
+    table.dropColumn('image_captions');
+    table.dropColumn('outgoing_links');
+  });
+};
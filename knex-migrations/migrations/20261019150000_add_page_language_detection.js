@@ -0,0 +1,25 @@
+exports.up = async function(knex) {
+  await knex.schema.alterTable('pages', function(table) {
+    // The Wikipedia edition a page was fetched from; language now holds the
+    // language detected from the text.
+    table.text('source_language');
+    table.text('detected_language');
+    table.float('language_confidence');
+  });
+  await knex.raw('UPDATE pages SET source_language = language');
+
+  // Any ISO 639-1/639-3 style code is allowed, not just en/da.
+  await knex.raw('ALTER TABLE pages DROP CONSTRAINT IF EXISTS pages_language_check');
+  await knex.raw(`ALTER TABLE pages ADD CONSTRAINT pages_language_check CHECK (language ~ '^[a-z]{2,3}$')`);
+};
+
+exports.down = async function(knex) {
+  await knex.raw('ALTER TABLE pages DROP CONSTRAINT IF EXISTS pages_language_check');
+  await knex.raw('UPDATE pages SET language = source_language WHERE source_language IS NOT NULL');
+  await knex.raw(`ALTER TABLE pages ADD CONSTRAINT pages_language_check CHECK (language IN ('en', 'da'))`);
+  await knex.schema.alterTable('pages', function(table) {
+    table.dropColumn('source_language');
+    table.dropColumn('detected_language');
+    table.dropColumn('language_confidence');
+  });
+};
@@ -0,0 +1,25 @@
+exports.up = async function(knex) {
+  await knex.schema.alterTable('pages', function(table) {
+    // 64-bit SimHash of the content, stored as a signed bigint.
+    table.bigInteger('simhash');
+    // URL of the page that represents this page's near-duplicate cluster.
+    table.text('cluster_id');
+    table.index(['cluster_id']);
+  });
+
+  // One index per 16-bit band; near-duplicates share at least one band.
+  for (const shift of [0, 16, 32, 48]) {
+    await knex.raw(`CREATE INDEX pages_simhash_band_${shift} ON pages (((simhash >> ${shift}) & 65535))`);
+  }
+};
+
+exports.down = async function(knex) {
+  for (const shift of [0, 16, 32, 48]) {
+    await knex.raw(`DROP INDEX IF EXISTS pages_simhash_band_${shift}`);
+  }
+  await knex.schema.alterTable('pages', function(table) {
+    table.dropIndex(['cluster_id']);
+    table.dropColumn('simhash');
+    table.dropColumn('cluster_id');
+  });
+};
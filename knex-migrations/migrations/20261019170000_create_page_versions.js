@@ -0,0 +1,20 @@
+exports.up = function(knex) {
+  return knex.schema.createTable('page_versions', function(table) {
+    table.increments('id').primary();
+    table.text('url').notNullable()
+      .references('url').inTable('pages').onDelete('CASCADE').onUpdate('CASCADE');
+    table.text('title').notNullable();
+    table.text('content').notNullable();
+    // SHA-256 of content, hex encoded.
+    table.text('content_hash').notNullable();
+    table.bigInteger('revision_id');
+    // When this version was fetched, i.e. its last_updated while current.
+    table.timestamp('captured_at').notNullable();
+    table.timestamp('archived_at').notNullable().defaultTo(knex.fn.now());
+    table.index(['url', 'captured_at']);
+  });
+};
+
+exports.down = function(knex) {
+  return knex.schema.dropTableIfExists('page_versions');
+};
@@ -0,0 +1,40 @@
+exports.up = async function(knex) {
+  // Jobs are either logged search terms or URLs from sitemaps and feeds; the
+  // term column holds the URL for the latter.
+  await knex.schema.alterTable('scrape_jobs', function(table) {
+    table.text('kind').notNullable().defaultTo('term').checkIn(['term', 'url']);
+    // Feed or sitemap metadata for URL jobs.
+    table.jsonb('payload');
+    table.dropUnique(['term']);
+    table.unique(['kind', 'term']);
+  });
+
+  await knex.schema.createTable('ingest_sources', function(table) {
+    table.increments('id').primary();
+    table.text('url').notNullable().unique();
+    table.boolean('enabled').notNullable().defaultTo(true);
+    table.timestamp('last_fetched_at');
+    table.text('last_error');
+    table.timestamp('created_at').notNullable().defaultTo(knex.fn.now());
+  });
+
+  await knex.schema.alterTable('pages', function(table) {
+    table.text('source').notNullable().defaultTo('wikipedia');
+    table.timestamp('published_at');
+  });
+};
+
+exports.down = async function(knex) {
+  await knex.schema.alterTable('pages', function(table) {
+    table.dropColumn('source');
+    table.dropColumn('published_at');
+  });
+  await knex.schema.dropTableIfExists('ingest_sources');
+  await knex('scrape_jobs').where('kind', 'url').del();
+  await knex.schema.alterTable('scrape_jobs', function(table) {
+    table.dropUnique(['kind', 'term']);
+    table.unique(['term']);
+    table.dropColumn('kind');
+    table.dropColumn('payload');
+  });
+};
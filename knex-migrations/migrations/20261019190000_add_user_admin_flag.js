@@ -0,0 +1,15 @@
+exports.up = async function(knex) {
+  await knex.schema.alterTable('users', function(table) {
+    table.boolean('is_admin').notNullable().defaultTo(false);
+  });
+  // The user created by the first migration is the administrator.
+  await knex('users')
+    .where('username', process.env.ADMIN_USER || 'admin')
+    .update({ is_admin: true });
+};
+
+exports.down = async function(knex) {
+  await knex.schema.alterTable('users', function(table) {
+    table.dropColumn('is_admin');
+  });
+};
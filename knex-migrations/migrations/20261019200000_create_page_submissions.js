@@ -0,0 +1,32 @@
+exports.up = async function(knex) {
+  await knex.schema.createTable('page_submissions', function(table) {
+    table.increments('id').primary();
+    table.text('url').notNullable();
+    table.text('reason');
+    table.text('status').notNullable().defaultTo('pending')
+      .checkIn(['pending', 'approved', 'rejected']);
+    table.integer('submitted_by')
+      .references('id').inTable('users').onDelete('SET NULL');
+    table.integer('reviewed_by')
+      .references('id').inTable('users').onDelete('SET NULL');
+    table.text('review_note');
+    // The scrape job queued when the submission was approved.
+    table.integer('job_id')
+      .references('id').inTable('scrape_jobs').onDelete('SET NULL');
+    table.timestamp('created_at').notNullable().defaultTo(knex.fn.now());
+    table.timestamp('reviewed_at');
+    table.index(['status', 'created_at']);
+    // Rate limits count a user's recent submissions.
+    table.index(['submitted_by', 'created_at']);
+  });
+
+  // A URL can only wait for review once at a time.
+  await knex.raw(`
+    CREATE UNIQUE INDEX page_submissions_pending_url_unique
+    ON page_submissions (url) WHERE status = 'pending'
+  `);
+};
+
+exports.down = async function(knex) {
+  await knex.schema.dropTableIfExists('page_submissions');
+};
@@ -0,0 +1,30 @@
+exports.up = async function(knex) {
+  await knex.schema.createTable('search_events', function(table) {
+    table.bigIncrements('id').primary();
+    table.timestamp('created_at').notNullable().defaultTo(knex.fn.now());
+    table.text('query').notNullable();
+    table.text('normalized_query').notNullable();
+    table.text('language');
+    table.jsonb('filters');
+    table.integer('result_count').notNullable();
+    table.integer('latency_ms').notNullable();
+    table.integer('user_id')
+      .references('id').inTable('users').onDelete('SET NULL');
+    // Salted SHA-256 of the client IP; the IP itself is never stored.
+    table.text('client_ip_hash');
+    table.index(['created_at']);
+    table.index(['normalized_query']);
+  });
+
+  // How far each reader (e.g. the scraper) has consumed search_events.
+  await knex.schema.createTable('search_event_cursors', function(table) {
+    table.text('consumer').primary();
+    table.bigInteger('last_event_id').notNullable().defaultTo(0);
+    table.timestamp('updated_at').notNullable().defaultTo(knex.fn.now());
+  });
+};
+
+exports.down = async function(knex) {
+  await knex.schema.dropTableIfExists('search_event_cursors');
+  await knex.schema.dropTableIfExists('search_events');
+};
@@ -0,0 +1,21 @@
+exports.up = async function(knex) {
+  // One row per search result opened through the /r redirect.
+  await knex.schema.createTable('search_clicks', function(table) {
+    table.bigIncrements('id').primary();
+    table.timestamp('created_at').notNullable().defaultTo(knex.fn.now());
+    table.text('query').notNullable();
+    table.text('normalized_query').notNullable();
+    table.text('url').notNullable();
+    // 1-based rank of the result on the results page.
+    table.integer('position').notNullable();
+    table.integer('user_id')
+      .references('id').inTable('users').onDelete('SET NULL');
+    table.text('client_ip_hash');
+    table.index(['created_at']);
+    table.index(['normalized_query', 'url']);
+  });
+};
+
+exports.down = async function(knex) {
+  await knex.schema.dropTableIfExists('search_clicks');
+};
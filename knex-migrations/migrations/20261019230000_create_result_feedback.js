@@ -0,0 +1,34 @@
+exports.up = async function(knex) {
+  // A user's thumbs up (1) or down (-1) on a result for a query. Voting
+  // again replaces the earlier vote.
+  await knex.schema.createTable('result_feedback', function(table) {
+    table.increments('id').primary();
+    table.text('normalized_query').notNullable();
+    table.text('url').notNullable();
+    table.integer('user_id').notNullable()
+      .references('id').inTable('users').onDelete('CASCADE');
+    table.smallint('vote').notNullable().checkIn([-1, 1]);
+    table.timestamp('created_at').notNullable().defaultTo(knex.fn.now());
+    table.timestamp('updated_at').notNullable().defaultTo(knex.fn.now());
+    table.unique(['normalized_query', 'url', 'user_id']);
+    table.index(['updated_at']);
+  });
+
+  // Curated results set by admins: pinned URLs are put first for the
+  // query, buried ones pushed to the bottom.
+  await knex.schema.createTable('query_overrides', function(table) {
+    table.increments('id').primary();
+    table.text('normalized_query').notNullable();
+    table.text('url').notNullable();
+    table.text('action').notNullable().checkIn(['pin', 'bury']);
+    table.integer('created_by')
+      .references('id').inTable('users').onDelete('SET NULL');
+    table.timestamp('created_at').notNullable().defaultTo(knex.fn.now());
+    table.unique(['normalized_query', 'url']);
+  });
+};
+
+exports.down = async function(knex) {
+  await knex.schema.dropTableIfExists('query_overrides');
+  await knex.schema.dropTableIfExists('result_feedback');
+};
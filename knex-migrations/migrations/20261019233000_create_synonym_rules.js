@@ -0,0 +1,33 @@
+exports.up = async function(knex) {
+  // Synonym and rewrite rules for search. Without a replacement the terms
+  // are equivalent ("kbh, københavn"); with one, the terms are rewritten to
+  // it ("kbh => københavn").
+  await knex.schema.createTable('synonym_rules', function(table) {
+    table.increments('id').primary();
+    table.specificType('terms', 'text[]').notNullable();
+    table.specificType('replacement', 'text[]');
+    table.integer('created_by')
+      .references('id').inTable('users').onDelete('SET NULL');
+    table.timestamp('created_at').notNullable().defaultTo(knex.fn.now());
+    table.timestamp('updated_at').notNullable().defaultTo(knex.fn.now());
+  });
+
+  // Every change to synonym_rules, kept after the rule itself is deleted.
+  await knex.schema.createTable('synonym_rule_history', function(table) {
+    table.bigIncrements('id').primary();
+    table.integer('rule_id').notNullable();
+    table.text('action').notNullable().checkIn(['create', 'update', 'delete']);
+    table.jsonb('before');
+    table.jsonb('after');
+    table.integer('changed_by')
+      .references('id').inTable('users').onDelete('SET NULL');
+    table.timestamp('changed_at').notNullable().defaultTo(knex.fn.now());
+    table.index(['rule_id']);
+    table.index(['changed_at']);
+  });
+};
+
+exports.down = async function(knex) {
+  await knex.schema.dropTableIfExists('synonym_rule_history');
+  await knex.schema.dropTableIfExists('synonym_rules');
+};
@@ -0,0 +1,13 @@
+exports.up = async function(knex) {
+  // Users who opt out have their searches recorded without their user ID
+  // or client address.
+  await knex.schema.alterTable('users', function(table) {
+    table.boolean('search_history_opt_out').notNullable().defaultTo(false);
+  });
+};
+
+exports.down = async function(knex) {
+  await knex.schema.alterTable('users', function(table) {
+    table.dropColumn('search_history_opt_out');
+  });
+};
@@ -0,0 +1,33 @@
+exports.up = async function(knex) {
+  // Searches made by logged-in users, listed back to them as their recent
+  // searches. Kept apart from search_events so clearing it leaves the
+  // analytics alone.
+  await knex.schema.createTable('search_history', function(table) {
+    table.bigIncrements('id').primary();
+    table.integer('user_id').notNullable()
+      .references('id').inTable('users').onDelete('CASCADE');
+    table.text('query').notNullable();
+    table.text('normalized_query').notNullable();
+    table.jsonb('filters');
+    table.timestamp('created_at').notNullable().defaultTo(knex.fn.now());
+    table.index(['user_id', 'created_at']);
+  });
+
+  // Queries a user saved under a name of their choosing, with the filters
+  // they were run with.
+  await knex.schema.createTable('saved_searches', function(table) {
+    table.increments('id').primary();
+    table.integer('user_id').notNullable()
+      .references('id').inTable('users').onDelete('CASCADE');
+    table.text('name').notNullable();
+    table.text('query').notNullable();
+    table.jsonb('filters');
+    table.timestamp('created_at').notNullable().defaultTo(knex.fn.now());
+    table.unique(['user_id', 'name']);
+  });
+};
+
+exports.down = async function(knex) {
+  await knex.schema.dropTableIfExists('saved_searches');
+  await knex.schema.dropTableIfExists('search_history');
+};
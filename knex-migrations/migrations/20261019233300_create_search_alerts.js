@@ -0,0 +1,30 @@
+exports.up = async function(knex) {
+  // When a page was first ingested, so alerts can tell new pages from
+  // refreshed ones. Pages already stored count as ingested now.
+  await knex.schema.alterTable('pages', function(table) {
+    table.timestamp('created_at').notNullable().defaultTo(knex.fn.now());
+    table.index(['created_at']);
+  });
+
+  // A subscription to new pages matching a saved search, delivered by email
+  // to the user or as a POST to their webhook. Pages ingested after
+  // last_checked_at have not been looked at yet.
+  await knex.schema.createTable('search_alerts', function(table) {
+    table.increments('id').primary();
+    table.integer('saved_search_id').notNullable().unique()
+      .references('id').inTable('saved_searches').onDelete('CASCADE');
+    table.text('channel').notNullable().checkIn(['email', 'webhook']);
+    table.text('webhook_url');
+    table.timestamp('last_checked_at').notNullable().defaultTo(knex.fn.now());
+    table.timestamp('last_sent_at');
+    table.timestamp('created_at').notNullable().defaultTo(knex.fn.now());
+  });
+};
+
+exports.down = async function(knex) {
+  await knex.schema.dropTableIfExists('search_alerts');
+  await knex.schema.alterTable('pages', function(table) {
+    table.dropIndex(['created_at']);
+    table.dropColumn('created_at');
+  });
+};
@@ -0,0 +1,31 @@
+exports.up = async function(knex) {
+  // Named collections of bookmarked pages. A collection with a share token
+  // can be read by anyone who has the link.
+  await knex.schema.createTable('collections', function(table) {
+    table.increments('id').primary();
+    table.integer('user_id').notNullable()
+      .references('id').inTable('users').onDelete('CASCADE');
+    table.text('name').notNullable();
+    table.text('share_token').unique();
+    table.timestamp('created_at').notNullable().defaultTo(knex.fn.now());
+    table.timestamp('updated_at').notNullable().defaultTo(knex.fn.now());
+    table.unique(['user_id', 'name']);
+  });
+
+  // The pages in each collection, with the user's note on why.
+  await knex.schema.createTable('collection_pages', function(table) {
+    table.increments('id').primary();
+    table.integer('collection_id').notNullable()
+      .references('id').inTable('collections').onDelete('CASCADE');
+    table.text('page_url').notNullable()
+      .references('url').inTable('pages').onDelete('CASCADE');
+    table.text('note').notNullable().defaultTo('');
+    table.timestamp('created_at').notNullable().defaultTo(knex.fn.now());
+    table.unique(['collection_id', 'page_url']);
+  });
+};
+
+exports.down = async function(knex) {
+  await knex.schema.dropTableIfExists('collection_pages');
+  await knex.schema.dropTableIfExists('collections');
+};
@@ -0,0 +1,17 @@
+exports.up = async function(knex) {
+  // Depending on SEARCH_IP_ANONYMIZATION the column holds a keyed hash or a
+  // truncated address, so it is no longer named after the hash.
+  for (const tableName of ['search_events', 'search_clicks']) {
+    await knex.schema.alterTable(tableName, function(table) {
+      table.renameColumn('client_ip_hash', 'client_ip_anon');
+    });
+  }
+};
+
+exports.down = async function(knex) {
+  for (const tableName of ['search_events', 'search_clicks']) {
+    await knex.schema.alterTable(tableName, function(table) {
+      table.renameColumn('client_ip_anon', 'client_ip_hash');
+    });
+  }
+};
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	neturl "net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Admin endpoints for queueing scrape jobs on demand instead of waiting for
+// terms to show up in the search log. Jobs go through the same queue,
+// workers and save path as the scheduled scraper.
+
+// adminMaxBatch caps how many terms and URLs one request may queue.
+const adminMaxBatch = 500
+
+// requireAdmin only lets logged-in users with users.is_admin set through.
+func requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := sessionUserID(r)
+		if !ok {
+			http.Error(w, "Login required", http.StatusUnauthorized)
+			return
+		}
+
+		var isAdmin bool
+		err := db.QueryRow("SELECT is_admin FROM users WHERE id = $1", userID).Scan(&isAdmin)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Login required", http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			log.Printf("Error checking admin status of user %d: %v", userID, err)
+			http.Error(w, "Error checking permissions", http.StatusInternalServerError)
+			return
+		}
+		if !isAdmin {
+			http.Error(w, "Admin access required", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+type adminScrapeRequest struct {
+	Term  string   `json:"term"`
+	URL   string   `json:"url"`
+	Terms []string `json:"terms"`
+	URLs  []string `json:"urls"`
+}
+
+type queuedJob struct {
+	ID        int    `json:"id"`
+	Kind      string `json:"kind"`
+	Term      string `json:"term"`
+	StatusURL string `json:"status_url"`
+}
+
+func newQueuedJob(id int, kind, term string) queuedJob {
+	return queuedJob{ID: id, Kind: kind, Term: term, StatusURL: fmt.Sprintf("/api/admin/jobs/%d", id)}
+}
+
+// adminScrapeHandler queues search terms and URLs for immediate scraping.
+// The body holds a single "term" or "url", or "terms" and "urls" lists.
+// POST /api/admin/scrape
+func adminScrapeHandler(w http.ResponseWriter, r *http.Request) {
+	var req adminScrapeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	rawTerms, rawURLs := req.Terms, req.URLs
+	if req.Term != "" {
+		rawTerms = append(rawTerms, req.Term)
+	}
+	if req.URL != "" {
+		rawURLs = append(rawURLs, req.URL)
+	}
+	if len(rawTerms)+len(rawURLs) == 0 {
+		http.Error(w, "term, url, terms or urls is required", http.StatusBadRequest)
+		return
+	}
+	if len(rawTerms)+len(rawURLs) > adminMaxBatch {
+		http.Error(w, fmt.Sprintf("At most %d terms and URLs per request", adminMaxBatch), http.StatusBadRequest)
+		return
+	}
+
+	// Validate everything before queueing anything.
+	var terms, urls []string
+	seenURLs := make(map[string]bool)
+	for _, raw := range rawTerms {
+		term := normalizeSearchTerm(raw)
+		if term == "" {
+			http.Error(w, fmt.Sprintf("Invalid term: %q", raw), http.StatusBadRequest)
+			return
+		}
+		terms = append(terms, term)
+	}
+	for _, raw := range rawURLs {
+		url, ok := validPageURL(raw)
+		if !ok {
+			http.Error(w, fmt.Sprintf("Invalid URL: %q", raw), http.StatusBadRequest)
+			return
+		}
+		if !seenURLs[url] {
+			seenURLs[url] = true
+			urls = append(urls, url)
+		}
+	}
+
+	var jobs []queuedJob
+	for _, term := range dedupeTerms(terms) {
+		id, err := requeueScrapeJob(jobKindTerm, term)
+		if err != nil {
+			log.Printf("%v", err)
+			http.Error(w, "Error queueing scrape jobs", http.StatusInternalServerError)
+			return
+		}
+		jobs = append(jobs, newQueuedJob(id, jobKindTerm, term))
+	}
+	for _, url := range urls {
+		id, err := requeueScrapeJob(jobKindURL, url)
+		if err != nil {
+			log.Printf("%v", err)
+			http.Error(w, "Error queueing scrape jobs", http.StatusInternalServerError)
+			return
+		}
+		jobs = append(jobs, newQueuedJob(id, jobKindURL, url))
+	}
+
+	kickScrapeWorkers()
+	writeJSON(w, http.StatusAccepted, map[string]any{"jobs": jobs})
+}
+
+// adminJobHandler reports the status of a scrape job.
+// GET /api/admin/jobs/{id}
+func adminJobHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid job ID", http.StatusBadRequest)
+		return
+	}
+
+	job, err := loadScrapeJob(id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error loading scrape job %d: %v", id, err)
+		http.Error(w, "Error loading job", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+// adminRescrapeHandler fetches a stored page again right away, from
+// Wikipedia or the web depending on where it came from.
+// POST /api/admin/pages/rescrape {"url": "..."}
+func adminRescrapeHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		URL string `json:"url"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.URL == "" {
+		http.Error(w, "url is required", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := loadStoredPage(req.URL); err == sql.ErrNoRows {
+		http.Error(w, "Page not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Printf("Error loading page %s: %v", req.URL, err)
+		http.Error(w, "Error loading page", http.StatusInternalServerError)
+		return
+	}
+
+	id, err := requeueScrapeJob(jobKindURL, req.URL)
+	if err != nil {
+		log.Printf("%v", err)
+		http.Error(w, "Error queueing scrape job", http.StatusInternalServerError)
+		return
+	}
+
+	kickScrapeWorkers()
+	writeJSON(w, http.StatusAccepted, newQueuedJob(id, jobKindURL, req.URL))
+}
+
+// validPageURL accepts absolute http(s) URLs and returns them without the
+// fragment.
+func validPageURL(raw string) (string, bool) {
+	u, err := neturl.Parse(strings.TrimSpace(raw))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", false
+	}
+	u.Fragment = ""
+	return u.String(), true
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/sessions"
+	"github.com/stretchr/testify/assert"
+)
+
+// loggedInRequest builds a request carrying a session cookie for userID, or
+// no session at all when userID is 0.
+func loggedInRequest(t *testing.T, method, target, body string, userID int) *http.Request {
+	t.Helper()
+	store = sessions.NewCookieStore([]byte("test-secret"))
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if userID == 0 {
+		return req
+	}
+
+	w := httptest.NewRecorder()
+	session, err := store.Get(httptest.NewRequest("GET", "/", nil), "session-name")
+	assert.NoError(t, err)
+	session.Values["user_id"] = userID
+	assert.NoError(t, session.Save(req, w))
+	for _, c := range w.Result().Cookies() {
+		req.AddCookie(c)
+	}
+	return req
+}
+
+// holdScrapeWorkers keeps handlers from starting a background worker run
+// against the mock database.
+func holdScrapeWorkers(t *testing.T) {
+	scrapeRunning.Store(true)
+	t.Cleanup(func() {
+		scrapeRunning.Store(false)
+		scrapeKicked.Store(false)
+	})
+}
+
+func newAdminRouter() *mux.Router {
+	r := mux.NewRouter()
+	admin := r.PathPrefix("/api/admin").Subrouter()
+	admin.Use(requireAdmin)
+	admin.HandleFunc("/scrape", adminScrapeHandler).Methods("POST")
+	admin.HandleFunc("/jobs/{id:[0-9]+}", adminJobHandler).Methods("GET")
+	admin.HandleFunc("/pages/rescrape", adminRescrapeHandler).Methods("POST")
+	return r
+}
+
+func TestRequireAdmin(t *testing.T) {
+	testCases := []struct {
+		name       string
+		userID     int
+		mockSetup  func(mock sqlmock.Sqlmock)
+		wantStatus int
+	}{
+		{
+			name:       "Not logged in",
+			mockSetup:  func(mock sqlmock.Sqlmock) {},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:   "Deleted user",
+			userID: 7,
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT is_admin FROM users").WithArgs(7).WillReturnError(sql.ErrNoRows)
+			},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:   "Not an admin",
+			userID: 2,
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT is_admin FROM users").WithArgs(2).
+					WillReturnRows(sqlmock.NewRows([]string{"is_admin"}).AddRow(false))
+			},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:   "Admin",
+			userID: 1,
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT is_admin FROM users").WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"is_admin"}).AddRow(true))
+			},
+			wantStatus: http.StatusNoContent,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockDB, mock := setupMockDB()
+			defer mockDB.Close()
+			tc.mockSetup(mock)
+
+			handler := requireAdmin(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			}))
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, loggedInRequest(t, "GET", "/api/admin/jobs/1", "", tc.userID))
+
+			assert.Equal(t, tc.wantStatus, w.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func expectAdmin(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery("SELECT is_admin FROM users").WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"is_admin"}).AddRow(true))
+}
+
+func TestAdminScrapeHandler(t *testing.T) {
+	testCases := []struct {
+		name       string
+		body       string
+		mockSetup  func(mock sqlmock.Sqlmock)
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "Invalid JSON",
+			body:       `{"term":`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Nothing to queue",
+			body:       `{}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Invalid URL rejects the whole batch",
+			body:       `{"terms":["golang"],"urls":["ftp://example.com/file"]}`,
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "Invalid URL",
+		},
+		{
+			name: "Single term",
+			body: `{"term":"  Golang "}`,
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("INSERT INTO scrape_jobs").WithArgs(jobKindTerm, "golang").
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(12))
+			},
+			wantStatus: http.StatusAccepted,
+			wantBody:   `"status_url":"/api/admin/jobs/12"`,
+		},
+		{
+			name: "Batch with a running job",
+			body: `{"terms":["golang","GOLANG"],"urls":["https://example.com/a#top","https://example.com/a"]}`,
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("INSERT INTO scrape_jobs").WithArgs(jobKindTerm, "golang").
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(12))
+				mock.ExpectQuery("INSERT INTO scrape_jobs").WithArgs(jobKindURL, "https://example.com/a").
+					WillReturnError(sql.ErrNoRows)
+				mock.ExpectQuery("SELECT id FROM scrape_jobs").WithArgs(jobKindURL, "https://example.com/a").
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(30))
+			},
+			wantStatus: http.StatusAccepted,
+			wantBody:   `{"id":30,"kind":"url","term":"https://example.com/a","status_url":"/api/admin/jobs/30"}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			holdScrapeWorkers(t)
+			mockDB, mock := setupMockDB()
+			defer mockDB.Close()
+			expectAdmin(mock)
+			if tc.mockSetup != nil {
+				tc.mockSetup(mock)
+			}
+
+			w := httptest.NewRecorder()
+			newAdminRouter().ServeHTTP(w, loggedInRequest(t, "POST", "/api/admin/scrape", tc.body, 1))
+
+			assert.Equal(t, tc.wantStatus, w.Code)
+			assert.Contains(t, w.Body.String(), tc.wantBody)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestAdminJobHandler(t *testing.T) {
+	mockDB, mock := setupMockDB()
+	defer mockDB.Close()
+	now := time.Now()
+
+	expectAdmin(mock)
+	mock.ExpectQuery("SELECT id, kind, term, status").WithArgs(12).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "kind", "term", "status", "attempts", "last_error", "next_attempt_at", "created_at", "updated_at"}).
+			AddRow(12, "term", "golang", "pending", 1, "timeout", now, now, now))
+	w := httptest.NewRecorder()
+	newAdminRouter().ServeHTTP(w, loggedInRequest(t, "GET", "/api/admin/jobs/12", "", 1))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"status":"pending","attempts":1,"last_error":"timeout"`)
+
+	expectAdmin(mock)
+	mock.ExpectQuery("SELECT id, kind, term, status").WithArgs(99).WillReturnError(sql.ErrNoRows)
+	w = httptest.NewRecorder()
+	newAdminRouter().ServeHTTP(w, loggedInRequest(t, "GET", "/api/admin/jobs/99", "", 1))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAdminRescrapeHandler(t *testing.T) {
+	const pageURL = "https://en.wikipedia.org/wiki/Go_(programming_language)"
+	testCases := []struct {
+		name       string
+		body       string
+		mockSetup  func(mock sqlmock.Sqlmock)
+		wantStatus int
+	}{
+		{
+			name:       "Missing url",
+			body:       `{}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "Unknown page",
+			body: `{"url":"https://example.com/nope"}`,
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT url, title").WithArgs("https://example.com/nope").WillReturnError(sql.ErrNoRows)
+			},
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name: "Stored page",
+			body: `{"url":"` + pageURL + `"}`,
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT url, title").WithArgs(pageURL).
+					WillReturnRows(sqlmock.NewRows([]string{"url", "title", "language", "source"}).
+						AddRow(pageURL, "Go (programming language)", "en", sourceWikipedia))
+				mock.ExpectQuery("INSERT INTO scrape_jobs").WithArgs(jobKindURL, pageURL).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(5))
+			},
+			wantStatus: http.StatusAccepted,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			holdScrapeWorkers(t)
+			mockDB, mock := setupMockDB()
+			defer mockDB.Close()
+			expectAdmin(mock)
+			if tc.mockSetup != nil {
+				tc.mockSetup(mock)
+			}
+
+			w := httptest.NewRecorder()
+			newAdminRouter().ServeHTTP(w, loggedInRequest(t, "POST", "/api/admin/pages/rescrape", tc.body, 1))
+
+			assert.Equal(t, tc.wantStatus, w.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
@@ -0,0 +1,523 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Users can subscribe to a saved search and be told about new pages that
+// match it. After each indexing run, sendSearchAlerts re-runs the subscribed
+// searches against the pages ingested since they were last checked and
+// hands any matches to the notifier for the subscription's channel.
+
+const (
+	alertChannelEmail   = "email"
+	alertChannelWebhook = "webhook"
+
+	// maxAlertPages is how many new pages one alert lists.
+	maxAlertPages = 20
+)
+
+// alertNotifiers deliver alerts by channel. Email is only available when
+// SMTP_ADDR is set; see config.go.
+var alertNotifiers = map[string]alertNotifier{
+	alertChannelWebhook: newWebhookNotifier(),
+}
+
+// publicBaseURL is where users reach the site, for links in alerts. Set
+// from PUBLIC_URL in config.go.
+var publicBaseURL = "http://localhost:8080"
+
+type alertNotifier interface {
+	Notify(ctx context.Context, alert searchAlert) error
+}
+
+// searchAlert is one delivery: new pages matching a saved search.
+type searchAlert struct {
+	// Recipient is an email address or a webhook URL, depending on the
+	// channel.
+	Recipient string
+	Search    savedSearch
+	Pages     []Page
+	// More is how many further matches did not fit in Pages.
+	More int
+}
+
+type alertSubscription struct {
+	ID            int
+	Channel       string
+	Recipient     string
+	LastCheckedAt time.Time
+	Search        savedSearch
+}
+
+type ingestedPage struct {
+	URL       string
+	CreatedAt time.Time
+}
+
+// smtpNotifier emails alerts to the user's address.
+type smtpNotifier struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+func newSMTPNotifier(addr, from, username, password string) smtpNotifier {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	if from == "" {
+		from = "no-reply@" + host
+	}
+	n := smtpNotifier{addr: addr, from: from}
+	if username != "" {
+		n.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return n
+}
+
+func (n smtpNotifier) Notify(_ context.Context, alert searchAlert) error {
+	if err := smtp.SendMail(n.addr, n.auth, n.from, []string{alert.Recipient}, alertEmail(n.from, alert)); err != nil {
+		return fmt.Errorf("error emailing alert: %w", err)
+	}
+	return nil
+}
+
+func alertEmail(from string, alert searchAlert) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", alert.Recipient)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", fmt.Sprintf("New results for %q", alert.Search.Name)))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: 8bit\r\n\r\n")
+
+	fmt.Fprintf(&b, "New pages match your saved search %q:\r\n\r\n", alert.Search.Name)
+	for _, p := range alert.Pages {
+		fmt.Fprintf(&b, "%s\r\n%s\r\n\r\n", p.Title, p.URL)
+	}
+	if alert.More > 0 {
+		fmt.Fprintf(&b, "...and %d more.\r\n\r\n", alert.More)
+	}
+	fmt.Fprintf(&b, "See all results: %s%s\r\n", publicBaseURL, alert.Search.URL())
+	fmt.Fprintf(&b, "Manage your alerts: %s/account/searches\r\n", publicBaseURL)
+	return []byte(b.String())
+}
+
+// webhookNotifier POSTs alerts as JSON to the URL the user gave.
+type webhookNotifier struct {
+	client *http.Client
+}
+
+type webhookPage struct {
+	Title   string `json:"title"`
+	URL     string `json:"url"`
+	Summary string `json:"summary,omitempty"`
+}
+
+type webhookPayload struct {
+	SavedSearch savedSearch   `json:"saved_search"`
+	SearchURL   string        `json:"search_url"`
+	Pages       []webhookPage `json:"pages"`
+	More        int           `json:"more,omitempty"`
+}
+
+func newWebhookNotifier() webhookNotifier {
+	dialer := &net.Dialer{Timeout: 5 * time.Second, Control: refusePrivateAddresses}
+	return webhookNotifier{client: &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: &http.Transport{DialContext: dialer.DialContext},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}}
+}
+
+// refusePrivateAddresses keeps webhooks and the scraper from reaching
+// services on the server's own network, such as Elasticsearch. As a dialer
+// Control it sees the resolved address of every connection, redirects
+// included.
+func refusePrivateAddresses(_, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	addr = addr.Unmap()
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return fmt.Errorf("address %s is not public", addr)
+	}
+	for _, prefix := range nonPublicPrefixes {
+		if prefix.Contains(addr) {
+			return fmt.Errorf("address %s is not public", addr)
+		}
+	}
+	return nil
+}
+
+// nonPublicPrefixes are ranges IsGlobalUnicast and IsPrivate let through
+// that still do not reach the public internet.
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),      // "this network"
+	netip.MustParsePrefix("100.64.0.0/10"),  // carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),   // IETF protocol assignments
+	netip.MustParsePrefix("198.18.0.0/15"),  // benchmarking
+	netip.MustParsePrefix("240.0.0.0/4"),    // reserved
+	netip.MustParsePrefix("64:ff9b::/96"),   // NAT64, can map to private IPv4
+	netip.MustParsePrefix("64:ff9b:1::/48"), // local-use NAT64
+	netip.MustParsePrefix("2001:db8::/32"),  // documentation
+}
+
+func (n webhookNotifier) Notify(ctx context.Context, alert searchAlert) error {
+	payload := webhookPayload{
+		SavedSearch: alert.Search,
+		SearchURL:   publicBaseURL + alert.Search.URL(),
+		Pages:       make([]webhookPage, 0, len(alert.Pages)),
+		More:        alert.More,
+	}
+	for _, p := range alert.Pages {
+		payload.Pages = append(payload.Pages, webhookPage{Title: p.Title, URL: p.URL, Summary: p.Summary})
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", alert.Recipient, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error creating webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := n.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error calling webhook: %w", err)
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(res.Body, 4096))
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("webhook answered %s", res.Status)
+	}
+	return nil
+}
+
+// sendSearchAlerts tells subscribers about pages ingested up to indexedAt,
+// the database time the search index was rebuilt from (see alertWindowEnd). A subscription only moves
+// past new pages once their alert is delivered, so failed deliveries are
+// tried again after the next indexing run.
+func sendSearchAlerts(ctx context.Context, indexedAt time.Time) {
+	subs, err := loadAlertSubscriptions()
+	if err != nil {
+		log.Printf("%v", err)
+		return
+	}
+	if len(subs) == 0 {
+		return
+	}
+
+	oldest := subs[0].LastCheckedAt
+	for _, sub := range subs[1:] {
+		if sub.LastCheckedAt.Before(oldest) {
+			oldest = sub.LastCheckedAt
+		}
+	}
+	fresh, err := pagesIngestedBetween(oldest, indexedAt)
+	if err != nil {
+		log.Printf("%v", err)
+		return
+	}
+
+	for _, sub := range subs {
+		if err := checkSearchAlert(ctx, sub, fresh, indexedAt); err != nil {
+			log.Printf("Error checking search alert %d: %v", sub.ID, err)
+		}
+	}
+}
+
+func checkSearchAlert(ctx context.Context, sub alertSubscription, fresh []ingestedPage, indexedAt time.Time) error {
+	var urls []string
+	for _, p := range fresh {
+		if p.CreatedAt.After(sub.LastCheckedAt) {
+			urls = append(urls, p.URL)
+		}
+	}
+	if len(urls) == 0 {
+		return nil
+	}
+
+	matches, err := matchingPages(ctx, sub.Search.Query, sub.Search.Filters["language"], urls)
+	if err != nil {
+		return err
+	}
+	if len(matches) == 0 {
+		return markAlertChecked(sub.ID, indexedAt, false)
+	}
+
+	notifier, ok := alertNotifiers[sub.Channel]
+	if !ok {
+		return fmt.Errorf("no notifier is set up for %s alerts", sub.Channel)
+	}
+	alert := searchAlert{Recipient: sub.Recipient, Search: sub.Search, Pages: matches}
+	if len(matches) > maxAlertPages {
+		alert.Pages, alert.More = matches[:maxAlertPages], len(matches)-maxAlertPages
+	}
+	if err := notifier.Notify(ctx, alert); err != nil {
+		return err
+	}
+	return markAlertChecked(sub.ID, indexedAt, true)
+}
+
+// matchingPages returns the pages among urls that query finds, best match
+// first.
+func matchingPages(ctx context.Context, query, language string, urls []string) ([]Page, error) {
+	if esClient == nil {
+		pages, err := searchPagesInEs(query, language)
+		if err != nil {
+			return nil, err
+		}
+		wanted := make(map[string]bool, len(urls))
+		for _, u := range urls {
+			wanted[u] = true
+		}
+		var matches []Page
+		for _, p := range pages {
+			if wanted[p.URL] {
+				matches = append(matches, p)
+			}
+		}
+		return matches, nil
+	}
+
+	body, err := json.Marshal(map[string]any{
+		"query": map[string]any{"bool": map[string]any{
+			"must":   searchQuery(query, language, nil, nil),
+			"filter": map[string]any{"terms": map[string]any{"url": urls}},
+		}},
+		"size":    len(urls),
+		"_source": []string{"title", "url", "summary"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	res, err := esClient.Search(
+		esClient.Search.WithContext(ctx),
+		esClient.Search.WithIndex("pages"),
+		esClient.Search.WithBody(bytes.NewReader(body)),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error searching new pages: %w", err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return nil, fmt.Errorf("error response when searching new pages: %s", res.String())
+	}
+
+	var r struct {
+		Hits struct {
+			Hits []struct {
+				Source Page `json:"_source"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+		return nil, err
+	}
+	var matches []Page
+	for _, hit := range r.Hits.Hits {
+		matches = append(matches, hit.Source)
+	}
+	return matches, nil
+}
+
+func loadAlertSubscriptions() ([]alertSubscription, error) {
+	rows, err := db.Query(`
+		SELECT a.id, a.channel, CASE WHEN a.channel = 'email' THEN u.email ELSE a.webhook_url END, a.last_checked_at,
+		       s.id, s.name, s.query, s.filters, s.created_at
+		FROM search_alerts a
+		JOIN saved_searches s ON s.id = a.saved_search_id
+		JOIN users u ON u.id = s.user_id
+		ORDER BY a.id
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("error loading search alerts: %w", err)
+	}
+	defer rows.Close()
+
+	var subs []alertSubscription
+	for rows.Next() {
+		var sub alertSubscription
+		var filters []byte
+		if err := rows.Scan(&sub.ID, &sub.Channel, &sub.Recipient, &sub.LastCheckedAt,
+			&sub.Search.ID, &sub.Search.Name, &sub.Search.Query, &filters, &sub.Search.CreatedAt); err != nil {
+			return nil, fmt.Errorf("error scanning search alert: %w", err)
+		}
+		if sub.Search.Filters, err = decodeFilters(filters); err != nil {
+			return nil, err
+		}
+		sub.Search.AlertChannel = sub.Channel
+		subs = append(subs, sub)
+	}
+	return subs, rows.Err()
+}
+
+// pagesIngestedBetween lists the pages first stored after since and no
+// later than until.
+func pagesIngestedBetween(since, until time.Time) ([]ingestedPage, error) {
+	rows, err := db.Query(`
+		SELECT url, created_at FROM pages WHERE created_at > $1 AND created_at <= $2 AND gone_at IS NULL
+	`, since, until)
+	if err != nil {
+		return nil, fmt.Errorf("error loading new pages: %w", err)
+	}
+	defer rows.Close()
+
+	var pages []ingestedPage
+	for rows.Next() {
+		var p ingestedPage
+		if err := rows.Scan(&p.URL, &p.CreatedAt); err != nil {
+			return nil, fmt.Errorf("error scanning new page: %w", err)
+		}
+		pages = append(pages, p)
+	}
+	return pages, rows.Err()
+}
+
+// alertWindowEnd returns the database's current time, taken before the
+// search index is rebuilt. Alert windows are compared with pages.created_at,
+// so they come from the database clock rather than the app's.
+func alertWindowEnd() (time.Time, error) {
+	var now time.Time
+	if err := db.QueryRow("SELECT NOW()").Scan(&now); err != nil {
+		return now, fmt.Errorf("error reading database time: %w", err)
+	}
+	return now, nil
+}
+
+func markAlertChecked(id int, checkedAt time.Time, sent bool) error {
+	query := "UPDATE search_alerts SET last_checked_at = $2 WHERE id = $1"
+	if sent {
+		query = "UPDATE search_alerts SET last_checked_at = $2, last_sent_at = NOW() WHERE id = $1"
+	}
+	if _, err := db.Exec(query, id, checkedAt); err != nil {
+		return fmt.Errorf("error saving search alert %d: %w", id, err)
+	}
+	return nil
+}
+
+// setSearchAlert subscribes one of the user's saved searches to alerts, or
+// changes how an existing subscription is delivered. It returns
+// sql.ErrNoRows when the user has no saved search with that ID.
+func setSearchAlert(userID, savedSearchID int, channel, webhookURL string) error {
+	res, err := db.Exec(`
+		INSERT INTO search_alerts (saved_search_id, channel, webhook_url)
+		SELECT id, $3, $4 FROM saved_searches WHERE id = $1 AND user_id = $2
+		ON CONFLICT (saved_search_id) DO UPDATE
+		SET channel = EXCLUDED.channel, webhook_url = EXCLUDED.webhook_url
+	`, savedSearchID, userID, channel, sql.NullString{String: webhookURL, Valid: webhookURL != ""})
+	if err != nil {
+		return fmt.Errorf("error saving alert for saved search %d: %w", savedSearchID, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func deleteSearchAlert(userID, savedSearchID int) error {
+	_, err := db.Exec(`
+		DELETE FROM search_alerts a USING saved_searches s
+		WHERE a.saved_search_id = s.id AND s.id = $1 AND s.user_id = $2
+	`, savedSearchID, userID)
+	if err != nil {
+		return fmt.Errorf("error removing alert for saved search %d: %w", savedSearchID, err)
+	}
+	return nil
+}
+
+// searchAlertHandler subscribes a saved search to alerts about new pages.
+// POST /api/account/saved-searches/{id}/alert (channel=email|webhook, webhook_url)
+func searchAlertHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := sessionUserID(r)
+	if !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid saved search ID", http.StatusBadRequest)
+		return
+	}
+
+	channel := r.FormValue("channel")
+	var webhookURL string
+	switch channel {
+	case alertChannelEmail:
+	case alertChannelWebhook:
+		var valid bool
+		if webhookURL, valid = validPageURL(r.FormValue("webhook_url")); !valid {
+			http.Error(w, "Webhook alerts need a full http:// or https:// address", http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "Channel must be email or webhook", http.StatusBadRequest)
+		return
+	}
+	if _, ok := alertNotifiers[channel]; !ok {
+		http.Error(w, fmt.Sprintf("%s alerts are not available on this server", channel), http.StatusBadRequest)
+		return
+	}
+
+	err = setSearchAlert(userID, id, channel, webhookURL)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Saved search not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("%v", err)
+		http.Error(w, "Error saving alert", http.StatusInternalServerError)
+		return
+	}
+	message := "You will be alerted about new pages matching this search."
+	http.Redirect(w, r, "/account/searches?message="+template.URLQueryEscaper(message), http.StatusSeeOther)
+}
+
+// deleteSearchAlertHandler stops alerts for a saved search.
+// POST /api/account/saved-searches/{id}/alert/delete
+func deleteSearchAlertHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := sessionUserID(r)
+	if !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid saved search ID", http.StatusBadRequest)
+		return
+	}
+	if err := deleteSearchAlert(userID, id); err != nil {
+		log.Printf("%v", err)
+		http.Error(w, "Error removing alert", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/account/searches?message="+template.URLQueryEscaper("Alerts stopped."), http.StatusSeeOther)
+}
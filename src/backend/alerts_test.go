@@ -0,0 +1,321 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+// smtpMessage is what fakeSMTPServer received for one mail.
+type smtpMessage struct {
+	From string
+	To   []string
+	Data string
+}
+
+// fakeSMTPServer accepts mail on a local port, speaking just enough SMTP for
+// net/smtp, and returns its address and the messages it receives.
+func fakeSMTPServer(t *testing.T) (string, <-chan smtpMessage) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	messages := make(chan smtpMessage, 10)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveSMTP(conn, messages)
+		}
+	}()
+	return ln.Addr().String(), messages
+}
+
+func serveSMTP(conn net.Conn, messages chan<- smtpMessage) {
+	defer conn.Close()
+	text := textproto.NewConn(conn)
+	text.PrintfLine("220 localhost fake SMTP")
+
+	var msg smtpMessage
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+		verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch verb {
+		case "EHLO", "HELO":
+			text.PrintfLine("250 localhost")
+		case "MAIL":
+			msg = smtpMessage{From: strings.Trim(strings.TrimPrefix(line, "MAIL FROM:"), "<>")}
+			text.PrintfLine("250 OK")
+		case "RCPT":
+			msg.To = append(msg.To, strings.Trim(strings.TrimPrefix(line, "RCPT TO:"), "<>"))
+			text.PrintfLine("250 OK")
+		case "DATA":
+			text.PrintfLine("354 Go ahead")
+			data, err := text.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			msg.Data = string(data)
+			messages <- msg
+			text.PrintfLine("250 Queued")
+		case "QUIT":
+			text.PrintfLine("221 Bye")
+			return
+		default:
+			text.PrintfLine("250 OK")
+		}
+	}
+}
+
+func testAlert(recipient string) searchAlert {
+	return searchAlert{
+		Recipient: recipient,
+		Search:    savedSearch{ID: 7, Name: "Danish Go", Query: "go", Filters: map[string]string{"language": "da"}},
+		Pages: []Page{
+			{Title: "Go (programmeringssprog)", URL: "https://da.wikipedia.org/wiki/Go_(programmeringssprog)", Summary: "Go er et sprog"},
+		},
+		More: 2,
+	}
+}
+
+func TestSMTPNotifier(t *testing.T) {
+	addr, messages := fakeSMTPServer(t)
+	notifier := newSMTPNotifier(addr, "alerts@gosearch.test", "", "")
+
+	assert.NoError(t, notifier.Notify(context.Background(), testAlert("ada@example.com")))
+
+	select {
+	case msg := <-messages:
+		assert.Equal(t, "alerts@gosearch.test", msg.From)
+		assert.Equal(t, []string{"ada@example.com"}, msg.To)
+		assert.Contains(t, msg.Data, `Subject: New results for "Danish Go"`)
+		assert.Contains(t, msg.Data, "https://da.wikipedia.org/wiki/Go_(programmeringssprog)")
+		assert.Contains(t, msg.Data, "...and 2 more.")
+		assert.Contains(t, msg.Data, publicBaseURL+"/search?language=da&q=go")
+	case <-time.After(5 * time.Second):
+		t.Fatal("no mail arrived")
+	}
+}
+
+func TestAlertEmailEncodesSubject(t *testing.T) {
+	alert := testAlert("ada@example.com")
+	alert.Search.Name = "København\r\nBcc: eve@example.com"
+	headers, _, _ := strings.Cut(string(alertEmail("alerts@gosearch.test", alert)), "\r\n\r\n")
+	assert.NotContains(t, headers, "\r\nBcc:")
+	assert.Contains(t, headers, "Subject: =?utf-8?q?")
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	var got webhookPayload
+	status := http.StatusNoContent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+	notifier := webhookNotifier{client: server.Client()}
+
+	assert.NoError(t, notifier.Notify(context.Background(), testAlert(server.URL+"/hook")))
+	assert.Equal(t, "Danish Go", got.SavedSearch.Name)
+	assert.Equal(t, publicBaseURL+"/search?language=da&q=go", got.SearchURL)
+	if assert.Len(t, got.Pages, 1) {
+		assert.Equal(t, "Go er et sprog", got.Pages[0].Summary)
+	}
+	assert.Equal(t, 2, got.More)
+
+	status = http.StatusInternalServerError
+	assert.Error(t, notifier.Notify(context.Background(), testAlert(server.URL+"/hook")))
+
+	// The real notifier does not call addresses on the local network.
+	status = http.StatusNoContent
+	assert.Error(t, newWebhookNotifier().Notify(context.Background(), testAlert(server.URL+"/hook")))
+}
+
+type fakeNotifier struct {
+	alerts []searchAlert
+	err    error
+}
+
+func (n *fakeNotifier) Notify(_ context.Context, alert searchAlert) error {
+	n.alerts = append(n.alerts, alert)
+	return n.err
+}
+
+func TestSendSearchAlerts(t *testing.T) {
+	mockDB, mock := setupMockDB()
+	defer mockDB.Close()
+	notifier := &fakeNotifier{}
+	previous := alertNotifiers
+	alertNotifiers = map[string]alertNotifier{alertChannelEmail: notifier}
+	defer func() { alertNotifiers = previous }()
+
+	indexedAt := time.Now()
+	checked := indexedAt.Add(-time.Hour)
+	subColumns := []string{"id", "channel", "recipient", "last_checked_at",
+		"search_id", "name", "query", "filters", "created_at"}
+	mock.ExpectQuery("FROM search_alerts a").
+		WillReturnRows(sqlmock.NewRows(subColumns).
+			AddRow(1, alertChannelEmail, "ada@example.com", checked, 7, "Go", "golang", nil, checked).
+			AddRow(2, alertChannelEmail, "bob@example.com", checked.Add(-time.Hour), 8, "Rust", "rust", nil, checked).
+			AddRow(3, alertChannelWebhook, "https://example.com/hook", checked, 9, "Go", "golang", nil, checked))
+	mock.ExpectQuery("SELECT url, created_at FROM pages").WithArgs(checked.Add(-time.Hour), indexedAt).
+		WillReturnRows(sqlmock.NewRows([]string{"url", "created_at"}).
+			AddRow("https://go.dev/", checked.Add(time.Minute)).
+			AddRow("https://www.rust-lang.org/", checked.Add(-time.Minute)))
+
+	// Alert 1 sees go.dev, which is also in the database search results.
+	mock.ExpectQuery("SELECT title, url, content").WithArgs("%golang%").
+		WillReturnRows(sqlmock.NewRows([]string{"title", "url", "content", "cluster_id"}).
+			AddRow("Go", "https://go.dev/", "golang", "https://go.dev/").
+			AddRow("Old Go", "https://golang.org/", "golang", "https://golang.org/"))
+	mock.ExpectExec("UPDATE search_alerts SET last_checked_at = \\$2, last_sent_at = NOW\\(\\)").
+		WithArgs(1, indexedAt).WillReturnResult(sqlmock.NewResult(0, 1))
+	// Alert 2 also sees the Rust page, but does not match go.dev.
+	mock.ExpectQuery("SELECT title, url, content").WithArgs("%rust%").
+		WillReturnRows(sqlmock.NewRows([]string{"title", "url", "content", "cluster_id"}))
+	mock.ExpectExec("UPDATE search_alerts SET last_checked_at = \\$2 WHERE").
+		WithArgs(2, indexedAt).WillReturnResult(sqlmock.NewResult(0, 1))
+	// Alert 3 matches, but no webhook notifier is set up, so it stays where
+	// it was and is tried again next time.
+	mock.ExpectQuery("SELECT title, url, content").WithArgs("%golang%").
+		WillReturnRows(sqlmock.NewRows([]string{"title", "url", "content", "cluster_id"}).
+			AddRow("Go", "https://go.dev/", "golang", "https://go.dev/"))
+
+	sendSearchAlerts(context.Background(), indexedAt)
+
+	if assert.Len(t, notifier.alerts, 1) {
+		alert := notifier.alerts[0]
+		assert.Equal(t, "ada@example.com", alert.Recipient)
+		assert.Equal(t, "Go", alert.Search.Name)
+		if assert.Len(t, alert.Pages, 1) {
+			assert.Equal(t, "https://go.dev/", alert.Pages[0].URL)
+		}
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSendSearchAlertsRetriesFailedDeliveries(t *testing.T) {
+	mockDB, mock := setupMockDB()
+	defer mockDB.Close()
+	notifier := &fakeNotifier{err: errors.New("connection refused")}
+	previous := alertNotifiers
+	alertNotifiers = map[string]alertNotifier{alertChannelEmail: notifier}
+	defer func() { alertNotifiers = previous }()
+
+	indexedAt := time.Now()
+	checked := indexedAt.Add(-time.Hour)
+	mock.ExpectQuery("FROM search_alerts a").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "channel", "recipient", "last_checked_at",
+			"search_id", "name", "query", "filters", "created_at"}).
+			AddRow(1, alertChannelEmail, "ada@example.com", checked, 7, "Go", "golang", nil, checked))
+	mock.ExpectQuery("SELECT url, created_at FROM pages").
+		WillReturnRows(sqlmock.NewRows([]string{"url", "created_at"}).AddRow("https://go.dev/", indexedAt))
+	mock.ExpectQuery("SELECT title, url, content").
+		WillReturnRows(sqlmock.NewRows([]string{"title", "url", "content", "cluster_id"}).
+			AddRow("Go", "https://go.dev/", "golang", "https://go.dev/"))
+
+	sendSearchAlerts(context.Background(), indexedAt)
+
+	assert.Len(t, notifier.alerts, 1)
+	assert.NoError(t, mock.ExpectationsWereMet(), "A failed delivery must not mark the alert as checked")
+}
+
+func TestSearchAlertHandler(t *testing.T) {
+	previous := alertNotifiers
+	alertNotifiers = map[string]alertNotifier{alertChannelWebhook: &fakeNotifier{}}
+	defer func() { alertNotifiers = previous }()
+
+	testCases := []struct {
+		name       string
+		body       string
+		mockSetup  func(mock sqlmock.Sqlmock)
+		wantStatus int
+	}{
+		{
+			name: "Subscribes a webhook",
+			body: "channel=webhook&webhook_url=https://example.com/hook",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec("INSERT INTO search_alerts").
+					WithArgs(7, 3, alertChannelWebhook, sql.NullString{String: "https://example.com/hook", Valid: true}).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+			},
+			wantStatus: http.StatusSeeOther,
+		},
+		{
+			name: "Refuses other users' saved searches",
+			body: "channel=webhook&webhook_url=https://example.com/hook",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec("INSERT INTO search_alerts").WillReturnResult(sqlmock.NewResult(0, 0))
+			},
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "Requires a webhook address",
+			body:       "channel=webhook&webhook_url=ftp://example.com/",
+			mockSetup:  func(mock sqlmock.Sqlmock) {},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Rejects channels without a notifier",
+			body:       "channel=email",
+			mockSetup:  func(mock sqlmock.Sqlmock) {},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Rejects unknown channels",
+			body:       "channel=sms",
+			mockSetup:  func(mock sqlmock.Sqlmock) {},
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockDB, mock := setupMockDB()
+			defer mockDB.Close()
+			tc.mockSetup(mock)
+
+			req := loggedInRequest(t, "POST", "/api/account/saved-searches/7/alert", tc.body, 3)
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req = mux.SetURLVars(req, map[string]string{"id": "7"})
+			rr := httptest.NewRecorder()
+			searchAlertHandler(rr, req)
+
+			assert.Equal(t, tc.wantStatus, rr.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestAlertWindowEndUsesDatabaseClock(t *testing.T) {
+	mockDB, mock := setupMockDB()
+	defer mockDB.Close()
+
+	dbNow := time.Now().Add(-time.Hour)
+	mock.ExpectQuery("SELECT NOW\\(\\)").WillReturnRows(sqlmock.NewRows([]string{"now"}).AddRow(dbNow))
+
+	end, err := alertWindowEnd()
+	assert.NoError(t, err)
+	assert.Equal(t, dbNow, end)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
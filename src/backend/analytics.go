@@ -0,0 +1,410 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"html/template"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Search analytics for admins, computed from recorded search events: what
+// people search for, what finds nothing, and how fast searches are.
+
+const (
+	defaultAnalyticsWindow = "7d"
+	defaultAnalyticsLimit  = 20
+	maxAnalyticsLimit      = 500
+)
+
+// analyticsWindows are the selectable time windows and the bucket size of
+// their queries-over-time series.
+var analyticsWindows = map[string]struct {
+	Span   time.Duration
+	Bucket string
+}{
+	"24h": {24 * time.Hour, "hour"},
+	"7d":  {7 * 24 * time.Hour, "day"},
+	"30d": {30 * 24 * time.Hour, "day"},
+	"90d": {90 * 24 * time.Hour, "day"},
+}
+
+type queryCount struct {
+	Query      string    `json:"query"`
+	Count      int       `json:"count"`
+	AvgResults float64   `json:"avg_results"`
+	LastSeen   time.Time `json:"last_seen"`
+}
+
+type searchTimeBucket struct {
+	Start       time.Time `json:"start"`
+	Searches    int       `json:"searches"`
+	ZeroResults int       `json:"zero_results"`
+}
+
+type latencyPercentiles struct {
+	P50 float64 `json:"p50_ms"`
+	P90 float64 `json:"p90_ms"`
+	P99 float64 `json:"p99_ms"`
+}
+
+type searchAnalytics struct {
+	Window            string             `json:"window"`
+	Since             time.Time          `json:"since"`
+	TotalSearches     int                `json:"total_searches"`
+	UniqueQueries     int                `json:"unique_queries"`
+	AvgResults        float64            `json:"avg_results"`
+	ZeroResultRate    float64            `json:"zero_result_rate"`
+	Latency           latencyPercentiles `json:"latency"`
+	TopQueries        []queryCount       `json:"top_queries"`
+	ZeroResultQueries []queryCount       `json:"zero_result_queries"`
+	OverTime          []searchTimeBucket `json:"over_time"`
+	// ClickThrough has impressions from the event store; clicks and rates
+	// are added from search_clicks by addClickThrough.
+	ClickThrough []positionCTR `json:"click_through"`
+}
+
+// searchAnalyticsSource is implemented by event stores that can report on
+// their events.
+type searchAnalyticsSource interface {
+	Analytics(since time.Time, bucket string, limit int) (*searchAnalytics, error)
+}
+
+// Analytics aggregates search_events in the database.
+func (postgresEventStore) Analytics(since time.Time, bucket string, limit int) (*searchAnalytics, error) {
+	a := &searchAnalytics{Since: since, ClickThrough: newPositionCTRs()}
+	err := db.QueryRow(`
+		SELECT COUNT(*), COUNT(DISTINCT normalized_query),
+		       COALESCE(AVG(result_count), 0), COALESCE(AVG(CASE WHEN result_count = 0 THEN 1 ELSE 0 END), 0),
+		       COALESCE(percentile_cont(0.5) WITHIN GROUP (ORDER BY latency_ms), 0),
+		       COALESCE(percentile_cont(0.9) WITHIN GROUP (ORDER BY latency_ms), 0),
+		       COALESCE(percentile_cont(0.99) WITHIN GROUP (ORDER BY latency_ms), 0)
+		FROM search_events WHERE created_at >= $1
+	`, since).Scan(&a.TotalSearches, &a.UniqueQueries, &a.AvgResults, &a.ZeroResultRate,
+		&a.Latency.P50, &a.Latency.P90, &a.Latency.P99)
+	if err != nil {
+		return nil, fmt.Errorf("error summarizing search events: %w", err)
+	}
+
+	if a.TopQueries, err = queryCounts(`
+		SELECT normalized_query, COUNT(*), AVG(result_count), MAX(created_at)
+		FROM search_events WHERE created_at >= $1 AND normalized_query <> ''
+		GROUP BY normalized_query ORDER BY COUNT(*) DESC, normalized_query LIMIT $2
+	`, since, limit); err != nil {
+		return nil, err
+	}
+	if a.ZeroResultQueries, err = queryCounts(`
+		SELECT normalized_query, COUNT(*), 0, MAX(created_at)
+		FROM search_events WHERE created_at >= $1 AND result_count = 0 AND normalized_query <> ''
+		GROUP BY normalized_query ORDER BY COUNT(*) DESC, normalized_query LIMIT $2
+	`, since, limit); err != nil {
+		return nil, err
+	}
+
+	impressions, err := db.Query(`
+		SELECT p.position, COUNT(*)
+		FROM search_events e CROSS JOIN LATERAL generate_series(1, LEAST(e.result_count, $2)) AS p(position)
+		WHERE e.created_at >= $1
+		GROUP BY p.position
+	`, since, ctrPositions)
+	if err != nil {
+		return nil, fmt.Errorf("error counting result impressions: %w", err)
+	}
+	defer impressions.Close()
+	for impressions.Next() {
+		var position, count int
+		if err := impressions.Scan(&position, &count); err != nil {
+			return nil, fmt.Errorf("error scanning result impressions: %w", err)
+		}
+		a.ClickThrough[position-1].Impressions = count
+	}
+	if err := impressions.Err(); err != nil {
+		return nil, fmt.Errorf("error counting result impressions: %w", err)
+	}
+
+	rows, err := db.Query(`
+		SELECT date_trunc($2, created_at) AS bucket, COUNT(*), COUNT(*) FILTER (WHERE result_count = 0)
+		FROM search_events WHERE created_at >= $1
+		GROUP BY bucket ORDER BY bucket
+	`, since, bucket)
+	if err != nil {
+		return nil, fmt.Errorf("error counting searches over time: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var b searchTimeBucket
+		if err := rows.Scan(&b.Start, &b.Searches, &b.ZeroResults); err != nil {
+			return nil, fmt.Errorf("error scanning search counts: %w", err)
+		}
+		a.OverTime = append(a.OverTime, b)
+	}
+	return a, rows.Err()
+}
+
+func queryCounts(query string, since time.Time, limit int) ([]queryCount, error) {
+	rows, err := db.Query(query, since, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error counting queries: %w", err)
+	}
+	defer rows.Close()
+
+	var counts []queryCount
+	for rows.Next() {
+		var c queryCount
+		if err := rows.Scan(&c.Query, &c.Count, &c.AvgResults, &c.LastSeen); err != nil {
+			return nil, fmt.Errorf("error scanning query count: %w", err)
+		}
+		counts = append(counts, c)
+	}
+	return counts, rows.Err()
+}
+
+// Analytics reads the whole event file, skipping lines in the old text
+// format, which have no result counts.
+func (s *jsonlEventStore) Analytics(since time.Time, bucket string, limit int) (*searchAnalytics, error) {
+	events, err := s.readEvents(func(ev searchEvent) bool { return !ev.Time.Before(since) })
+	if err != nil {
+		return nil, err
+	}
+	return aggregateSearchEvents(events, since, bucket, limit), nil
+}
+
+// aggregateSearchEvents computes the same figures as the SQL in
+// postgresEventStore.Analytics, for stores without a query engine.
+func aggregateSearchEvents(events []searchEvent, since time.Time, bucket string, limit int) *searchAnalytics {
+	a := &searchAnalytics{Since: since, TotalSearches: len(events), ClickThrough: newPositionCTRs()}
+	if len(events) == 0 {
+		return a
+	}
+
+	type queryStats struct {
+		count, zero, results int
+		lastSeen, lastZero   time.Time
+	}
+	stats := make(map[string]*queryStats)
+	buckets := make(map[time.Time]*searchTimeBucket)
+	latencies := make([]float64, 0, len(events))
+	totalResults, zeroResults := 0, 0
+
+	for _, ev := range events {
+		totalResults += ev.ResultCount
+		for p := 0; p < ev.ResultCount && p < ctrPositions; p++ {
+			a.ClickThrough[p].Impressions++
+		}
+		latencies = append(latencies, float64(ev.LatencyMS))
+
+		start := ev.Time.UTC().Truncate(time.Hour)
+		if bucket == "day" {
+			start = time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, time.UTC)
+		}
+		b := buckets[start]
+		if b == nil {
+			b = &searchTimeBucket{Start: start}
+			buckets[start] = b
+		}
+		b.Searches++
+		if ev.ResultCount == 0 {
+			zeroResults++
+			b.ZeroResults++
+		}
+
+		q := stats[ev.NormalizedQuery]
+		if q == nil {
+			q = &queryStats{}
+			stats[ev.NormalizedQuery] = q
+		}
+		q.count++
+		q.results += ev.ResultCount
+		q.lastSeen = maxTime(q.lastSeen, ev.Time)
+		if ev.ResultCount == 0 {
+			q.zero++
+			q.lastZero = maxTime(q.lastZero, ev.Time)
+		}
+	}
+
+	a.UniqueQueries = len(stats)
+	a.AvgResults = float64(totalResults) / float64(len(events))
+	a.ZeroResultRate = float64(zeroResults) / float64(len(events))
+	sort.Float64s(latencies)
+	a.Latency = latencyPercentiles{
+		P50: percentileCont(latencies, 0.5),
+		P90: percentileCont(latencies, 0.9),
+		P99: percentileCont(latencies, 0.99),
+	}
+
+	for query, q := range stats {
+		if query == "" {
+			continue
+		}
+		a.TopQueries = append(a.TopQueries, queryCount{
+			Query: query, Count: q.count, AvgResults: float64(q.results) / float64(q.count), LastSeen: q.lastSeen,
+		})
+		if q.zero > 0 {
+			a.ZeroResultQueries = append(a.ZeroResultQueries, queryCount{Query: query, Count: q.zero, LastSeen: q.lastZero})
+		}
+	}
+	a.TopQueries = topQueryCounts(a.TopQueries, limit)
+	a.ZeroResultQueries = topQueryCounts(a.ZeroResultQueries, limit)
+
+	for _, b := range buckets {
+		a.OverTime = append(a.OverTime, *b)
+	}
+	sort.Slice(a.OverTime, func(i, j int) bool { return a.OverTime[i].Start.Before(a.OverTime[j].Start) })
+	return a
+}
+
+// topQueryCounts orders by count, then query, and keeps the first limit.
+func topQueryCounts(counts []queryCount, limit int) []queryCount {
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		return counts[i].Query < counts[j].Query
+	})
+	if len(counts) > limit {
+		counts = counts[:limit]
+	}
+	return counts
+}
+
+// percentileCont interpolates between the closest ranks of sorted values,
+// like PostgreSQL's percentile_cont.
+func percentileCont(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	pos := p * float64(len(sorted)-1)
+	lower := int(math.Floor(pos))
+	upper := int(math.Ceil(pos))
+	return sorted[lower] + (sorted[upper]-sorted[lower])*(pos-float64(lower))
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+	return a
+}
+
+// analyticsParams reads the window and limit query parameters.
+func analyticsParams(r *http.Request) (window string, since time.Time, bucket string, limit int, err error) {
+	window = r.URL.Query().Get("window")
+	if window == "" {
+		window = defaultAnalyticsWindow
+	}
+	w, ok := analyticsWindows[window]
+	if !ok {
+		return "", time.Time{}, "", 0, fmt.Errorf("unknown window %q, use 24h, 7d, 30d or 90d", window)
+	}
+
+	limit = defaultAnalyticsLimit
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		limit, err = strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > maxAnalyticsLimit {
+			return "", time.Time{}, "", 0, fmt.Errorf("limit must be between 1 and %d", maxAnalyticsLimit)
+		}
+	}
+	return window, time.Now().Add(-w.Span), w.Bucket, limit, nil
+}
+
+// loadAnalytics runs the report for a request, writing an error response
+// and returning nil when that is not possible.
+func loadAnalytics(w http.ResponseWriter, r *http.Request) *searchAnalytics {
+	window, since, bucket, limit, err := analyticsParams(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil
+	}
+	source, ok := searchEvents.(searchAnalyticsSource)
+	if !ok {
+		http.Error(w, "Search analytics are not available for this event store", http.StatusNotImplemented)
+		return nil
+	}
+
+	a, err := source.Analytics(since, bucket, limit)
+	if err != nil {
+		log.Printf("Error computing search analytics: %v", err)
+		http.Error(w, "Error computing search analytics", http.StatusInternalServerError)
+		return nil
+	}
+	a.Window = window
+	return a
+}
+
+// analyticsHandler returns the full analytics report.
+// GET /api/admin/analytics?window=7d&limit=20
+func analyticsHandler(w http.ResponseWriter, r *http.Request) {
+	a := loadAnalytics(w, r)
+	if a == nil {
+		return
+	}
+	if err := addClickThrough(a); err != nil {
+		log.Printf("Error computing click-through rates: %v", err)
+		http.Error(w, "Error computing search analytics", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, a)
+}
+
+// zeroResultsHandler lists the queries that found nothing, most frequent
+// first, as JSON or, with format=csv, as a spreadsheet-friendly download.
+// GET /api/admin/analytics/zero-results?window=30d&limit=100&format=csv
+func zeroResultsHandler(w http.ResponseWriter, r *http.Request) {
+	a := loadAnalytics(w, r)
+	if a == nil {
+		return
+	}
+	if r.URL.Query().Get("format") != "csv" {
+		writeJSON(w, http.StatusOK, map[string]any{
+			"window":  a.Window,
+			"since":   a.Since,
+			"queries": a.ZeroResultQueries,
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="zero-results-%s.csv"`, a.Window))
+	out := csv.NewWriter(w)
+	out.Write([]string{"query", "searches", "last_seen"})
+	for _, q := range a.ZeroResultQueries {
+		out.Write([]string{q.Query, strconv.Itoa(q.Count), q.LastSeen.UTC().Format(time.RFC3339)})
+	}
+	out.Flush()
+}
+
+// analyticsPageHandler shows the analytics report to admins.
+// GET /admin/analytics?window=7d
+func analyticsPageHandler(w http.ResponseWriter, r *http.Request) {
+	a := loadAnalytics(w, r)
+	if a == nil {
+		return
+	}
+	if err := addClickThrough(a); err != nil {
+		log.Printf("Error computing click-through rates: %v", err)
+		http.Error(w, "Error computing search analytics", http.StatusInternalServerError)
+		return
+	}
+
+	tmpl, err := template.ParseFiles(templatePath+"layout.html", templatePath+"analytics.html")
+	if err != nil {
+		log.Printf("Error parsing templates: %v", err)
+		http.Error(w, "Error loading templates", http.StatusInternalServerError)
+		return
+	}
+	data := map[string]any{
+		"Title":             "Search analytics",
+		"UserLoggedIn":      true,
+		"Windows":           []string{"24h", "7d", "30d", "90d"},
+		"Analytics":         a,
+		"ZeroResultPercent": a.ZeroResultRate * 100,
+	}
+	if err := tmpl.ExecuteTemplate(w, "layout.html", data); err != nil {
+		log.Printf("Error executing template: %v", err)
+		http.Error(w, "Error rendering page", http.StatusInternalServerError)
+	}
+}
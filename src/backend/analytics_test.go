@@ -0,0 +1,124 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAggregateSearchEvents(t *testing.T) {
+	day := time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)
+	events := []searchEvent{
+		{Time: day.Add(9 * time.Hour), NormalizedQuery: "go", ResultCount: 4, LatencyMS: 10},
+		{Time: day.Add(10 * time.Hour), NormalizedQuery: "go", ResultCount: 2, LatencyMS: 20},
+		{Time: day.Add(11 * time.Hour), NormalizedQuery: "xyzzy", ResultCount: 0, LatencyMS: 30},
+		{Time: day.Add(33 * time.Hour), NormalizedQuery: "xyzzy", ResultCount: 0, LatencyMS: 40},
+		{Time: day.Add(34 * time.Hour), NormalizedQuery: "rust", ResultCount: 0, LatencyMS: 100},
+	}
+
+	a := aggregateSearchEvents(events, day, "day", 2)
+
+	assert.Equal(t, 5, a.TotalSearches)
+	assert.Equal(t, 3, a.UniqueQueries)
+	assert.InDelta(t, 1.2, a.AvgResults, 1e-9)
+	assert.InDelta(t, 0.6, a.ZeroResultRate, 1e-9)
+	assert.Equal(t, latencyPercentiles{P50: 30, P90: 76, P99: 97.6}, roundLatency(a.Latency))
+	assert.Equal(t, []queryCount{
+		{Query: "go", Count: 2, AvgResults: 3, LastSeen: day.Add(10 * time.Hour)},
+		{Query: "xyzzy", Count: 2, AvgResults: 0, LastSeen: day.Add(33 * time.Hour)},
+	}, a.TopQueries, "Ties are broken alphabetically and the list is cut at the limit")
+	assert.Equal(t, []queryCount{
+		{Query: "xyzzy", Count: 2, LastSeen: day.Add(33 * time.Hour)},
+		{Query: "rust", Count: 1, LastSeen: day.Add(34 * time.Hour)},
+	}, a.ZeroResultQueries)
+	assert.Equal(t, []searchTimeBucket{
+		{Start: day, Searches: 3, ZeroResults: 1},
+		{Start: day.Add(24 * time.Hour), Searches: 2, ZeroResults: 2},
+	}, a.OverTime)
+}
+
+func roundLatency(l latencyPercentiles) latencyPercentiles {
+	round := func(v float64) float64 { return float64(int(v*10+0.5)) / 10 }
+	return latencyPercentiles{P50: round(l.P50), P90: round(l.P90), P99: round(l.P99)}
+}
+
+func TestAnalyticsHandler(t *testing.T) {
+	previous := searchEvents
+	defer func() { searchEvents = previous }()
+
+	searchEvents = postgresEventStore{}
+	w := httptest.NewRecorder()
+	analyticsHandler(w, httptest.NewRequest("GET", "/api/admin/analytics?window=1y", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = httptest.NewRecorder()
+	analyticsHandler(w, httptest.NewRequest("GET", "/api/admin/analytics?limit=0", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	searchEvents = &fakeEventStore{}
+	w = httptest.NewRecorder()
+	analyticsHandler(w, httptest.NewRequest("GET", "/api/admin/analytics", nil))
+	assert.Equal(t, http.StatusNotImplemented, w.Code)
+
+	mockDB, mock := setupMockDB()
+	defer mockDB.Close()
+	searchEvents = postgresEventStore{}
+	now := time.Now()
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\), COUNT\\(DISTINCT normalized_query\\)").WithArgs(sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"total", "unique", "avg", "zero", "p50", "p90", "p99"}).
+			AddRow(10, 4, 2.5, 0.2, 12.0, 40.0, 95.0))
+	mock.ExpectQuery("GROUP BY normalized_query").WithArgs(sqlmock.AnyArg(), 5).
+		WillReturnRows(sqlmock.NewRows([]string{"query", "count", "avg", "last"}).AddRow("go", 6, 3.0, now))
+	mock.ExpectQuery("result_count = 0 AND normalized_query").WithArgs(sqlmock.AnyArg(), 5).
+		WillReturnRows(sqlmock.NewRows([]string{"query", "count", "avg", "last"}).AddRow("xyzzy", 2, 0, now))
+	mock.ExpectQuery("generate_series").WithArgs(sqlmock.AnyArg(), ctrPositions).
+		WillReturnRows(sqlmock.NewRows([]string{"position", "count"}).AddRow(1, 8).AddRow(2, 5))
+	mock.ExpectQuery("date_trunc").WithArgs(sqlmock.AnyArg(), "hour").
+		WillReturnRows(sqlmock.NewRows([]string{"bucket", "searches", "zero"}).AddRow(now.Truncate(time.Hour), 10, 2))
+	mock.ExpectQuery("FROM search_clicks").WithArgs(sqlmock.AnyArg(), ctrPositions).
+		WillReturnRows(sqlmock.NewRows([]string{"position", "count"}).AddRow(1, 2))
+
+	w = httptest.NewRecorder()
+	analyticsHandler(w, httptest.NewRequest("GET", "/api/admin/analytics?window=24h&limit=5", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"window":"24h"`)
+	assert.Contains(t, w.Body.String(), `"latency":{"p50_ms":12,"p90_ms":40,"p99_ms":95}`)
+	assert.Contains(t, w.Body.String(), `"zero_result_queries":[{"query":"xyzzy","count":2`)
+	assert.Contains(t, w.Body.String(), `"click_through":[{"position":1,"impressions":8,"clicks":2,"ctr":0.25},{"position":2,"impressions":5,"clicks":0,"ctr":0}`)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestZeroResultsCSV(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "search.log")
+	appendLog(t, path, "SEARCH: 2025/05/01 10:00:00 query=\"legacy\" from=1.2.3.4:1\n")
+	store, err := newJSONLEventStore(path)
+	assert.NoError(t, err)
+	defer store.Close()
+
+	previous := searchEvents
+	searchEvents = store
+	defer func() { searchEvents = previous }()
+
+	seen := time.Now().UTC().Truncate(time.Second).Add(-time.Hour)
+	old := seen.Add(-60 * 24 * time.Hour)
+	for _, ev := range []searchEvent{
+		{Time: seen, Query: "Xyzzy", NormalizedQuery: "xyzzy", ResultCount: 0},
+		{Time: seen, Query: "go", NormalizedQuery: "go", ResultCount: 3},
+		{Time: old, Query: "ancient", NormalizedQuery: "ancient", ResultCount: 0},
+	} {
+		assert.NoError(t, store.Record(ev))
+	}
+
+	w := httptest.NewRecorder()
+	zeroResultsHandler(w, httptest.NewRequest("GET", "/api/admin/analytics/zero-results?window=30d&format=csv", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/csv; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Equal(t, "query,searches,last_seen\nxyzzy,1,"+seen.Format(time.RFC3339)+"\n", w.Body.String(),
+		"Only zero-result queries inside the window are listed")
+}
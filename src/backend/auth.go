@@ -80,6 +80,16 @@ func userIsLoggedIn(r *http.Request) bool {
 	return ok && userID != nil
 }
 
+// sessionUserID returns the ID of the logged-in user, if any.
+func sessionUserID(r *http.Request) (int, bool) {
+	session, err := store.Get(r, "session-name")
+	if err != nil {
+		return 0, false
+	}
+	userID, ok := session.Values["user_id"].(int)
+	return userID, ok
+}
+
 // simpel email validering indtil videre kun med .com. skal udvides.
 func isValidEmail(email string) bool {
 	email = strings.TrimSpace(email) // Fjern mellemrum
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Search result links go through /r, which records the click and then
+// redirects to the result. Links are signed so /r cannot be used to send
+// people to arbitrary sites.
+
+// ctrPositions is how many result positions click-through rates are
+// reported for.
+const ctrPositions = 10
+
+// clickSigningKey signs result links. Set in config.go.
+var clickSigningKey []byte
+
+type searchClick struct {
+	Time            time.Time
+	Query           string
+	NormalizedQuery string
+	URL             string
+	// Position is the 1-based rank of the result on the results page.
+	Position     int
+	UserID       int
+	ClientIPAnon string
+}
+
+// positionCTR is the click-through rate of one result position: the share
+// of searches showing a result there in which that result was opened.
+type positionCTR struct {
+	Position    int     `json:"position"`
+	Impressions int     `json:"impressions"`
+	Clicks      int     `json:"clicks"`
+	CTR         float64 `json:"ctr"`
+}
+
+// CTRPercent is the rate as a percentage, for display.
+func (p positionCTR) CTRPercent() float64 {
+	return p.CTR * 100
+}
+
+// resultClicks is how often a result was opened for one query.
+type resultClicks struct {
+	URL         string    `json:"url"`
+	Clicks      int       `json:"clicks"`
+	AvgPosition float64   `json:"avg_position"`
+	LastClicked time.Time `json:"last_clicked"`
+}
+
+// clickURL is the tracked link for the result at position for query.
+func clickURL(query, target string, position int) string {
+	v := url.Values{
+		"q":   {query},
+		"u":   {target},
+		"pos": {strconv.Itoa(position)},
+		"sig": {signClick(query, target, position)},
+	}
+	return "/r?" + v.Encode()
+}
+
+func signClick(query, target string, position int) string {
+	mac := hmac.New(sha256.New, clickSigningKey)
+	fmt.Fprintf(mac, "%s\x00%s\x00%d", query, target, position)
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// clickRedirectHandler records a click on a search result and sends the
+// browser on to it. A click that cannot be saved is logged; the redirect
+// still happens.
+// GET /r?q=go&u=https://go.dev/&pos=1&sig=...
+func clickRedirectHandler(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	query, target := params.Get("q"), params.Get("u")
+	position, err := strconv.Atoi(params.Get("pos"))
+	if err != nil || position < 1 || (!strings.HasPrefix(target, "http://") && !strings.HasPrefix(target, "https://")) ||
+		!hmac.Equal([]byte(params.Get("sig")), []byte(signClick(query, target, position))) {
+		http.Error(w, "Invalid result link", http.StatusBadRequest)
+		return
+	}
+
+	click := searchClick{
+		Time:            time.Now().UTC(),
+		Query:           query,
+		NormalizedQuery: normalizeSearchTerm(query),
+		URL:             target,
+		Position:        position,
+	}
+	click.UserID, click.ClientIPAnon = searchIdentity(r)
+	if err := recordClick(click); err != nil {
+		log.Printf("Error recording search click: %v", err)
+	}
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+func recordClick(c searchClick) error {
+	_, err := db.Exec(`
+		INSERT INTO search_clicks (created_at, query, normalized_query, url, position, user_id, client_ip_anon)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, c.Time, c.Query, c.NormalizedQuery, c.URL, c.Position,
+		sql.NullInt64{Int64: int64(c.UserID), Valid: c.UserID != 0},
+		sql.NullString{String: c.ClientIPAnon, Valid: c.ClientIPAnon != ""})
+	if err != nil {
+		return fmt.Errorf("error saving search click: %w", err)
+	}
+	return nil
+}
+
+func newPositionCTRs() []positionCTR {
+	ctr := make([]positionCTR, ctrPositions)
+	for i := range ctr {
+		ctr[i].Position = i + 1
+	}
+	return ctr
+}
+
+// addClickThrough counts the clicks on each position since a.Since and
+// works out the rates against the impressions the event store counted.
+func addClickThrough(a *searchAnalytics) error {
+	if a.ClickThrough == nil {
+		a.ClickThrough = newPositionCTRs()
+	}
+	rows, err := db.Query(`
+		SELECT position, COUNT(*) FROM search_clicks
+		WHERE created_at >= $1 AND position <= $2
+		GROUP BY position
+	`, a.Since, ctrPositions)
+	if err != nil {
+		return fmt.Errorf("error counting search clicks: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var position, clicks int
+		if err := rows.Scan(&position, &clicks); err != nil {
+			return fmt.Errorf("error scanning search clicks: %w", err)
+		}
+		a.ClickThrough[position-1].Clicks = clicks
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error counting search clicks: %w", err)
+	}
+
+	for i := range a.ClickThrough {
+		if p := &a.ClickThrough[i]; p.Impressions > 0 {
+			p.CTR = float64(p.Clicks) / float64(p.Impressions)
+		}
+	}
+	return nil
+}
+
+// queryResultClicks lists the results opened for a normalized query since
+// the given time, most clicked first.
+func queryResultClicks(query string, since time.Time, limit int) ([]resultClicks, error) {
+	rows, err := db.Query(`
+		SELECT url, COUNT(*), AVG(position), MAX(created_at) FROM search_clicks
+		WHERE normalized_query = $1 AND created_at >= $2
+		GROUP BY url ORDER BY COUNT(*) DESC, url LIMIT $3
+	`, query, since, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error loading clicks for %q: %w", query, err)
+	}
+	defer rows.Close()
+
+	results := []resultClicks{}
+	for rows.Next() {
+		var c resultClicks
+		if err := rows.Scan(&c.URL, &c.Clicks, &c.AvgPosition, &c.LastClicked); err != nil {
+			return nil, fmt.Errorf("error scanning clicks for %q: %w", query, err)
+		}
+		results = append(results, c)
+	}
+	return results, rows.Err()
+}
+
+// queryClicksHandler returns which results were opened for a query.
+// GET /api/admin/analytics/clicks?q=go&window=30d&limit=20
+func queryClicksHandler(w http.ResponseWriter, r *http.Request) {
+	query := normalizeSearchTerm(r.URL.Query().Get("q"))
+	if query == "" {
+		http.Error(w, "No query provided", http.StatusBadRequest)
+		return
+	}
+	window, since, _, limit, err := analyticsParams(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	results, err := queryResultClicks(query, since, limit)
+	if err != nil {
+		log.Printf("Error loading query clicks: %v", err)
+		http.Error(w, "Error loading clicks", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"query":   query,
+		"window":  window,
+		"since":   since,
+		"results": results,
+	})
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClickRedirectHandler(t *testing.T) {
+	const target = "https://go.dev/doc/"
+	link := clickURL("Go  Docs", target, 2)
+
+	testCases := []struct {
+		name       string
+		link       string
+		mockSetup  func(mock sqlmock.Sqlmock)
+		wantStatus int
+	}{
+		{
+			name: "Records the click and redirects",
+			link: link,
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec("INSERT INTO search_clicks").
+					WithArgs(sqlmock.AnyArg(), "Go  Docs", "go docs", target, 2, sql.NullInt64{}, sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+			},
+			wantStatus: http.StatusFound,
+		},
+		{
+			name: "Redirects even when the click cannot be saved",
+			link: link,
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec("INSERT INTO search_clicks").WillReturnError(errors.New("database is down"))
+			},
+			wantStatus: http.StatusFound,
+		},
+		{
+			name:       "Tampered target",
+			link:       strings.Replace(link, url.QueryEscape(target), url.QueryEscape("https://evil.example/"), 1),
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Missing signature",
+			link:       "/r?pos=1&q=go&u=https%3A%2F%2Fgo.dev%2F",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Signed but not a web address",
+			link:       clickURL("go", "javascript:alert(1)", 1),
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockDB, mock := setupMockDB()
+			defer mockDB.Close()
+			if tc.mockSetup != nil {
+				tc.mockSetup(mock)
+			}
+
+			w := httptest.NewRecorder()
+			clickRedirectHandler(w, loggedInRequest(t, "GET", tc.link, "", 0))
+
+			assert.Equal(t, tc.wantStatus, w.Code)
+			if tc.wantStatus == http.StatusFound {
+				assert.Equal(t, target, w.Header().Get("Location"))
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestQueryClicksHandler(t *testing.T) {
+	mockDB, mock := setupMockDB()
+	defer mockDB.Close()
+
+	w := httptest.NewRecorder()
+	queryClicksHandler(w, httptest.NewRequest("GET", "/api/admin/analytics/clicks", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	clicked := time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("FROM search_clicks").WithArgs("go docs", sqlmock.AnyArg(), 20).
+		WillReturnRows(sqlmock.NewRows([]string{"url", "count", "avg", "last"}).
+			AddRow("https://go.dev/doc/", 3, 1.5, clicked))
+
+	w = httptest.NewRecorder()
+	queryClicksHandler(w, httptest.NewRequest("GET", "/api/admin/analytics/clicks?q=Go+Docs&window=30d", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"query":"go docs"`)
+	assert.Contains(t, w.Body.String(),
+		`"results":[{"url":"https://go.dev/doc/","clicks":3,"avg_position":1.5,"last_clicked":"2026-10-19T09:00:00Z"}]`)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
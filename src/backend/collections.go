@@ -0,0 +1,572 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// Logged-in users can bookmark pages into named collections with a note on
+// each, search within a collection, and share one through a read-only link
+// that works without logging in.
+
+const (
+	maxCollectionName = 100
+	maxBookmarkNote   = 1000
+)
+
+type collection struct {
+	ID         int       `json:"id"`
+	Name       string    `json:"name"`
+	ShareToken string    `json:"share_token,omitempty"`
+	Pages      int       `json:"pages"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// ShareURL is the public link to the collection, or "" when it is not
+// shared.
+func (c collection) ShareURL() string {
+	if c.ShareToken == "" {
+		return ""
+	}
+	return publicBaseURL + "/shared/collections/" + c.ShareToken
+}
+
+type bookmark struct {
+	URL     string    `json:"url"`
+	Title   string    `json:"title"`
+	Summary string    `json:"summary,omitempty"`
+	Note    string    `json:"note,omitempty"`
+	AddedAt time.Time `json:"added_at"`
+}
+
+func loadCollections(userID int) ([]collection, error) {
+	rows, err := db.Query(`
+		SELECT c.id, c.name, COALESCE(c.share_token, ''), COUNT(cp.id), c.updated_at
+		FROM collections c LEFT JOIN collection_pages cp ON cp.collection_id = c.id
+		WHERE c.user_id = $1
+		GROUP BY c.id
+		ORDER BY c.name
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error loading collections of user %d: %w", userID, err)
+	}
+	defer rows.Close()
+
+	collections := []collection{}
+	for rows.Next() {
+		var c collection
+		if err := rows.Scan(&c.ID, &c.Name, &c.ShareToken, &c.Pages, &c.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("error scanning collection: %w", err)
+		}
+		collections = append(collections, c)
+	}
+	return collections, rows.Err()
+}
+
+// loadCollection returns one of the user's collections, or sql.ErrNoRows.
+func loadCollection(userID, id int) (collection, error) {
+	var c collection
+	err := db.QueryRow(`
+		SELECT id, name, COALESCE(share_token, ''), updated_at FROM collections WHERE id = $1 AND user_id = $2
+	`, id, userID).Scan(&c.ID, &c.Name, &c.ShareToken, &c.UpdatedAt)
+	if err != nil && err != sql.ErrNoRows {
+		return c, fmt.Errorf("error loading collection %d: %w", id, err)
+	}
+	return c, err
+}
+
+// loadSharedCollection returns the collection shared under token and the
+// name of its owner, or sql.ErrNoRows.
+func loadSharedCollection(token string) (collection, string, error) {
+	var c collection
+	var owner string
+	err := db.QueryRow(`
+		SELECT c.id, c.name, c.share_token, c.updated_at, u.username
+		FROM collections c JOIN users u ON u.id = c.user_id
+		WHERE c.share_token = $1
+	`, token).Scan(&c.ID, &c.Name, &c.ShareToken, &c.UpdatedAt, &owner)
+	if err != nil && err != sql.ErrNoRows {
+		return c, "", fmt.Errorf("error loading shared collection: %w", err)
+	}
+	return c, owner, err
+}
+
+// loadBookmarks returns the pages in a collection, newest first. A non-empty
+// query keeps only pages whose title, content or note contain it.
+func loadBookmarks(collectionID int, query string) ([]bookmark, error) {
+	sqlStmt := `
+		SELECT p.url, p.title, COALESCE(p.summary, ''), cp.note, cp.created_at
+		FROM collection_pages cp JOIN pages p ON p.url = cp.page_url
+		WHERE cp.collection_id = $1`
+	args := []any{collectionID}
+	if query != "" {
+		sqlStmt += ` AND (p.title ILIKE $2 ESCAPE '\' OR p.content ILIKE $2 ESCAPE '\' OR cp.note ILIKE $2 ESCAPE '\')`
+		args = append(args, "%"+likeEscaper.Replace(query)+"%")
+	}
+	sqlStmt += " ORDER BY cp.created_at DESC"
+
+	rows, err := db.Query(sqlStmt, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error loading pages of collection %d: %w", collectionID, err)
+	}
+	defer rows.Close()
+
+	bookmarks := []bookmark{}
+	for rows.Next() {
+		var b bookmark
+		if err := rows.Scan(&b.URL, &b.Title, &b.Summary, &b.Note, &b.AddedAt); err != nil {
+			return nil, fmt.Errorf("error scanning bookmark: %w", err)
+		}
+		bookmarks = append(bookmarks, b)
+	}
+	return bookmarks, rows.Err()
+}
+
+// likeEscaper makes % and _ in a search match literally in a LIKE pattern.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// sqlRunner is satisfied by both *sql.DB and *sql.Tx.
+type sqlRunner interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// collectionNamed returns the ID of the user's collection called name,
+// creating it if there is none.
+func collectionNamed(q sqlRunner, userID int, name string) (int, error) {
+	var id int
+	err := q.QueryRow(`
+		INSERT INTO collections (user_id, name) VALUES ($1, $2)
+		ON CONFLICT (user_id, name) DO UPDATE SET updated_at = collections.updated_at
+		RETURNING id
+	`, userID, name).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("error saving collection %q: %w", name, err)
+	}
+	return id, nil
+}
+
+// bookmarkPage adds a stored page to one of the user's collections, or
+// updates its note if it is already there. The collection is given by ID
+// or, when id is 0, by name, and is then created as needed in the same
+// transaction, so a failed bookmark leaves no empty collection behind. It
+// returns the collection's ID, or sql.ErrNoRows when the collection is not
+// the user's or the page is not in the index.
+func bookmarkPage(userID, id int, name, url, note string) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if id == 0 {
+		if id, err = collectionNamed(tx, userID, name); err != nil {
+			return 0, err
+		}
+	}
+	res, err := tx.Exec(`
+		INSERT INTO collection_pages (collection_id, page_url, note)
+		SELECT c.id, p.url, $4
+		FROM collections c, pages p
+		WHERE c.id = $1 AND c.user_id = $2 AND p.url = $3 AND p.gone_at IS NULL
+		ON CONFLICT (collection_id, page_url) DO UPDATE SET note = EXCLUDED.note
+	`, id, userID, url, note)
+	if err != nil {
+		return 0, fmt.Errorf("error bookmarking %s: %w", url, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return 0, sql.ErrNoRows
+	}
+	if err := touchCollection(tx, userID, id); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error committing bookmark: %w", err)
+	}
+	return id, nil
+}
+
+func removeBookmark(userID, collectionID int, url string) error {
+	res, err := db.Exec(`
+		DELETE FROM collection_pages cp USING collections c
+		WHERE cp.collection_id = c.id AND c.id = $1 AND c.user_id = $2 AND cp.page_url = $3
+	`, collectionID, userID, url)
+	if err != nil {
+		return fmt.Errorf("error removing %s from collection %d: %w", url, collectionID, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return nil
+	}
+	return touchCollection(db, userID, collectionID)
+}
+
+func touchCollection(q sqlRunner, userID, id int) error {
+	if _, err := q.Exec("UPDATE collections SET updated_at = NOW() WHERE id = $1 AND user_id = $2", id, userID); err != nil {
+		return fmt.Errorf("error updating collection %d: %w", id, err)
+	}
+	return nil
+}
+
+func deleteCollection(userID, id int) error {
+	_, err := db.Exec("DELETE FROM collections WHERE id = $1 AND user_id = $2", id, userID)
+	if err != nil {
+		return fmt.Errorf("error deleting collection %d: %w", id, err)
+	}
+	return nil
+}
+
+// setCollectionShared gives a collection a new share token, or removes it
+// so the old link stops working.
+func setCollectionShared(userID, id int, shared bool) error {
+	token := sql.NullString{}
+	if shared {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			return err
+		}
+		token = sql.NullString{String: hex.EncodeToString(b), Valid: true}
+	}
+	res, err := db.Exec("UPDATE collections SET share_token = $3 WHERE id = $1 AND user_id = $2", id, userID, token)
+	if err != nil {
+		return fmt.Errorf("error sharing collection %d: %w", id, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// collectionNames lists the names of the user's collections, for the
+// bookmark form on the results page.
+func collectionNames(userID int) ([]string, error) {
+	var names []string
+	err := db.QueryRow(`
+		SELECT COALESCE(array_agg(name ORDER BY updated_at DESC), '{}') FROM collections WHERE user_id = $1
+	`, userID).Scan(pq.Array(&names))
+	if err != nil {
+		return nil, fmt.Errorf("error loading collections of user %d: %w", userID, err)
+	}
+	return names, nil
+}
+
+func validCollectionName(raw string) (string, bool) {
+	name := strings.TrimSpace(raw)
+	return name, name != "" && len([]rune(name)) <= maxCollectionName
+}
+
+func collectionID(r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	return id, err == nil
+}
+
+func redirectToCollection(w http.ResponseWriter, r *http.Request, id int, message string) {
+	target := fmt.Sprintf("/collections/%d", id)
+	if message != "" {
+		target += "?message=" + template.URLQueryEscaper(message)
+	}
+	http.Redirect(w, r, target, http.StatusSeeOther)
+}
+
+func renderCollectionPage(w http.ResponseWriter, page string, data map[string]any) {
+	tmpl, err := template.ParseFiles(templatePath+"layout.html", templatePath+page)
+	if err != nil {
+		log.Printf("Error parsing templates: %v", err)
+		http.Error(w, "Error loading templates", http.StatusInternalServerError)
+		return
+	}
+	if err := tmpl.ExecuteTemplate(w, "layout.html", data); err != nil {
+		log.Printf("Error executing template: %v", err)
+		http.Error(w, "Error rendering page", http.StatusInternalServerError)
+	}
+}
+
+// collectionsPageHandler lists the user's collections.
+// GET /collections
+func collectionsPageHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := sessionUserID(r)
+	if !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	collections, err := loadCollections(userID)
+	if err != nil {
+		log.Printf("%v", err)
+		http.Error(w, "Error loading collections", http.StatusInternalServerError)
+		return
+	}
+	renderCollectionPage(w, "collections.html", map[string]any{
+		"Title":        "Collections",
+		"UserLoggedIn": true,
+		"Collections":  collections,
+		"Message":      r.URL.Query().Get("message"),
+	})
+}
+
+// collectionPageHandler shows one of the user's collections.
+// GET /collections/{id}?q=
+func collectionPageHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := sessionUserID(r)
+	if !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	id, _ := collectionID(r)
+	c, err := loadCollection(userID, id)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Printf("%v", err)
+		http.Error(w, "Error loading collection", http.StatusInternalServerError)
+		return
+	}
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	bookmarks, err := loadBookmarks(c.ID, query)
+	if err != nil {
+		log.Printf("%v", err)
+		http.Error(w, "Error loading collection", http.StatusInternalServerError)
+		return
+	}
+	renderCollectionPage(w, "collection.html", map[string]any{
+		"Title":        c.Name,
+		"UserLoggedIn": true,
+		"Collection":   c,
+		"Bookmarks":    bookmarks,
+		"Query":        query,
+		"SearchAction": fmt.Sprintf("/collections/%d", c.ID),
+		"Message":      r.URL.Query().Get("message"),
+	})
+}
+
+// sharedCollectionPageHandler shows a shared collection to anyone with the
+// link. It cannot be changed from here.
+// GET /shared/collections/{token}?q=
+func sharedCollectionPageHandler(w http.ResponseWriter, r *http.Request) {
+	token := mux.Vars(r)["token"]
+	c, owner, err := loadSharedCollection(token)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Printf("%v", err)
+		http.Error(w, "Error loading collection", http.StatusInternalServerError)
+		return
+	}
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	bookmarks, err := loadBookmarks(c.ID, query)
+	if err != nil {
+		log.Printf("%v", err)
+		http.Error(w, "Error loading collection", http.StatusInternalServerError)
+		return
+	}
+	renderCollectionPage(w, "collection.html", map[string]any{
+		"Title":        c.Name,
+		"UserLoggedIn": userIsLoggedIn(r),
+		"Collection":   c,
+		"Bookmarks":    bookmarks,
+		"Query":        query,
+		"SearchAction": "/shared/collections/" + c.ShareToken,
+		"ReadOnly":     true,
+		"Owner":        owner,
+	})
+}
+
+// collectionsHandler returns the user's collections.
+// GET /api/collections
+func collectionsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := sessionUserID(r)
+	if !ok {
+		http.Error(w, "Not logged in", http.StatusUnauthorized)
+		return
+	}
+	collections, err := loadCollections(userID)
+	if err != nil {
+		log.Printf("%v", err)
+		http.Error(w, "Error loading collections", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"collections": collections})
+}
+
+// collectionHandler returns one of the user's collections with its pages.
+// GET /api/collections/{id}?q=
+func collectionHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := sessionUserID(r)
+	if !ok {
+		http.Error(w, "Not logged in", http.StatusUnauthorized)
+		return
+	}
+	id, _ := collectionID(r)
+	c, err := loadCollection(userID, id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Collection not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("%v", err)
+		http.Error(w, "Error loading collection", http.StatusInternalServerError)
+		return
+	}
+	bookmarks, err := loadBookmarks(c.ID, strings.TrimSpace(r.URL.Query().Get("q")))
+	if err != nil {
+		log.Printf("%v", err)
+		http.Error(w, "Error loading collection", http.StatusInternalServerError)
+		return
+	}
+	c.Pages = len(bookmarks)
+	writeJSON(w, http.StatusOK, map[string]any{"collection": c, "pages": bookmarks})
+}
+
+// createCollectionHandler creates a collection, or opens the user's
+// collection of that name if there already is one.
+// POST /api/collections (name)
+func createCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := sessionUserID(r)
+	if !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	name, valid := validCollectionName(r.FormValue("name"))
+	if !valid {
+		http.Error(w, fmt.Sprintf("A name of at most %d characters is required", maxCollectionName), http.StatusBadRequest)
+		return
+	}
+	id, err := collectionNamed(db, userID, name)
+	if err != nil {
+		log.Printf("%v", err)
+		http.Error(w, "Error saving collection", http.StatusInternalServerError)
+		return
+	}
+	redirectToCollection(w, r, id, "")
+}
+
+// deleteCollectionHandler deletes one of the user's collections.
+// POST /api/collections/{id}/delete
+func deleteCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := sessionUserID(r)
+	if !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	id, _ := collectionID(r)
+	if err := deleteCollection(userID, id); err != nil {
+		log.Printf("%v", err)
+		http.Error(w, "Error deleting collection", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/collections?message="+template.URLQueryEscaper("Collection deleted."), http.StatusSeeOther)
+}
+
+// bookmarkHandler adds a page to a collection, creating the collection when
+// it is given by a name the user has not used yet. Adding a page that is
+// already there updates its note.
+// POST /api/bookmarks (url, collection, note)
+// POST /api/collections/{id}/pages (url, note)
+func bookmarkHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := sessionUserID(r)
+	if !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	url := strings.TrimSpace(r.FormValue("url"))
+	note := strings.TrimSpace(r.FormValue("note"))
+	if url == "" {
+		http.Error(w, "A page address is required", http.StatusBadRequest)
+		return
+	}
+	if len([]rune(note)) > maxBookmarkNote {
+		http.Error(w, fmt.Sprintf("Notes can be at most %d characters", maxBookmarkNote), http.StatusBadRequest)
+		return
+	}
+
+	id, byID := collectionID(r)
+	var name string
+	switch {
+	case byID && id < 1:
+		http.Error(w, "No such collection or page", http.StatusNotFound)
+		return
+	case !byID:
+		var valid bool
+		if name, valid = validCollectionName(r.FormValue("collection")); !valid {
+			http.Error(w, fmt.Sprintf("A collection name of at most %d characters is required", maxCollectionName), http.StatusBadRequest)
+			return
+		}
+	}
+
+	id, err := bookmarkPage(userID, id, name, url, note)
+	if err == sql.ErrNoRows {
+		http.Error(w, "No such collection or page", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("%v", err)
+		http.Error(w, "Error saving bookmark", http.StatusInternalServerError)
+		return
+	}
+	redirectToCollection(w, r, id, "Bookmark saved.")
+}
+
+// removeBookmarkHandler takes a page out of a collection.
+// POST /api/collections/{id}/pages/delete (url)
+func removeBookmarkHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := sessionUserID(r)
+	if !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	id, _ := collectionID(r)
+	if err := removeBookmark(userID, id, r.FormValue("url")); err != nil {
+		log.Printf("%v", err)
+		http.Error(w, "Error removing bookmark", http.StatusInternalServerError)
+		return
+	}
+	redirectToCollection(w, r, id, "Bookmark removed.")
+}
+
+// shareCollectionHandler creates a new public link for a collection, or
+// turns sharing off.
+// POST /api/collections/{id}/share (shared=on|off)
+func shareCollectionHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := sessionUserID(r)
+	if !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	id, _ := collectionID(r)
+	var shared bool
+	var message string
+	switch r.FormValue("shared") {
+	case "on":
+		shared, message = true, "Anyone with the link can now view this collection."
+	case "off":
+		shared, message = false, "The collection is private again; the old link no longer works."
+	default:
+		http.Error(w, "shared must be on or off", http.StatusBadRequest)
+		return
+	}
+
+	err := setCollectionShared(userID, id, shared)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Collection not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("%v", err)
+		http.Error(w, "Error sharing collection", http.StatusInternalServerError)
+		return
+	}
+	redirectToCollection(w, r, id, message)
+}
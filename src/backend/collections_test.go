@@ -0,0 +1,261 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBookmarkHandler(t *testing.T) {
+	const pageURL = "https://go.dev/"
+
+	testCases := []struct {
+		name       string
+		target     string
+		vars       map[string]string
+		body       string
+		mockSetup  func(mock sqlmock.Sqlmock)
+		wantStatus int
+		wantTarget string
+	}{
+		{
+			name:   "Bookmarks into a collection by name, creating it",
+			target: "/api/bookmarks",
+			body:   "url=" + pageURL + "&collection=+Reading+list+&note=Start+here",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery("INSERT INTO collections").WithArgs(3, "Reading list").
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(5))
+				mock.ExpectExec("INSERT INTO collection_pages").WithArgs(5, 3, pageURL, "Start here").
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectExec("UPDATE collections SET updated_at").WithArgs(5, 3).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+			wantStatus: http.StatusSeeOther,
+			wantTarget: "/collections/5?message=",
+		},
+		{
+			name:   "Updates the note in a collection by ID",
+			target: "/api/collections/5/pages",
+			vars:   map[string]string{"id": "5"},
+			body:   "url=" + pageURL + "&note=Better+note",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec("INSERT INTO collection_pages").WithArgs(5, 3, pageURL, "Better note").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec("UPDATE collections SET updated_at").WithArgs(5, 3).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+			wantStatus: http.StatusSeeOther,
+			wantTarget: "/collections/5?message=",
+		},
+		{
+			name:   "Only bookmarks indexed pages in the user's own collections",
+			target: "/api/collections/6/pages",
+			vars:   map[string]string{"id": "6"},
+			body:   "url=https://example.com/unknown",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec("INSERT INTO collection_pages").WithArgs(6, 3, "https://example.com/unknown", "").
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectRollback()
+			},
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:   "Leaves no new collection behind when the page is unknown",
+			target: "/api/bookmarks",
+			body:   "url=https://example.com/unknown&collection=Reading+list",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery("INSERT INTO collections").WithArgs(3, "Reading list").
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+				mock.ExpectExec("INSERT INTO collection_pages").WithArgs(7, 3, "https://example.com/unknown", "").
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectRollback()
+			},
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "Requires a collection",
+			target:     "/api/bookmarks",
+			body:       "url=" + pageURL,
+			mockSetup:  func(mock sqlmock.Sqlmock) {},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Rejects overly long notes",
+			target:     "/api/bookmarks",
+			body:       "url=" + pageURL + "&collection=Go&note=" + strings.Repeat("a", maxBookmarkNote+1),
+			mockSetup:  func(mock sqlmock.Sqlmock) {},
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockDB, mock := setupMockDB()
+			defer mockDB.Close()
+			tc.mockSetup(mock)
+
+			req := loggedInRequest(t, "POST", tc.target, tc.body, 3)
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tc.vars != nil {
+				req = mux.SetURLVars(req, tc.vars)
+			}
+			rr := httptest.NewRecorder()
+			bookmarkHandler(rr, req)
+
+			assert.Equal(t, tc.wantStatus, rr.Code)
+			assert.True(t, strings.HasPrefix(rr.Header().Get("Location"), tc.wantTarget))
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func bookmarkRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"url", "title", "summary", "note", "added_at"}).
+		AddRow("https://go.dev/", "The Go Programming Language", "Go is open source", "Start here", time.Now())
+}
+
+func TestCollectionPageHandler(t *testing.T) {
+	mockDB, mock := setupMockDB()
+	defer mockDB.Close()
+
+	mock.ExpectQuery("FROM collections WHERE id = \\$1 AND user_id = \\$2").WithArgs(5, 3).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "share_token", "updated_at"}).
+			AddRow(5, "Reading list", "", time.Now()))
+	mock.ExpectQuery("FROM collection_pages cp JOIN pages p").WithArgs(5, `%go\_1%`).
+		WillReturnRows(bookmarkRows())
+
+	req := mux.SetURLVars(loggedInRequest(t, "GET", "/collections/5?q=go_1", "", 3), map[string]string{"id": "5"})
+	rr := httptest.NewRecorder()
+	collectionPageHandler(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	body := rr.Body.String()
+	assert.Contains(t, body, "The Go Programming Language")
+	assert.Contains(t, body, "Start here")
+	assert.Contains(t, body, "/api/collections/5/pages/delete")
+	assert.Contains(t, body, "Create a share link")
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// Someone else's collection.
+	mock.ExpectQuery("FROM collections WHERE id = \\$1 AND user_id = \\$2").WithArgs(6, 3).
+		WillReturnError(sql.ErrNoRows)
+	req = mux.SetURLVars(loggedInRequest(t, "GET", "/collections/6", "", 3), map[string]string{"id": "6"})
+	rr = httptest.NewRecorder()
+	collectionPageHandler(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestRemoveBookmarkHandler(t *testing.T) {
+	mockDB, mock := setupMockDB()
+	defer mockDB.Close()
+
+	mock.ExpectExec("DELETE FROM collection_pages").WithArgs(5, 3, "https://go.dev/").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE collections SET updated_at").WithArgs(5, 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	// Someone else's collection is left alone entirely.
+	mock.ExpectExec("DELETE FROM collection_pages").WithArgs(6, 3, "https://go.dev/").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	for _, id := range []string{"5", "6"} {
+		req := loggedInRequest(t, "POST", "/api/collections/"+id+"/pages/delete", "url=https://go.dev/", 3)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req = mux.SetURLVars(req, map[string]string{"id": id})
+		rr := httptest.NewRecorder()
+		removeBookmarkHandler(rr, req)
+		assert.Equal(t, http.StatusSeeOther, rr.Code)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSharedCollectionPageHandler(t *testing.T) {
+	mockDB, mock := setupMockDB()
+	defer mockDB.Close()
+
+	mock.ExpectQuery("WHERE c.share_token = \\$1").WithArgs("abc123").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "share_token", "updated_at", "username"}).
+			AddRow(5, "Reading list", "abc123", time.Now(), "ada"))
+	mock.ExpectQuery("FROM collection_pages cp JOIN pages p").WithArgs(5).
+		WillReturnRows(bookmarkRows())
+
+	req := mux.SetURLVars(httptest.NewRequest("GET", "/shared/collections/abc123", nil), map[string]string{"token": "abc123"})
+	rr := httptest.NewRecorder()
+	sharedCollectionPageHandler(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	body := rr.Body.String()
+	assert.Contains(t, body, "shared by ada")
+	assert.Contains(t, body, "Start here")
+	assert.NotContains(t, body, "/api/collections/5", "Shared collections are read-only")
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	mock.ExpectQuery("WHERE c.share_token = \\$1").WithArgs("def456").WillReturnError(sql.ErrNoRows)
+	req = mux.SetURLVars(httptest.NewRequest("GET", "/shared/collections/def456", nil), map[string]string{"token": "def456"})
+	rr = httptest.NewRecorder()
+	sharedCollectionPageHandler(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestShareCollectionHandler(t *testing.T) {
+	mockDB, mock := setupMockDB()
+	defer mockDB.Close()
+
+	mock.ExpectExec("UPDATE collections SET share_token = \\$3").
+		WithArgs(5, 3, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE collections SET share_token = \\$3").
+		WithArgs(5, 3, sql.NullString{}).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	for _, shared := range []string{"on", "off"} {
+		req := loggedInRequest(t, "POST", "/api/collections/5/share", "shared="+shared, 3)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req = mux.SetURLVars(req, map[string]string{"id": "5"})
+		rr := httptest.NewRecorder()
+		shareCollectionHandler(rr, req)
+		assert.Equal(t, http.StatusSeeOther, rr.Code)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	c := collection{}
+	assert.Empty(t, c.ShareURL())
+	c.ShareToken = "abc123"
+	assert.Equal(t, publicBaseURL+"/shared/collections/abc123", c.ShareURL())
+}
+
+func TestCollectionsHandler(t *testing.T) {
+	mockDB, mock := setupMockDB()
+	defer mockDB.Close()
+
+	mock.ExpectQuery("FROM collections c LEFT JOIN collection_pages cp").WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "share_token", "pages", "updated_at"}).
+			AddRow(5, "Reading list", "", 2, time.Now()))
+
+	rr := httptest.NewRecorder()
+	collectionsHandler(rr, loggedInRequest(t, "GET", "/api/collections", "", 3))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var body struct {
+		Collections []collection `json:"collections"`
+	}
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &body))
+	if assert.Len(t, body.Collections, 1) {
+		assert.Equal(t, 2, body.Collections[0].Pages)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	rr = httptest.NewRecorder()
+	collectionsHandler(rr, loggedInRequest(t, "GET", "/api/collections", "", 0))
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+}
@@ -4,7 +4,11 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/elastic/go-elasticsearch/v8"
 	"github.com/gorilla/sessions"
@@ -13,8 +17,6 @@ import (
 
 var staticPath = "static"
 
-var searchLogger = log.New(os.Stdout, "SEARCH: ", log.LstdFlags)
-
 var CONN_STR string
 
 var templatePath string
@@ -71,5 +73,103 @@ func init() {
 	}
 
 	store = sessions.NewCookieStore([]byte(sessionSecret))
+	clickSigningKey = []byte(sessionSecret)
+
+	searchIPSalt = os.Getenv("SEARCH_IP_SALT")
+	if searchIPSalt == "" {
+		searchIPSalt = sessionSecret
+	}
+	switch mode := os.Getenv("SEARCH_IP_ANONYMIZATION"); mode {
+	case "":
+	case ipAnonymizeHash, ipAnonymizeTruncate, ipAnonymizeOff:
+		searchIPAnonymization = mode
+	default:
+		log.Printf("Warning: unknown SEARCH_IP_ANONYMIZATION %q, hashing client addresses", mode)
+	}
+	searchEventRetention = getEnvDuration("SEARCH_EVENTS_RETENTION", searchEventRetention)
+
+	if url := os.Getenv("PUBLIC_URL"); url != "" {
+		publicBaseURL = strings.TrimRight(url, "/")
+	}
+	if addr := os.Getenv("SMTP_ADDR"); addr != "" {
+		alertNotifiers[alertChannelEmail] = newSMTPNotifier(addr, os.Getenv("SMTP_FROM"),
+			os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"))
+	}
+
+	scraperPolicy = newCrawlPolicyFromEnv()
+	scraperHTTPClient = &http.Client{
+		Transport:     scraperPolicy,
+		CheckRedirect: checkScraperRedirect,
+		Timeout:       30 * time.Second,
+	}
+	wikiClient = newMediaWikiClientFromEnv(scraperHTTPClient)
+	scrapeMaxAttempts = getEnvInt("SCRAPER_MAX_ATTEMPTS", scrapeMaxAttempts)
+	scrapeRetryBase = getEnvDuration("SCRAPER_RETRY_BASE", scrapeRetryBase)
+	scrapeRetryMax = getEnvDuration("SCRAPER_RETRY_MAX", scrapeRetryMax)
+	scrapeConcurrency = getEnvInt("SCRAPER_CONCURRENCY", scrapeConcurrency)
+	scrapeTermTimeout = getEnvDuration("SCRAPER_TERM_TIMEOUT", scrapeTermTimeout)
+	scrapeTopK = getEnvInt("SCRAPER_TOP_K", scrapeTopK)
+	if mode := os.Getenv("SCRAPER_DISAMBIGUATION"); mode != "" {
+		scrapeDisambiguation = mode
+	}
+	scrapeDisambiguationLinks = getEnvInt("SCRAPER_DISAMBIGUATION_LINKS", scrapeDisambiguationLinks)
+	recrawlMaxAge = getEnvDuration("RECRAWL_MAX_AGE", recrawlMaxAge)
+	recrawlBatchSize = getEnvInt("RECRAWL_BATCH_SIZE", recrawlBatchSize)
+	langDetectMinConfidence = getEnvFloat("LANGDETECT_MIN_CONFIDENCE", langDetectMinConfidence)
+	nearDuplicateMaxDistance = getEnvInt("NEAR_DUPLICATE_MAX_DISTANCE", nearDuplicateMaxDistance)
+	if nearDuplicateMaxDistance > maxNearDuplicateDistance {
+		log.Printf("Warning: NEAR_DUPLICATE_MAX_DISTANCE=%d would miss duplicates, using %d",
+			nearDuplicateMaxDistance, maxNearDuplicateDistance)
+		nearDuplicateMaxDistance = maxNearDuplicateDistance
+	}
+	pageVersionsMax = getEnvInt("PAGE_VERSIONS_MAX", pageVersionsMax)
+	pageVersionsMaxAge = getEnvDuration("PAGE_VERSIONS_MAX_AGE", pageVersionsMaxAge)
+	ingestMaxURLs = getEnvInt("INGEST_MAX_URLS", ingestMaxURLs)
+	submissionsPerDay = getEnvInt("SUBMISSIONS_PER_DAY", submissionsPerDay)
 
 }
+
+// getEnvInt reads an integer environment variable, falling back to def when
+// it is unset or malformed.
+func getEnvInt(name string, def int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Warning: %s=%q is not a valid integer, using %d", name, value, def)
+		return def
+	}
+	return n
+}
+
+// getEnvDuration reads a duration such as "500ms" or "24h" from the
+// environment, falling back to def when it is unset or malformed.
+func getEnvDuration(name string, def time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("Warning: %s=%q is not a valid duration, using %s", name, value, def)
+		return def
+	}
+	return d
+}
+
+// getEnvFloat reads a decimal number such as "0.5" from the environment,
+// falling back to def when it is unset or malformed.
+func getEnvFloat(name string, def float64) float64 {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		log.Printf("Warning: %s=%q is not a valid number, using %g", name, value, def)
+		return def
+	}
+	return f
+}
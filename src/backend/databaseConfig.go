@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
@@ -66,7 +67,7 @@ func closeDB() {
 func checkTables() {
 	// Check users table
 	fmt.Println("\n--- Users in database ---")
-	rows, err := queryDB("SELECT * FROM users")
+	rows, err := queryDB("SELECT id, username, email, password, password_changed, is_admin FROM users")
 	if err != nil {
 		log.Printf("Error querying users: %v", err)
 		return
@@ -75,17 +76,17 @@ func checkTables() {
 
 	for rows.Next() {
 		var user User
-		err := rows.Scan(&user.ID, &user.Username, &user.Email, &user.Password, &user.PasswordChanged)
+		err := rows.Scan(&user.ID, &user.Username, &user.Email, &user.Password, &user.PasswordChanged, &user.IsAdmin)
 		if err != nil {
 			log.Printf("Error scanning user: %v", err)
 			continue
 		}
-		fmt.Printf("ID: %d, Username: %s, Email: %s, Password Changed: %t\n", user.ID, user.Username, user.Email, user.PasswordChanged)
+		fmt.Printf("ID: %d, Username: %s, Email: %s, Password Changed: %t, Admin: %t\n", user.ID, user.Username, user.Email, user.PasswordChanged, user.IsAdmin)
 	}
 
 	// Check pages table
 	fmt.Println("\n--- Pages in database ---")
-	rows2, err := queryDB("SELECT * FROM pages")
+	rows2, err := queryDB("SELECT url, title, language, last_updated, content FROM pages")
 	if err != nil {
 		log.Printf("Error querying pages: %v", err)
 		return
@@ -103,7 +104,7 @@ func checkTables() {
 	}
 }
 
-func startCronScheduler() {
+func startCronScheduler(ctx context.Context) {
 	c := cron.New()
 	// Schedule the checkTables function to run every minute
 	if _, err := c.AddFunc("*/1 * * * *", func() {
@@ -124,44 +125,45 @@ func startCronScheduler() {
 	// scraping wikipedia every 5. minutes
 	if _, err := c.AddFunc("*/5 * * * *", func() {
 		fmt.Println("Cron job: Running Wikipedia scraper at", time.Now())
-		logPath := os.Getenv("SEARCH_LOG_PATH")
-		if logPath == "" {
-			logPath = "search.log"
-		}
-		// Track the number of pages before scraping
-		var countBefore int
-		err := db.QueryRow("SELECT COUNT(*) FROM pages").Scan(&countBefore)
-		if err != nil {
-			log.Printf("Error getting page count before scraping: %v", err)
-		}
-
-		// Run scraping
-		StartScraping(logPath)
-
-		// Check if new pages were added
-		var countAfter int
-		err = db.QueryRow("SELECT COUNT(*) FROM pages").Scan(&countAfter)
-		if err != nil {
-			log.Printf("Error getting page count after scraping: %v", err)
-		}
+		// Scrapes due jobs and indexes whatever they saved.
+		StartScraping(ctx)
+	}); err != nil {
+		log.Fatalf("Error scheduling Wikipedia scraper cron job: %v", err)
+	}
 
-		// Only sync to Elasticsearch if new pages were added
-		if countAfter > countBefore {
-			log.Printf("New pages added (%d -> %d). Syncing to Elasticsearch.", countBefore, countAfter)
-			err := syncPagesToElasticsearch()
-			if err != nil {
+	// Refresh pages that have gone stale
+	recrawlSchedule := os.Getenv("RECRAWL_SCHEDULE")
+	if recrawlSchedule == "" {
+		recrawlSchedule = "30 3 * * *"
+	}
+	if _, err := c.AddFunc(recrawlSchedule, func() {
+		log.Println("Cron job: Recrawling stale pages at", time.Now())
+		stats := recrawlStalePages(ctx)
+		purgeOldPageVersions()
+		if stats.Refreshed > 0 || stats.Gone > 0 {
+			if err := syncPagesToElasticsearch(); err != nil {
 				log.Printf("Error syncing to Elasticsearch: %v", err)
-			} else {
-				log.Println("Synced scraped pages to Elasticsearch successfully.")
 			}
-		} else {
-			log.Println("No new pages added. Skipping Elasticsearch sync.")
 		}
 	}); err != nil {
-		log.Fatalf("Error scheduling Wikipedia scraper cron job: %v", err)
+		log.Fatalf("Error scheduling recrawl cron job: %v", err)
+	}
+
+	// Delete search events and clicks past their retention period
+	purgeSchedule := os.Getenv("SEARCH_PURGE_SCHEDULE")
+	if purgeSchedule == "" {
+		purgeSchedule = "15 4 * * *"
+	}
+	if _, err := c.AddFunc(purgeSchedule, purgeSearchData); err != nil {
+		log.Fatalf("Error scheduling search purge cron job: %v", err)
 	}
 
 	c.Start()
+
+	go func() {
+		<-ctx.Done()
+		<-c.Stop().Done()
+	}()
 }
 
 func backupDatabase() {
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"crypto/tls"
+	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
@@ -13,6 +14,80 @@ import (
 	"github.com/elastic/go-elasticsearch/v8"
 )
 
+// languageAnalyzers maps the languages we detect to the names Elasticsearch
+// uses for their stop words and stemmers. Each page's content is also
+// indexed into content_<lang> so it gets stemming and stop words for its own
+// language.
+var languageAnalyzers = map[string]string{
+	"da": "danish",
+	"de": "german",
+	"en": "english",
+	"es": "spanish",
+	"fi": "finnish",
+	"fr": "french",
+	"it": "italian",
+	"nl": "dutch",
+	"no": "norwegian",
+	"pt": "portuguese",
+	"sv": "swedish",
+}
+
+// pagesIndexMappings defines the 'pages' index. infobox is a flattened
+// object so any infobox field can be filtered on, while infobox_text holds
+// the same values for full-text search.
+func pagesIndexMappings() (string, error) {
+	// Synonyms are only applied when searching, by search analyzers that
+	// read the rules from the synonyms set. Rule changes reload those
+	// analyzers, so they need neither a reindex nor closing the index.
+	text := map[string]string{"type": "text", "search_analyzer": synonymAnalyzerName}
+	properties := map[string]any{
+		"title":          text,
+		"url":            map[string]string{"type": "keyword"},
+		"content":        text,
+		"summary":        text,
+		"sections":       text,
+		"infobox":        map[string]string{"type": "flattened"},
+		"infobox_text":   text,
+		"image_captions": text,
+		"links":          map[string]string{"type": "keyword"},
+		"categories":     map[string]string{"type": "keyword"},
+		"language":       map[string]string{"type": "keyword"},
+		"cluster_id":     map[string]string{"type": "keyword"},
+		"last_updated":   map[string]string{"type": "date"},
+		"published_at":   map[string]string{"type": "date"},
+	}
+
+	filters := map[string]any{synonymFilterName: synonymFilter()}
+	analyzers := map[string]any{synonymAnalyzerName: customAnalyzer("lowercase", synonymFilterName)}
+	for lang, language := range languageAnalyzers {
+		// Custom versions of the language analyzers, so the synonyms can be
+		// applied before stemming when searching.
+		stop, stemmer := lang+"_stop", lang+"_stemmer"
+		filters[stop] = map[string]string{"type": "stop", "stopwords": "_" + language + "_"}
+		filters[stemmer] = map[string]string{"type": "stemmer", "language": language}
+		analyzers["content_"+lang] = customAnalyzer("lowercase", stop, stemmer)
+		analyzers["content_"+lang+"_search"] = customAnalyzer("lowercase", synonymFilterName, stop, stemmer)
+		properties["content_"+lang] = map[string]string{
+			"type":            "text",
+			"analyzer":        "content_" + lang,
+			"search_analyzer": "content_" + lang + "_search",
+		}
+	}
+
+	body, err := json.Marshal(map[string]any{
+		"settings": map[string]any{"analysis": map[string]any{"filter": filters, "analyzer": analyzers}},
+		"mappings": map[string]any{"properties": properties},
+	})
+	if err != nil {
+		return "", fmt.Errorf("error encoding index mappings: %w", err)
+	}
+	return string(body), nil
+}
+
+func customAnalyzer(filters ...string) map[string]any {
+	return map[string]any{"type": "custom", "tokenizer": "standard", "filter": filters}
+}
+
 func initElasticsearch() {
 	var err error
 	maxRetries := 10
@@ -68,6 +143,11 @@ func initElasticsearch() {
 				defer res.Body.Close()
 				log.Printf("Successfully connected to Elasticsearch via %s", config.Addresses[0])
 
+				// The synonyms set has to exist before an index can use it.
+				if err := putSynonymSet(currentSynonymRules()); err != nil {
+					log.Printf("Error creating synonyms set: %v", err)
+				}
+
 				// Check if 'pages' index exists
 				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 				existsRes, err := esClient.Indices.Exists(
@@ -82,19 +162,10 @@ func initElasticsearch() {
 					if existsRes.StatusCode == 404 {
 						log.Println("Creating 'pages' index with proper mappings")
 
-						// Define index mappings with correct field types
-						mappings := `{
-                            "mappings": {
-                                "properties": {
-                                    "title": { "type": "text" },
-                                    "url": { "type": "keyword" },
-                                    "content": { "type": "text" },
-                                    "language": { "type": "keyword" },
-                                    "last_updated": { "type": "date" }
-                                }
-                            }
-                        }`
-
+						mappings, err := pagesIndexMappings()
+						if err != nil {
+							log.Fatalf("%v", err)
+						}
 						ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 						createRes, err := esClient.Indices.Create(
 							"pages",
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Caps that keep a single huge article from bloating the row and the index.
+const (
+	maxInfoboxFields = 50
+	maxImageCaptions = 30
+	maxOutgoingLinks = 500
+)
+
+var footnoteRe = regexp.MustCompile(`\[\d+\]`)
+
+// articleStructure is the structured content pulled out of a rendered
+// article next to its plain text.
+type articleStructure struct {
+	Sections      []string
+	Infobox       map[string]string
+	ImageCaptions []string
+	Links         []string
+}
+
+// firstParagraph returns the lead paragraph of a plain-text extract, used as
+// the search snippet.
+func firstParagraph(text string) string {
+	for _, p := range strings.Split(text, "\n") {
+		if p = strings.TrimSpace(p); p != "" {
+			return p
+		}
+	}
+	return ""
+}
+
+// parseInfobox reads the label/value rows of the first infobox table.
+func parseInfobox(doc *goquery.Document) map[string]string {
+	infobox := make(map[string]string)
+	doc.Find("table.infobox").First().Find("tr").EachWithBreak(func(_ int, row *goquery.Selection) bool {
+		label := cleanText(row.ChildrenFiltered("th").First().Text())
+		value := cleanText(row.ChildrenFiltered("td").First().Text())
+		if label != "" && value != "" {
+			if _, exists := infobox[label]; !exists {
+				infobox[label] = value
+			}
+		}
+		return len(infobox) < maxInfoboxFields
+	})
+	return infobox
+}
+
+// parseImageCaptions collects figure and thumbnail captions.
+func parseImageCaptions(doc *goquery.Document) []string {
+	var captions []string
+	seen := make(map[string]bool)
+	doc.Find("figcaption, .thumbcaption, .gallerytext").EachWithBreak(func(_ int, s *goquery.Selection) bool {
+		caption := cleanText(s.Text())
+		if caption != "" && !seen[caption] {
+			seen[caption] = true
+			captions = append(captions, caption)
+		}
+		return len(captions) < maxImageCaptions
+	})
+	return captions
+}
+
+// htmlText strips markup from a short HTML fragment such as a section
+// heading.
+func htmlText(fragment string) string {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(fragment))
+	if err != nil {
+		return cleanText(fragment)
+	}
+	return cleanText(doc.Text())
+}
+
+// cleanText collapses whitespace and drops footnote markers like "[1]".
+func cleanText(s string) string {
+	s = footnoteRe.ReplaceAllString(s, "")
+	return strings.Join(strings.Fields(s), " ")
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/stretchr/testify/assert"
+)
+
+const articleHTML = `<div class="mw-parser-output">
+<table class="infobox"><tbody>
+<tr><th colspan="2">København</th></tr>
+<tr><th>Land</th><td>Danmark</td></tr>
+<tr><th>Indbyggere</th><td>660.842<sup>[1]</sup></td></tr>
+</tbody></table>
+<p>København er Danmarks hovedstad.</p>
+<figure><img src="x.jpg"><figcaption>Nyhavn  om aftenen</figcaption></figure>
+<div class="thumb"><div class="thumbcaption">Rådhuspladsen</div></div>
+</div>`
+
+func TestParseArticleStructure(t *testing.T) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(articleHTML))
+	assert.NoError(t, err)
+
+	assert.Equal(t, map[string]string{"Land": "Danmark", "Indbyggere": "660.842"}, parseInfobox(doc))
+	assert.Equal(t, []string{"Nyhavn om aftenen", "Rådhuspladsen"}, parseImageCaptions(doc))
+	assert.Equal(t, "Historie", htmlText("<i>Historie</i>"))
+}
+
+func TestFirstParagraph(t *testing.T) {
+	assert.Equal(t, "Lead paragraph.", firstParagraph("\n  Lead paragraph.\nSecond paragraph."))
+	assert.Equal(t, "", firstParagraph(""))
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Logged-in users can mark search results as relevant or not. Admins get a
+// report of the most downvoted results per query and can pin or bury URLs
+// for a query; searchPagesInEs applies those overrides.
+
+const (
+	overridePin  = "pin"
+	overrideBury = "bury"
+
+	// pinnedBoost is added to the score of pinned results, far above any
+	// text match, so they come first.
+	pinnedBoost = 1000
+	// buriedBoost multiplies the score of buried results so they sink below
+	// everything else that matches.
+	buriedBoost = 0.001
+)
+
+// resultFeedback is the vote tally for one result of one query.
+type resultFeedback struct {
+	Query     string `json:"query"`
+	URL       string `json:"url"`
+	Downvotes int    `json:"downvotes"`
+	Upvotes   int    `json:"upvotes"`
+	// Override is "pin" or "bury" when an admin has set one.
+	Override string `json:"override,omitempty"`
+}
+
+type queryOverride struct {
+	Query     string    `json:"query"`
+	URL       string    `json:"url"`
+	Action    string    `json:"action"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// saveFeedback stores userID's vote (1 or -1) on url for a normalized
+// query, replacing any earlier vote.
+func saveFeedback(userID int, query, url string, vote int) error {
+	_, err := db.Exec(`
+		INSERT INTO result_feedback (normalized_query, url, user_id, vote)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (normalized_query, url, user_id) DO UPDATE
+		SET vote = EXCLUDED.vote, updated_at = NOW()
+	`, query, url, userID, vote)
+	if err != nil {
+		return fmt.Errorf("error saving feedback: %w", err)
+	}
+	return nil
+}
+
+// feedbackReport lists results with downvotes cast since the given time,
+// most downvoted first.
+func feedbackReport(since time.Time, limit int) ([]resultFeedback, error) {
+	rows, err := db.Query(`
+		SELECT f.normalized_query, f.url,
+		       COUNT(*) FILTER (WHERE f.vote < 0), COUNT(*) FILTER (WHERE f.vote > 0), COALESCE(o.action, '')
+		FROM result_feedback f
+		LEFT JOIN query_overrides o ON o.normalized_query = f.normalized_query AND o.url = f.url
+		WHERE f.updated_at >= $1
+		GROUP BY f.normalized_query, f.url, o.action
+		HAVING COUNT(*) FILTER (WHERE f.vote < 0) > 0
+		ORDER BY 3 DESC, f.normalized_query, f.url
+		LIMIT $2
+	`, since, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error loading feedback: %w", err)
+	}
+	defer rows.Close()
+
+	report := []resultFeedback{}
+	for rows.Next() {
+		var f resultFeedback
+		if err := rows.Scan(&f.Query, &f.URL, &f.Downvotes, &f.Upvotes, &f.Override); err != nil {
+			return nil, fmt.Errorf("error scanning feedback: %w", err)
+		}
+		report = append(report, f)
+	}
+	return report, rows.Err()
+}
+
+func listOverrides() ([]queryOverride, error) {
+	rows, err := db.Query(`
+		SELECT o.normalized_query, o.url, o.action, COALESCE(u.username, ''), o.created_at
+		FROM query_overrides o LEFT JOIN users u ON u.id = o.created_by
+		ORDER BY o.normalized_query, o.action, o.url
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("error loading overrides: %w", err)
+	}
+	defer rows.Close()
+
+	var overrides []queryOverride
+	for rows.Next() {
+		var o queryOverride
+		if err := rows.Scan(&o.Query, &o.URL, &o.Action, &o.CreatedBy, &o.CreatedAt); err != nil {
+			return nil, fmt.Errorf("error scanning override: %w", err)
+		}
+		overrides = append(overrides, o)
+	}
+	return overrides, rows.Err()
+}
+
+// setOverride pins or buries url for a normalized query, replacing any
+// override it already has.
+func setOverride(query, url, action string, userID int) error {
+	_, err := db.Exec(`
+		INSERT INTO query_overrides (normalized_query, url, action, created_by)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (normalized_query, url) DO UPDATE
+		SET action = EXCLUDED.action, created_by = EXCLUDED.created_by, created_at = NOW()
+	`, query, url, action, userID)
+	if err != nil {
+		return fmt.Errorf("error saving override: %w", err)
+	}
+	return nil
+}
+
+func clearOverride(query, url string) error {
+	_, err := db.Exec("DELETE FROM query_overrides WHERE normalized_query = $1 AND url = $2", query, url)
+	if err != nil {
+		return fmt.Errorf("error removing override: %w", err)
+	}
+	return nil
+}
+
+// loadQueryOverrides returns the URLs pinned and buried for a normalized
+// query.
+func loadQueryOverrides(query string) (pinned, buried []string, err error) {
+	rows, err := db.Query("SELECT url, action FROM query_overrides WHERE normalized_query = $1 ORDER BY created_at", query)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error loading overrides for %q: %w", query, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var url, action string
+		if err := rows.Scan(&url, &action); err != nil {
+			return nil, nil, fmt.Errorf("error scanning override for %q: %w", query, err)
+		}
+		if action == overridePin {
+			pinned = append(pinned, url)
+		} else {
+			buried = append(buried, url)
+		}
+	}
+	return pinned, buried, rows.Err()
+}
+
+// feedbackHandler records a thumbs up or down from the results page. It
+// answers 204 so the browser stays on the results.
+// POST /api/feedback (q, url, vote=up|down)
+func feedbackHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := sessionUserID(r)
+	if !ok {
+		http.Error(w, "Log in to rate results", http.StatusUnauthorized)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid data", http.StatusBadRequest)
+		return
+	}
+
+	query := normalizeSearchTerm(r.FormValue("q"))
+	url := strings.TrimSpace(r.FormValue("url"))
+	var vote int
+	switch r.FormValue("vote") {
+	case "up":
+		vote = 1
+	case "down":
+		vote = -1
+	default:
+		http.Error(w, "Vote must be up or down", http.StatusBadRequest)
+		return
+	}
+	if query == "" || !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		http.Error(w, "A query and a result URL are required", http.StatusBadRequest)
+		return
+	}
+
+	if err := saveFeedback(userID, query, url, vote); err != nil {
+		log.Printf("%v", err)
+		http.Error(w, "Error saving feedback", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// feedbackReportHandler returns the most downvoted results.
+// GET /api/admin/feedback?window=30d&limit=20
+func feedbackReportHandler(w http.ResponseWriter, r *http.Request) {
+	window, since, _, limit, err := analyticsParams(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	report, err := feedbackReport(since, limit)
+	if err != nil {
+		log.Printf("%v", err)
+		http.Error(w, "Error loading feedback", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"window":  window,
+		"since":   since,
+		"results": report,
+	})
+}
+
+// feedbackPageHandler shows admins the most downvoted results and the
+// current overrides.
+// GET /admin/feedback?window=30d
+func feedbackPageHandler(w http.ResponseWriter, r *http.Request) {
+	window, since, _, limit, err := analyticsParams(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	report, err := feedbackReport(since, limit)
+	if err != nil {
+		log.Printf("%v", err)
+		http.Error(w, "Error loading feedback", http.StatusInternalServerError)
+		return
+	}
+	overrides, err := listOverrides()
+	if err != nil {
+		log.Printf("%v", err)
+		http.Error(w, "Error loading overrides", http.StatusInternalServerError)
+		return
+	}
+
+	tmpl, err := template.ParseFiles(templatePath+"layout.html", templatePath+"feedback.html")
+	if err != nil {
+		log.Printf("Error parsing templates: %v", err)
+		http.Error(w, "Error loading templates", http.StatusInternalServerError)
+		return
+	}
+	data := map[string]any{
+		"Title":        "Result feedback",
+		"UserLoggedIn": true,
+		"Window":       window,
+		"Windows":      []string{"24h", "7d", "30d", "90d"},
+		"Feedback":     report,
+		"Overrides":    overrides,
+		"Message":      r.URL.Query().Get("message"),
+	}
+	if err := tmpl.ExecuteTemplate(w, "layout.html", data); err != nil {
+		log.Printf("Error executing template: %v", err)
+		http.Error(w, "Error rendering page", http.StatusInternalServerError)
+	}
+}
+
+// overrideHandler pins, buries or clears a URL for a query and returns to
+// the feedback page.
+// POST /api/admin/overrides (q, url, action=pin|bury|clear)
+func overrideHandler(w http.ResponseWriter, r *http.Request) {
+	query := normalizeSearchTerm(r.FormValue("q"))
+	url, valid := validPageURL(r.FormValue("url"))
+	if query == "" || !valid {
+		http.Error(w, "A query and a full http:// or https:// address are required", http.StatusBadRequest)
+		return
+	}
+	userID, _ := sessionUserID(r)
+
+	var err error
+	var message string
+	switch action := r.FormValue("action"); action {
+	case overridePin, overrideBury:
+		err = setOverride(query, url, action, userID)
+		message = fmt.Sprintf("%s is now %s for %q", url, map[string]string{overridePin: "pinned", overrideBury: "buried"}[action], query)
+	case "clear":
+		err = clearOverride(query, url)
+		message = fmt.Sprintf("Removed the override for %s on %q", url, query)
+	default:
+		http.Error(w, "Action must be pin, bury or clear", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("%v", err)
+		http.Error(w, "Error saving override", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/admin/feedback?message="+template.URLQueryEscaper(message), http.StatusSeeOther)
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFeedbackHandler(t *testing.T) {
+	const resultURL = "https://go.dev/doc/"
+	testCases := []struct {
+		name       string
+		userID     int
+		form       url.Values
+		mockSetup  func(mock sqlmock.Sqlmock)
+		wantStatus int
+	}{
+		{
+			name:       "Not logged in",
+			form:       url.Values{"q": {"go"}, "url": {resultURL}, "vote": {"up"}},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "Unknown vote",
+			userID:     2,
+			form:       url.Values{"q": {"go"}, "url": {resultURL}, "vote": {"meh"}},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Missing query",
+			userID:     2,
+			form:       url.Values{"url": {resultURL}, "vote": {"down"}},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:   "Downvote",
+			userID: 2,
+			form:   url.Values{"q": {"  Go Docs "}, "url": {resultURL}, "vote": {"down"}},
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec("INSERT INTO result_feedback").WithArgs("go docs", resultURL, 2, -1).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+			},
+			wantStatus: http.StatusNoContent,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockDB, mock := setupMockDB()
+			defer mockDB.Close()
+			if tc.mockSetup != nil {
+				tc.mockSetup(mock)
+			}
+
+			req := loggedInRequest(t, "POST", "/api/feedback", tc.form.Encode(), tc.userID)
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+			feedbackHandler(w, req)
+
+			assert.Equal(t, tc.wantStatus, w.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestOverrideHandler(t *testing.T) {
+	const resultURL = "https://go.dev/doc/"
+	testCases := []struct {
+		name       string
+		form       url.Values
+		mockSetup  func(mock sqlmock.Sqlmock)
+		wantStatus int
+	}{
+		{
+			name: "Pin",
+			form: url.Values{"q": {"Go"}, "url": {resultURL}, "action": {overridePin}},
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec("INSERT INTO query_overrides").WithArgs("go", resultURL, overridePin, 1).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+			},
+			wantStatus: http.StatusSeeOther,
+		},
+		{
+			name: "Clear",
+			form: url.Values{"q": {"go"}, "url": {resultURL}, "action": {"clear"}},
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec("DELETE FROM query_overrides").WithArgs("go", resultURL).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+			wantStatus: http.StatusSeeOther,
+		},
+		{
+			name:       "Unknown action",
+			form:       url.Values{"q": {"go"}, "url": {resultURL}, "action": {"promote"}},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Invalid URL",
+			form:       url.Values{"q": {"go"}, "url": {"go.dev"}, "action": {overrideBury}},
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockDB, mock := setupMockDB()
+			defer mockDB.Close()
+			expectAdmin(mock)
+			if tc.mockSetup != nil {
+				tc.mockSetup(mock)
+			}
+
+			r := mux.NewRouter()
+			r.Handle("/api/admin/overrides", requireAdmin(http.HandlerFunc(overrideHandler)))
+			req := loggedInRequest(t, "POST", "/api/admin/overrides", tc.form.Encode(), 1)
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.wantStatus, w.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestFeedbackPageHandler(t *testing.T) {
+	mockDB, mock := setupMockDB()
+	defer mockDB.Close()
+
+	mock.ExpectQuery("FROM result_feedback f").WithArgs(sqlmock.AnyArg(), defaultAnalyticsLimit).
+		WillReturnRows(sqlmock.NewRows([]string{"query", "url", "down", "up", "override"}).
+			AddRow("go", "https://example.com/go-game", 5, 1, "").
+			AddRow("go", "https://example.com/old-go", 2, 0, overrideBury))
+	mock.ExpectQuery("FROM query_overrides o").
+		WillReturnRows(sqlmock.NewRows([]string{"query", "url", "action", "username", "created_at"}).
+			AddRow("go", "https://example.com/old-go", overrideBury, "admin", time.Now()))
+
+	w := httptest.NewRecorder()
+	feedbackPageHandler(w, httptest.NewRequest("GET", "/admin/feedback", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "https://example.com/go-game")
+	assert.Contains(t, w.Body.String(), "Buried")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSearchQuery(t *testing.T) {
+	plain, _ := json.Marshal(searchQuery("go", "", nil, nil))
+	assert.JSONEq(t, `{"bool":{"must":{"multi_match":{"query":"go",
+		"fields":["title^3","url^2","summary^2","infobox_text^2","sections","image_captions","content","content_*"]}}}}`,
+		string(plain))
+
+	q := searchQuery("go", "da", []string{"https://go.dev/"}, []string{"https://example.com/old-go"})
+	boosting := q["boosting"].(map[string]any)
+	assert.Equal(t, map[string]any{"terms": map[string]any{"url": []string{"https://example.com/old-go"}}}, boosting["negative"])
+	assert.Equal(t, buriedBoost, boosting["negative_boost"])
+
+	boolQuery := boosting["positive"].(map[string]any)["bool"].(map[string]any)
+	assert.Equal(t, 1, boolQuery["minimum_should_match"], "Pinned pages match even without the search text")
+	assert.Equal(t, map[string]any{"term": map[string]any{"language": "da"}}, boolQuery["filter"],
+		"Pinned pages still have to be in the requested language")
+	should := boolQuery["should"].([]any)
+	assert.Len(t, should, 2)
+	assert.Equal(t, map[string]any{"constant_score": map[string]any{
+		"filter": map[string]any{"terms": map[string]any{"url": []string{"https://go.dev/"}}},
+		"boost":  pinnedBoost,
+	}}, should[1])
+}
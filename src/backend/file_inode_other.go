@@ -0,0 +1,11 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// fileInode is not available on this platform; rotation is then only
+// detected when the log shrinks below the saved offset.
+func fileInode(fi os.FileInfo) uint64 {
+	return 0
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Scraper tests replay HTTP responses recorded in testdata/scraper instead
+// of talking to Wikipedia and other live sites. To record a new fixture (or
+// refresh one), run the test with SCRAPER_RECORD_FIXTURES=1 and network
+// access; responses are then fetched for real and written to disk.
+
+const fixtureDir = "testdata/scraper"
+
+// replayTransport is an http.RoundTripper that serves each request from a
+// fixture file, or records that file when record is set. A fixture is the
+// request line ("GET https://...") followed by the raw HTTP response.
+type replayTransport struct {
+	dir      string
+	record   bool
+	upstream http.RoundTripper
+}
+
+func (rt *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	path := filepath.Join(rt.dir, fixtureName(req))
+
+	if rt.record {
+		resp, err := rt.upstream.RoundTrip(req)
+		if err != nil {
+			return nil, err
+		}
+		dump, err := httputil.DumpResponse(resp, true)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		fixture := append([]byte(req.Method+" "+req.URL.String()+"\n"), dump...)
+		if err := os.WriteFile(path, fixture, 0o644); err != nil {
+			return nil, err
+		}
+		return http.ReadResponse(bufio.NewReader(bytes.NewReader(dump)), req)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("no fixture for %s %s (want %s); record it with SCRAPER_RECORD_FIXTURES=1: %w",
+			req.Method, req.URL, path, err)
+	}
+	r := bufio.NewReader(bytes.NewReader(data))
+	if _, err := r.ReadString('\n'); err != nil {
+		return nil, fmt.Errorf("fixture %s has no request line: %w", path, err)
+	}
+	return http.ReadResponse(r, req)
+}
+
+// fixtureName maps a request to a file name that is readable but still
+// unique: the sanitized URL, cut short, plus a hash of the full request.
+func fixtureName(req *http.Request) string {
+	key := req.Method + " " + req.URL.String()
+	sum := sha256.Sum256([]byte(key))
+
+	readable := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-':
+			return r
+		}
+		return '_'
+	}, req.URL.Host+req.URL.Path)
+	if len(readable) > 60 {
+		readable = readable[:60]
+	}
+	return fmt.Sprintf("%s_%s_%s.http", req.Method, readable, hex.EncodeToString(sum[:4]))
+}
+
+// useFixtureClient points the scraper's HTTP client and the MediaWiki client
+// at recorded fixtures for the duration of the test.
+func useFixtureClient(t *testing.T) {
+	t.Helper()
+	client := &http.Client{Transport: &replayTransport{
+		dir:      fixtureDir,
+		record:   os.Getenv("SCRAPER_RECORD_FIXTURES") == "1",
+		upstream: http.DefaultTransport,
+	}}
+
+	previousHTTP, previousWiki := scraperHTTPClient, wikiClient
+	scraperHTTPClient = client
+	wikiClient = &mediaWikiClient{http: client, endpoint: defaultMediaWikiEndpoint}
+	t.Cleanup(func() {
+		scraperHTTPClient, wikiClient = previousHTTP, previousWiki
+	})
+}
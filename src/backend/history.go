@@ -0,0 +1,341 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Logged-in users get a list of their recent searches, which they can
+// clear, and can save queries under a name to run them again later.
+
+const (
+	defaultHistoryLimit = 20
+	maxSavedSearchName  = 100
+)
+
+type historyEntry struct {
+	Query      string            `json:"query"`
+	Filters    map[string]string `json:"filters,omitempty"`
+	SearchedAt time.Time         `json:"searched_at"`
+}
+
+// URL runs the search again.
+func (h historyEntry) URL() string {
+	return searchURL(h.Query, h.Filters)
+}
+
+type savedSearch struct {
+	ID        int               `json:"id"`
+	Name      string            `json:"name"`
+	Query     string            `json:"query"`
+	Filters   map[string]string `json:"filters,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+	// AlertChannel is how the user hears about new matching pages, if they
+	// subscribed to them.
+	AlertChannel string `json:"alert_channel,omitempty"`
+}
+
+// URL runs the saved search.
+func (s savedSearch) URL() string {
+	return searchURL(s.Query, s.Filters)
+}
+
+// searchURL is the results page for query with the given filters.
+func searchURL(query string, filters map[string]string) string {
+	params := url.Values{"q": {query}}
+	for name, value := range filters {
+		params.Set(name, value)
+	}
+	return "/search?" + params.Encode()
+}
+
+func addSearchHistory(userID int, query string, filters map[string]string) error {
+	encoded, err := encodeFilters(filters)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`
+		INSERT INTO search_history (user_id, query, normalized_query, filters) VALUES ($1, $2, $3, $4)
+	`, userID, query, normalizeSearchTerm(query), encoded)
+	if err != nil {
+		return fmt.Errorf("error saving search history of user %d: %w", userID, err)
+	}
+	return nil
+}
+
+// loadSearchHistory returns a user's most recent searches, newest first,
+// with repeats of the same search listed once.
+func loadSearchHistory(userID, limit int) ([]historyEntry, error) {
+	rows, err := db.Query(`
+		SELECT query, filters, searched_at FROM (
+			SELECT DISTINCT ON (normalized_query, filters) query, filters, created_at AS searched_at
+			FROM search_history WHERE user_id = $1
+			ORDER BY normalized_query, filters, created_at DESC
+		) latest
+		ORDER BY searched_at DESC
+		LIMIT $2
+	`, userID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error loading search history of user %d: %w", userID, err)
+	}
+	return scanSearchHistory(rows)
+}
+
+// allSearchHistory returns every search in a user's history, oldest first.
+func allSearchHistory(userID int) ([]historyEntry, error) {
+	rows, err := db.Query(`
+		SELECT query, filters, created_at FROM search_history WHERE user_id = $1 ORDER BY created_at
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error loading search history of user %d: %w", userID, err)
+	}
+	return scanSearchHistory(rows)
+}
+
+func scanSearchHistory(rows *sql.Rows) ([]historyEntry, error) {
+	defer rows.Close()
+	history := []historyEntry{}
+	for rows.Next() {
+		var h historyEntry
+		var raw []byte
+		if err := rows.Scan(&h.Query, &raw, &h.SearchedAt); err != nil {
+			return nil, fmt.Errorf("error scanning search history: %w", err)
+		}
+		filters, err := decodeFilters(raw)
+		if err != nil {
+			return nil, err
+		}
+		h.Filters = filters
+		history = append(history, h)
+	}
+	return history, rows.Err()
+}
+
+func clearSearchHistory(userID int) error {
+	_, err := db.Exec("DELETE FROM search_history WHERE user_id = $1", userID)
+	if err != nil {
+		return fmt.Errorf("error clearing search history of user %d: %w", userID, err)
+	}
+	return nil
+}
+
+func loadSavedSearches(userID int) ([]savedSearch, error) {
+	rows, err := db.Query(`
+		SELECT s.id, s.name, s.query, s.filters, s.created_at, COALESCE(a.channel, '')
+		FROM saved_searches s LEFT JOIN search_alerts a ON a.saved_search_id = s.id
+		WHERE s.user_id = $1 ORDER BY s.name
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error loading saved searches of user %d: %w", userID, err)
+	}
+	defer rows.Close()
+
+	saved := []savedSearch{}
+	for rows.Next() {
+		var s savedSearch
+		var filters []byte
+		if err := rows.Scan(&s.ID, &s.Name, &s.Query, &filters, &s.CreatedAt, &s.AlertChannel); err != nil {
+			return nil, fmt.Errorf("error scanning saved search: %w", err)
+		}
+		if s.Filters, err = decodeFilters(filters); err != nil {
+			return nil, err
+		}
+		saved = append(saved, s)
+	}
+	return saved, rows.Err()
+}
+
+// saveSearch stores a search under name, replacing what the user had saved
+// under that name before.
+func saveSearch(userID int, name, query string, filters map[string]string) error {
+	encoded, err := encodeFilters(filters)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`
+		INSERT INTO saved_searches (user_id, name, query, filters) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id, name) DO UPDATE
+		SET query = EXCLUDED.query, filters = EXCLUDED.filters, created_at = NOW()
+	`, userID, name, query, encoded)
+	if err != nil {
+		return fmt.Errorf("error saving search %q of user %d: %w", name, userID, err)
+	}
+	return nil
+}
+
+// deleteSavedSearch removes one of the user's saved searches. It returns
+// sql.ErrNoRows when the user has no saved search with that ID.
+func deleteSavedSearch(userID, id int) error {
+	res, err := db.Exec("DELETE FROM saved_searches WHERE id = $1 AND user_id = $2", id, userID)
+	if err != nil {
+		return fmt.Errorf("error deleting saved search %d: %w", id, err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// searchesPageHandler lists a user's recent and saved searches.
+// GET /account/searches
+func searchesPageHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := sessionUserID(r)
+	if !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	history, err := loadSearchHistory(userID, defaultHistoryLimit)
+	if err != nil {
+		log.Printf("%v", err)
+		http.Error(w, "Error loading search history", http.StatusInternalServerError)
+		return
+	}
+	saved, err := loadSavedSearches(userID)
+	if err != nil {
+		log.Printf("%v", err)
+		http.Error(w, "Error loading saved searches", http.StatusInternalServerError)
+		return
+	}
+
+	tmpl, err := template.ParseFiles(templatePath+"layout.html", templatePath+"searches.html")
+	if err != nil {
+		log.Printf("Error parsing templates: %v", err)
+		http.Error(w, "Error loading templates", http.StatusInternalServerError)
+		return
+	}
+	data := map[string]any{
+		"Title":        "My searches",
+		"UserLoggedIn": true,
+		"History":      history,
+		"Saved":        saved,
+		"Message":      r.URL.Query().Get("message"),
+	}
+	if err := tmpl.ExecuteTemplate(w, "layout.html", data); err != nil {
+		log.Printf("Error executing template: %v", err)
+		http.Error(w, "Error rendering page", http.StatusInternalServerError)
+	}
+}
+
+// searchHistoryHandler returns a user's recent searches, newest first.
+// GET /api/account/history?limit=20
+func searchHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := sessionUserID(r)
+	if !ok {
+		http.Error(w, "Not logged in", http.StatusUnauthorized)
+		return
+	}
+	limit := defaultHistoryLimit
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxAnalyticsLimit {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxAnalyticsLimit), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	history, err := loadSearchHistory(userID, limit)
+	if err != nil {
+		log.Printf("%v", err)
+		http.Error(w, "Error loading search history", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"history": history})
+}
+
+// clearSearchHistoryHandler deletes a user's search history.
+// POST /api/account/history/clear
+func clearSearchHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := sessionUserID(r)
+	if !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	if err := clearSearchHistory(userID); err != nil {
+		log.Printf("%v", err)
+		http.Error(w, "Error clearing search history", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/account/searches?message="+template.URLQueryEscaper("Your search history was cleared."), http.StatusSeeOther)
+}
+
+// savedSearchesHandler returns a user's saved searches by name.
+// GET /api/account/saved-searches
+func savedSearchesHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := sessionUserID(r)
+	if !ok {
+		http.Error(w, "Not logged in", http.StatusUnauthorized)
+		return
+	}
+	saved, err := loadSavedSearches(userID)
+	if err != nil {
+		log.Printf("%v", err)
+		http.Error(w, "Error loading saved searches", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"saved_searches": saved})
+}
+
+// saveSearchHandler saves a query and its filters under a name.
+// POST /api/account/saved-searches (name, q, language)
+func saveSearchHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := sessionUserID(r)
+	if !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	name := strings.TrimSpace(r.FormValue("name"))
+	query := strings.TrimSpace(r.FormValue("q"))
+	language := strings.ToLower(strings.TrimSpace(r.FormValue("language")))
+	if name == "" || query == "" {
+		http.Error(w, "A name and a query are required", http.StatusBadRequest)
+		return
+	}
+	if len([]rune(name)) > maxSavedSearchName {
+		http.Error(w, fmt.Sprintf("Name must be at most %d characters", maxSavedSearchName), http.StatusBadRequest)
+		return
+	}
+
+	if err := saveSearch(userID, name, query, searchFilters(language)); err != nil {
+		log.Printf("%v", err)
+		http.Error(w, "Error saving search", http.StatusInternalServerError)
+		return
+	}
+	message := fmt.Sprintf("Saved %q as %q.", query, name)
+	http.Redirect(w, r, "/account/searches?message="+template.URLQueryEscaper(message), http.StatusSeeOther)
+}
+
+// deleteSavedSearchHandler removes one of a user's saved searches.
+// POST /api/account/saved-searches/{id}/delete
+func deleteSavedSearchHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := sessionUserID(r)
+	if !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid saved search ID", http.StatusBadRequest)
+		return
+	}
+
+	err = deleteSavedSearch(userID, id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Saved search not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("%v", err)
+		http.Error(w, "Error deleting saved search", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/account/searches?message="+template.URLQueryEscaper("Saved search deleted."), http.StatusSeeOther)
+}
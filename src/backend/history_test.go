@@ -0,0 +1,175 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSearchURL(t *testing.T) {
+	assert.Equal(t, "/search?q=go+lang", searchURL("go lang", nil))
+	assert.Equal(t, "/search?language=da&q=k%C3%B8benhavn", searchURL("københavn", map[string]string{"language": "da"}))
+}
+
+func TestSaveSearchHandler(t *testing.T) {
+	testCases := []struct {
+		name       string
+		body       string
+		userID     int
+		mockSetup  func(mock sqlmock.Sqlmock)
+		wantStatus int
+		wantTarget string
+	}{
+		{
+			name:   "Saves the query with its filters",
+			body:   "name=Danish+Go&q=go&language=DA",
+			userID: 3,
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec("INSERT INTO saved_searches").
+					WithArgs(3, "Danish Go", "go", sql.NullString{String: `{"language":"da"}`, Valid: true}).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+			},
+			wantStatus: http.StatusSeeOther,
+			wantTarget: "/account/searches?message=",
+		},
+		{
+			name:       "Requires a name",
+			body:       "name=+&q=go",
+			userID:     3,
+			mockSetup:  func(mock sqlmock.Sqlmock) {},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Rejects overly long names",
+			body:       "q=go&name=" + strings.Repeat("a", maxSavedSearchName+1),
+			userID:     3,
+			mockSetup:  func(mock sqlmock.Sqlmock) {},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Sends anonymous users to log in",
+			body:       "name=go&q=go",
+			mockSetup:  func(mock sqlmock.Sqlmock) {},
+			wantStatus: http.StatusSeeOther,
+			wantTarget: "/login",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockDB, mock := setupMockDB()
+			defer mockDB.Close()
+			tc.mockSetup(mock)
+
+			req := loggedInRequest(t, "POST", "/api/account/saved-searches", tc.body, tc.userID)
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			rr := httptest.NewRecorder()
+			saveSearchHandler(rr, req)
+
+			assert.Equal(t, tc.wantStatus, rr.Code)
+			assert.True(t, strings.HasPrefix(rr.Header().Get("Location"), tc.wantTarget))
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestDeleteSavedSearchHandler(t *testing.T) {
+	mockDB, mock := setupMockDB()
+	defer mockDB.Close()
+
+	mock.ExpectExec("DELETE FROM saved_searches WHERE id = \\$1 AND user_id = \\$2").WithArgs(7, 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM saved_searches WHERE id = \\$1 AND user_id = \\$2").WithArgs(8, 3).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	req := mux.SetURLVars(loggedInRequest(t, "POST", "/api/account/saved-searches/7/delete", "", 3), map[string]string{"id": "7"})
+	rr := httptest.NewRecorder()
+	deleteSavedSearchHandler(rr, req)
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+
+	// Another user's search, or one that is already gone.
+	req = mux.SetURLVars(loggedInRequest(t, "POST", "/api/account/saved-searches/8/delete", "", 3), map[string]string{"id": "8"})
+	rr = httptest.NewRecorder()
+	deleteSavedSearchHandler(rr, req)
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSearchHistoryHandler(t *testing.T) {
+	mockDB, mock := setupMockDB()
+	defer mockDB.Close()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	mock.ExpectQuery("SELECT DISTINCT ON \\(normalized_query, filters\\)").WithArgs(3, 5).
+		WillReturnRows(sqlmock.NewRows([]string{"query", "filters", "searched_at"}).
+			AddRow("København", []byte(`{"language":"da"}`), now).
+			AddRow("go", nil, now.Add(-time.Hour)))
+
+	rr := httptest.NewRecorder()
+	searchHistoryHandler(rr, loggedInRequest(t, "GET", "/api/account/history?limit=5", "", 3))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	var body struct {
+		History []historyEntry `json:"history"`
+	}
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &body))
+	if assert.Len(t, body.History, 2) {
+		assert.Equal(t, map[string]string{"language": "da"}, body.History[0].Filters)
+		assert.Nil(t, body.History[1].Filters)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	rr = httptest.NewRecorder()
+	searchHistoryHandler(rr, loggedInRequest(t, "GET", "/api/account/history?limit=0", "", 3))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = httptest.NewRecorder()
+	searchHistoryHandler(rr, loggedInRequest(t, "GET", "/api/account/history", "", 0))
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+}
+
+func TestClearSearchHistoryHandler(t *testing.T) {
+	mockDB, mock := setupMockDB()
+	defer mockDB.Close()
+
+	mock.ExpectExec("DELETE FROM search_history WHERE user_id = \\$1").WithArgs(3).
+		WillReturnResult(sqlmock.NewResult(0, 12))
+
+	rr := httptest.NewRecorder()
+	clearSearchHistoryHandler(rr, loggedInRequest(t, "POST", "/api/account/history/clear", "", 3))
+
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+	assert.True(t, strings.HasPrefix(rr.Header().Get("Location"), "/account/searches?message="))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSearchesPageHandler(t *testing.T) {
+	mockDB, mock := setupMockDB()
+	defer mockDB.Close()
+
+	now := time.Now()
+	mock.ExpectQuery("FROM search_history").WithArgs(3, defaultHistoryLimit).
+		WillReturnRows(sqlmock.NewRows([]string{"query", "filters", "searched_at"}).
+			AddRow("rust", nil, now))
+	mock.ExpectQuery("FROM saved_searches").WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "query", "filters", "created_at", "alert_channel"}).
+			AddRow(7, "Danish Go", "go", []byte(`{"language":"da"}`), now, ""))
+
+	rr := httptest.NewRecorder()
+	searchesPageHandler(rr, loggedInRequest(t, "GET", "/account/searches", "", 3))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	body := rr.Body.String()
+	assert.Contains(t, body, `href="/search?q=rust"`)
+	assert.Contains(t, body, `href="/search?language=da&amp;q=go"`)
+	assert.Contains(t, body, "/api/account/saved-searches/7/delete")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html/charset"
+)
+
+// Ingestion sources are sitemaps and RSS/Atom feeds whose entries are queued
+// as URL jobs, so we can index sites other than Wikipedia.
+
+const (
+	ingestSitemap = "sitemap"
+	ingestFeed    = "feed"
+
+	// Nested sitemap indexes are followed this deep.
+	maxSitemapDepth = 3
+	// Sources larger than this are cut off rather than read into memory.
+	maxIngestSourceBytes = 50 << 20
+)
+
+// ingestMaxURLs caps how many URLs one source may queue per run. Overridden
+// from the environment in config.go.
+var ingestMaxURLs = 5000
+
+var errUnknownIngestFormat = errors.New("not a sitemap, RSS or Atom document")
+
+// ingestEntry is one URL found in a source, with whatever metadata the
+// source had for it. It is stored as the payload of the URL job.
+type ingestEntry struct {
+	URL       string    `json:"url"`
+	Title     string    `json:"title,omitempty"`
+	Summary   string    `json:"summary,omitempty"`
+	Published time.Time `json:"published,omitzero"`
+	LastMod   time.Time `json:"lastmod,omitzero"`
+}
+
+type sitemapDocument struct {
+	URLs []struct {
+		Loc     string `xml:"loc"`
+		LastMod string `xml:"lastmod"`
+	} `xml:"url"`
+	Sitemaps []struct {
+		Loc     string `xml:"loc"`
+		LastMod string `xml:"lastmod"`
+	} `xml:"sitemap"`
+}
+
+type rssDocument struct {
+	Channel struct {
+		Items []struct {
+			Title       string `xml:"title"`
+			Link        string `xml:"link"`
+			GUID        string `xml:"guid"`
+			PubDate     string `xml:"pubDate"`
+			Description string `xml:"description"`
+		} `xml:"item"`
+	} `xml:"channel"`
+}
+
+type atomDocument struct {
+	Entries []struct {
+		Title string `xml:"title"`
+		Links []struct {
+			Href string `xml:"href,attr"`
+			Rel  string `xml:"rel,attr"`
+		} `xml:"link"`
+		Published string `xml:"published"`
+		Updated   string `xml:"updated"`
+		Summary   string `xml:"summary"`
+		Content   string `xml:"content"`
+	} `xml:"entry"`
+}
+
+// parseIngestDocument recognizes a sitemap, sitemap index, RSS or Atom
+// document by its root element and returns its entries. For a sitemap index
+// the child sitemaps are returned instead.
+func parseIngestDocument(data []byte) (kind string, entries []ingestEntry, sitemaps []ingestEntry, err error) {
+	root, err := xmlRootElement(data)
+	if err != nil {
+		return "", nil, nil, err
+	}
+
+	switch root {
+	case "urlset", "sitemapindex":
+		var doc sitemapDocument
+		if err := decodeXML(data, &doc); err != nil {
+			return "", nil, nil, err
+		}
+		for _, u := range doc.URLs {
+			if loc := strings.TrimSpace(u.Loc); loc != "" {
+				entries = append(entries, ingestEntry{URL: loc, LastMod: parseFeedTime(u.LastMod)})
+			}
+		}
+		for _, s := range doc.Sitemaps {
+			if loc := strings.TrimSpace(s.Loc); loc != "" {
+				sitemaps = append(sitemaps, ingestEntry{URL: loc, LastMod: parseFeedTime(s.LastMod)})
+			}
+		}
+		return ingestSitemap, entries, sitemaps, nil
+
+	case "rss":
+		var doc rssDocument
+		if err := decodeXML(data, &doc); err != nil {
+			return "", nil, nil, err
+		}
+		for _, item := range doc.Channel.Items {
+			link := strings.TrimSpace(item.Link)
+			if link == "" && strings.HasPrefix(item.GUID, "http") {
+				link = strings.TrimSpace(item.GUID)
+			}
+			if link == "" {
+				continue
+			}
+			entries = append(entries, ingestEntry{
+				URL:       link,
+				Title:     cleanText(item.Title),
+				Summary:   htmlText(item.Description),
+				Published: parseFeedTime(item.PubDate),
+			})
+		}
+		return ingestFeed, entries, nil, nil
+
+	case "feed":
+		var doc atomDocument
+		if err := decodeXML(data, &doc); err != nil {
+			return "", nil, nil, err
+		}
+		for _, e := range doc.Entries {
+			var link string
+			for _, l := range e.Links {
+				if l.Rel == "" || l.Rel == "alternate" {
+					link = strings.TrimSpace(l.Href)
+					break
+				}
+			}
+			if link == "" {
+				continue
+			}
+			summary := e.Summary
+			if summary == "" {
+				summary = e.Content
+			}
+			published := parseFeedTime(e.Published)
+			if published.IsZero() {
+				published = parseFeedTime(e.Updated)
+			}
+			entries = append(entries, ingestEntry{
+				URL:       link,
+				Title:     cleanText(e.Title),
+				Summary:   htmlText(summary),
+				Published: published,
+				LastMod:   parseFeedTime(e.Updated),
+			})
+		}
+		return ingestFeed, entries, nil, nil
+	}
+	return "", nil, nil, fmt.Errorf("%w (root element <%s>)", errUnknownIngestFormat, root)
+}
+
+func newXMLDecoder(data []byte) *xml.Decoder {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	// Feeds are often declared as ISO-8859-1 or windows-1252.
+	dec.CharsetReader = charset.NewReaderLabel
+	dec.Strict = false
+	return dec
+}
+
+func decodeXML(data []byte, v any) error {
+	if err := newXMLDecoder(data).Decode(v); err != nil {
+		return fmt.Errorf("error parsing XML: %w", err)
+	}
+	return nil
+}
+
+func xmlRootElement(data []byte) (string, error) {
+	dec := newXMLDecoder(data)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return "", errUnknownIngestFormat
+		}
+		if err != nil {
+			return "", fmt.Errorf("error parsing XML: %w", err)
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			return start.Name.Local, nil
+		}
+	}
+}
+
+// feedTimeLayouts covers W3C datetimes used by sitemaps and Atom, and the
+// RFC 822 variants found in RSS.
+var feedTimeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02",
+	time.RFC1123Z,
+	time.RFC1123,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"2 Jan 2006 15:04:05 -0700",
+	time.RFC822Z,
+	time.RFC822,
+}
+
+// parseFeedTime returns the zero time for empty or unrecognized dates.
+func parseFeedTime(value string) time.Time {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}
+	}
+	for _, layout := range feedTimeLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// fetchIngestDocument downloads a source through the scraper's HTTP client,
+// so robots.txt and per-domain limits apply.
+func fetchIngestDocument(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := scraperHTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s returned %s", url, resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxIngestSourceBytes))
+}
+
+// collectIngestEntries fetches a source and returns up to limit entries,
+// following sitemap indexes.
+func collectIngestEntries(ctx context.Context, url string, limit int) (string, []ingestEntry, error) {
+	seen := make(map[string]bool)
+	var collect func(url string, depth int) (string, []ingestEntry, error)
+	collect = func(url string, depth int) (string, []ingestEntry, error) {
+		seen[url] = true
+		data, err := fetchIngestDocument(ctx, url)
+		if err != nil {
+			return "", nil, err
+		}
+		kind, entries, sitemaps, err := parseIngestDocument(data)
+		if err != nil {
+			return "", nil, fmt.Errorf("%s: %w", url, err)
+		}
+
+		for _, child := range sitemaps {
+			if len(entries) >= limit || ctx.Err() != nil {
+				break
+			}
+			if seen[child.URL] || depth >= maxSitemapDepth {
+				continue
+			}
+			_, more, err := collect(child.URL, depth+1)
+			if err != nil {
+				log.Printf("Error reading sitemap %s: %v", child.URL, err)
+				continue
+			}
+			entries = append(entries, more...)
+		}
+
+		if len(entries) > limit {
+			entries = entries[:limit]
+		}
+		return kind, entries, nil
+	}
+	return collect(url, 0)
+}
+
+// ingestSourceURLs returns the configured sources: those stored in
+// ingest_sources plus any listed in INGEST_SOURCES, which are added to the
+// table.
+func ingestSourceURLs() ([]string, error) {
+	for _, url := range strings.Split(os.Getenv("INGEST_SOURCES"), ",") {
+		if url = strings.TrimSpace(url); url == "" {
+			continue
+		}
+		if _, err := db.Exec(`
+			INSERT INTO ingest_sources (url) VALUES ($1) ON CONFLICT (url) DO NOTHING
+		`, url); err != nil {
+			return nil, fmt.Errorf("error adding ingest source %s: %w", url, err)
+		}
+	}
+
+	rows, err := db.Query("SELECT url FROM ingest_sources WHERE enabled ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("error loading ingest sources: %w", err)
+	}
+	defer rows.Close()
+
+	var urls []string
+	for rows.Next() {
+		var url string
+		if err := rows.Scan(&url); err != nil {
+			return nil, err
+		}
+		urls = append(urls, url)
+	}
+	return urls, rows.Err()
+}
+
+// runIngestSources reads every enabled source and queues its URLs.
+func runIngestSources(ctx context.Context) {
+	urls, err := ingestSourceURLs()
+	if err != nil {
+		log.Printf("%v", err)
+		return
+	}
+
+	for _, url := range urls {
+		if ctx.Err() != nil {
+			return
+		}
+		queued, err := ingestSource(ctx, url)
+		if err != nil {
+			log.Printf("Error ingesting %s: %v", url, err)
+		} else {
+			log.Printf("Ingested %s: queued %d URLs", url, queued)
+		}
+		recordIngestRun(url, err)
+	}
+}
+
+// ingestSource reads one source and queues its entries as URL jobs. Returns
+// how many were queued or requeued.
+func ingestSource(ctx context.Context, url string) (int, error) {
+	kind, entries, err := collectIngestEntries(ctx, url, ingestMaxURLs)
+	if err != nil {
+		return 0, err
+	}
+
+	queued := 0
+	for _, entry := range entries {
+		ok, err := enqueueURLJob(entry)
+		if err != nil {
+			return queued, err
+		}
+		if ok {
+			queued++
+			ingestedURLsTotal.WithLabelValues(kind).Inc()
+		}
+	}
+	return queued, nil
+}
+
+func recordIngestRun(url string, runErr error) {
+	var lastError any
+	if runErr != nil {
+		lastError = runErr.Error()
+	}
+	_, err := db.Exec(`
+		UPDATE ingest_sources SET last_fetched_at = NOW(), last_error = $2 WHERE url = $1
+	`, url, lastError)
+	if err != nil {
+		log.Printf("Error recording ingest run for %s: %v", url, err)
+	}
+}
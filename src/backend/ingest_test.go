@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseIngestDocument(t *testing.T) {
+	testCases := []struct {
+		name         string
+		doc          string
+		wantKind     string
+		wantEntries  []ingestEntry
+		wantSitemaps []string
+	}{
+		{
+			name: "Sitemap",
+			doc: `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url><loc>https://docs.example.com/install</loc><lastmod>2026-03-01</lastmod></url>
+  <url><loc> https://docs.example.com/usage </loc></url>
+</urlset>`,
+			wantKind: ingestSitemap,
+			wantEntries: []ingestEntry{
+				{URL: "https://docs.example.com/install", LastMod: time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)},
+				{URL: "https://docs.example.com/usage"},
+			},
+		},
+		{
+			name: "Sitemap index",
+			doc: `<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <sitemap><loc>https://docs.example.com/sitemap-1.xml</loc><lastmod>2026-03-01T10:00:00+00:00</lastmod></sitemap>
+</sitemapindex>`,
+			wantKind:     ingestSitemap,
+			wantSitemaps: []string{"https://docs.example.com/sitemap-1.xml"},
+		},
+		{
+			name: "RSS",
+			doc: `<?xml version="1.0" encoding="ISO-8859-1"?>
+<rss version="2.0"><channel><title>Nyheder</title>
+  <item>
+    <title>Ny udgave</title>
+    <link>https://news.example.com/ny-udgave</link>
+    <pubDate>Mon, 02 Mar 2026 08:30:00 +0100</pubDate>
+    <description>&lt;p&gt;Version 2 er &lt;b&gt;ude&lt;/b&gt; nu.&lt;/p&gt;</description>
+  </item>
+  <item><title>No link</title></item>
+</channel></rss>`,
+			wantKind: ingestFeed,
+			wantEntries: []ingestEntry{{
+				URL:       "https://news.example.com/ny-udgave",
+				Title:     "Ny udgave",
+				Summary:   "Version 2 er ude nu.",
+				Published: time.Date(2026, 3, 2, 8, 30, 0, 0, time.FixedZone("", 3600)),
+			}},
+		},
+		{
+			name: "Atom",
+			doc: `<feed xmlns="http://www.w3.org/2005/Atom"><title>Blog</title>
+  <entry>
+    <title type="html">Release notes</title>
+    <link rel="self" href="https://blog.example.com/feed/1"/>
+    <link rel="alternate" href="https://blog.example.com/release-notes"/>
+    <updated>2026-03-05T12:00:00Z</updated>
+    <summary>What changed.</summary>
+  </entry>
+</feed>`,
+			wantKind: ingestFeed,
+			wantEntries: []ingestEntry{{
+				URL:       "https://blog.example.com/release-notes",
+				Title:     "Release notes",
+				Summary:   "What changed.",
+				Published: time.Date(2026, 3, 5, 12, 0, 0, 0, time.UTC),
+				LastMod:   time.Date(2026, 3, 5, 12, 0, 0, 0, time.UTC),
+			}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			kind, entries, sitemaps, err := parseIngestDocument([]byte(tc.doc))
+			assert.NoError(t, err)
+			assert.Equal(t, tc.wantKind, kind)
+			assert.Equal(t, len(tc.wantEntries), len(entries))
+			for i := range tc.wantEntries {
+				assert.Equal(t, tc.wantEntries[i].URL, entries[i].URL)
+				assert.Equal(t, tc.wantEntries[i].Title, entries[i].Title)
+				assert.Equal(t, tc.wantEntries[i].Summary, entries[i].Summary)
+				assert.True(t, tc.wantEntries[i].Published.Equal(entries[i].Published), "published %v", entries[i].Published)
+				assert.True(t, tc.wantEntries[i].LastMod.Equal(entries[i].LastMod), "lastmod %v", entries[i].LastMod)
+			}
+			var sitemapURLs []string
+			for _, s := range sitemaps {
+				sitemapURLs = append(sitemapURLs, s.URL)
+			}
+			assert.Equal(t, tc.wantSitemaps, sitemapURLs)
+		})
+	}
+}
+
+func TestParseIngestDocumentUnknown(t *testing.T) {
+	_, _, _, err := parseIngestDocument([]byte(`<html><body>Not a feed</body></html>`))
+	assert.ErrorIs(t, err, errUnknownIngestFormat)
+}
+
+func TestCollectIngestEntriesFollowsSitemapIndex(t *testing.T) {
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	mux.HandleFunc("/sitemap.xml", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<sitemapindex>
+  <sitemap><loc>` + srv.URL + `/sitemap-docs.xml</loc></sitemap>
+  <sitemap><loc>` + srv.URL + `/sitemap.xml</loc></sitemap>
+</sitemapindex>`))
+	})
+	mux.HandleFunc("/sitemap-docs.xml", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<urlset>
+  <url><loc>` + srv.URL + `/a</loc></url>
+  <url><loc>` + srv.URL + `/b</loc></url>
+  <url><loc>` + srv.URL + `/c</loc></url>
+</urlset>`))
+	})
+
+	previous := scraperHTTPClient
+	scraperHTTPClient = srv.Client()
+	defer func() { scraperHTTPClient = previous }()
+
+	kind, entries, err := collectIngestEntries(context.Background(), srv.URL+"/sitemap.xml", 2)
+	assert.NoError(t, err)
+	assert.Equal(t, ingestSitemap, kind)
+	assert.Equal(t, 2, len(entries), "entries should be capped at the limit")
+	assert.Equal(t, srv.URL+"/a", entries[0].URL)
+}
@@ -47,14 +47,34 @@ CREATE TABLE users (
     username TEXT,
     email TEXT,
     password TEXT,
-	password_changed BOOLEAN DEFAULT TRUE
+	password_changed BOOLEAN DEFAULT TRUE,
+	is_admin BOOLEAN DEFAULT FALSE,
+	search_history_opt_out BOOLEAN DEFAULT FALSE
 );
 CREATE TABLE pages (
     title TEXT,
     url TEXT,
     language TEXT,
     last_updated DATETIME,
-    content TEXT
+    content TEXT,
+    etag TEXT,
+    http_last_modified TEXT,
+    last_crawled_at DATETIME,
+    gone_at DATETIME,
+    categories TEXT,
+    summary TEXT,
+    sections TEXT,
+    infobox TEXT,
+    image_captions TEXT,
+    outgoing_links TEXT,
+    source_language TEXT,
+    detected_language TEXT,
+    language_confidence REAL,
+    simhash INTEGER,
+    cluster_id TEXT,
+    source TEXT,
+    published_at DATETIME,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
 );
 `
 	if _, err := db.Exec(schema); err != nil {
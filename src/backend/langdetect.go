@@ -0,0 +1,129 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"unicode"
+)
+
+// Language identification with a character n-gram model: each language is
+// represented by the relative frequencies of the 1- to 3-grams in its sample
+// text, and a text is scored under every model (naive Bayes with add-one
+// smoothing). Short text gets its confidence scaled down.
+
+const (
+	// langDetectMaxChars bounds the work for very long articles; the first
+	// few thousand characters are plenty to tell languages apart.
+	langDetectMaxChars = 4000
+	// Below this many letters a text says too little about its language to
+	// be trusted fully; confidence is scaled down proportionally.
+	langDetectMinLetters = 200
+)
+
+// Pages whose detected language is below this confidence keep the language
+// of the source they came from. Overridden from the environment in config.go.
+var langDetectMinConfidence = 0.5
+
+type ngramModel struct {
+	logProb map[string]float64
+	// unseen is the smoothed log probability of an n-gram not in the sample.
+	unseen float64
+}
+
+var languageModels = buildLanguageModels(languageSamples)
+
+func buildLanguageModels(samples map[string]string) map[string]ngramModel {
+	models := make(map[string]ngramModel, len(samples))
+	for lang, text := range samples {
+		counts := ngramCounts(text)
+		total := 0
+		for _, c := range counts {
+			total += c
+		}
+		denom := math.Log(float64(total + len(counts) + 1))
+		model := ngramModel{
+			logProb: make(map[string]float64, len(counts)),
+			unseen:  -denom,
+		}
+		for gram, c := range counts {
+			model.logProb[gram] = math.Log(float64(c+1)) - denom
+		}
+		models[lang] = model
+	}
+	return models
+}
+
+// ngramCounts counts the 1- to 3-grams of the words in text, with each word
+// padded by a space on both sides so word boundaries are part of the model.
+func ngramCounts(text string) map[string]int {
+	counts := make(map[string]int)
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	}) {
+		runes := []rune(" " + word + " ")
+		for n := 1; n <= 3; n++ {
+			for i := 0; i+n <= len(runes); i++ {
+				if gram := string(runes[i : i+n]); gram != " " {
+					counts[gram]++
+				}
+			}
+		}
+	}
+	return counts
+}
+
+// detectLanguage returns the most likely language code for text and a
+// confidence between 0 and 1: the posterior of the best language, scaled
+// down for short text. Text without letters yields "" and 0.
+func detectLanguage(text string) (string, float64) {
+	if len(text) > langDetectMaxChars {
+		text = text[:langDetectMaxChars]
+	}
+	counts := ngramCounts(text)
+	if len(counts) == 0 {
+		return "", 0
+	}
+
+	scores := make(map[string]float64, len(languageModels))
+	bestLang, best := "", math.Inf(-1)
+	for lang, model := range languageModels {
+		score := 0.0
+		for gram, c := range counts {
+			p, ok := model.logProb[gram]
+			if !ok {
+				p = model.unseen
+			}
+			score += float64(c) * p
+		}
+		scores[lang] = score
+		if score > best || (score == best && lang < bestLang) {
+			bestLang, best = lang, score
+		}
+	}
+
+	// Posterior of the winner, computed relative to it to avoid underflow.
+	sum := 0.0
+	for _, score := range scores {
+		sum += math.Exp(score - best)
+	}
+
+	letters := 0
+	for _, r := range text {
+		if unicode.IsLetter(r) {
+			letters++
+		}
+	}
+	support := min(1, float64(letters)/langDetectMinLetters)
+	return bestLang, support / sum
+}
+
+// pageLanguage picks the language a page is stored and indexed under: the
+// detected language when we are confident enough, otherwise the language of
+// the source it was fetched from.
+func pageLanguage(page Page, sourceLang string) (lang, detected string, confidence float64) {
+	detected, confidence = detectLanguage(page.Title + "\n" + page.Content)
+	if detected != "" && confidence >= langDetectMinConfidence {
+		return detected, detected, confidence
+	}
+	return sourceLang, detected, confidence
+}
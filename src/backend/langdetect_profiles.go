@@ -0,0 +1,135 @@
+package main
+
+// languageSamples is the training text the n-gram profiles are built from.
+// Each sample is ordinary encyclopedic prose, which is what the scraper
+// ingests; keep them roughly the same length so no language is favoured.
+var languageSamples = map[string]string{
+	"en": `The city is the capital and most populous city of the country. It is situated on the
+eastern coast of the island and has been the seat of government since the fifteenth century.
+Its history goes back to a small fishing village that grew into an important trading port during
+the middle ages. Today the city is known for its harbour, its old churches and palaces, and a
+large number of museums, which attract many visitors every year. The economy is based on services,
+trade and shipping, and the university is one of the oldest in the region. Public transport
+includes buses, trains and a modern metro system that connects the centre with the airport.
+Scientists have studied the climate of the area for many years and found that the weather
+changes quickly. The language which is spoken there has borrowed words from several neighbouring
+countries, and most people also understand English. After the war the population increased and
+new districts were built around the old town, where the streets are still narrow and winding.`,
+
+	"da": `Byen er landets hovedstad og største by. Den ligger på øens østkyst og har været sæde for
+regeringen siden det femtende århundrede. Dens historie går tilbage til en lille fiskerlandsby, som
+voksede til en vigtig handelshavn i middelalderen. I dag er byen kendt for sin havn, sine gamle kirker
+og slotte og et stort antal museer, som hvert år tiltrækker mange besøgende. Økonomien bygger på
+service, handel og skibsfart, og universitetet er et af de ældste i regionen. Den offentlige transport
+omfatter busser, tog og en moderne metro, der forbinder centrum med lufthavnen. Forskere har i mange
+år undersøgt områdets klima og fundet ud af, at vejret skifter hurtigt. Sproget, der tales der, har
+lånt ord fra flere nabolande, og de fleste mennesker forstår også engelsk. Efter krigen steg
+befolkningen, og der blev bygget nye kvarterer omkring den gamle bydel, hvor gaderne stadig er smalle
+og snoede. Det er også her, man finder mange af de kendte bygninger, som turisterne gerne vil se.`,
+
+	"sv": `Staden är landets huvudstad och största stad. Den ligger på öns östkust och har varit säte
+för regeringen sedan femtonhundratalet. Dess historia går tillbaka till en liten fiskeby som växte
+till en viktig handelshamn under medeltiden. I dag är staden känd för sin hamn, sina gamla kyrkor och
+slott och ett stort antal museer, som varje år lockar många besökare. Ekonomin bygger på tjänster,
+handel och sjöfart, och universitetet är ett av de äldsta i regionen. Kollektivtrafiken omfattar
+bussar, tåg och en modern tunnelbana som förbinder centrum med flygplatsen. Forskare har under många
+år studerat områdets klimat och funnit att vädret växlar snabbt. Språket som talas där har lånat ord
+från flera grannländer, och de flesta människor förstår också engelska. Efter kriget ökade
+befolkningen och nya stadsdelar byggdes runt den gamla staden, där gatorna fortfarande är smala och
+slingriga. Det är också här man hittar många av de kända byggnaderna som turisterna gärna vill se.`,
+
+	"no": `Byen er landets hovedstad og største by. Den ligger på øyas østkyst og har vært sete for
+regjeringen siden det femtende århundre. Historien går tilbake til en liten fiskelandsby som vokste
+til en viktig handelshavn i middelalderen. I dag er byen kjent for havnen, de gamle kirkene og slottene
+og et stort antall museer, som hvert år tiltrekker seg mange besøkende. Økonomien bygger på tjenester,
+handel og skipsfart, og universitetet er et av de eldste i regionen. Kollektivtransporten omfatter
+busser, tog og en moderne t-bane som forbinder sentrum med flyplassen. Forskere har i mange år
+undersøkt klimaet i området og funnet ut at været skifter raskt. Språket som snakkes der har lånt ord
+fra flere naboland, og de fleste forstår også engelsk. Etter krigen økte befolkningen, og det ble
+bygget nye bydeler rundt den gamle bydelen, hvor gatene fortsatt er smale og svingete. Det er også
+her man finner mange av de kjente bygningene som turistene gjerne vil se, og hvor livet er travelt.`,
+
+	"de": `Die Stadt ist die Hauptstadt und die bevölkerungsreichste Stadt des Landes. Sie liegt an der
+Ostküste der Insel und ist seit dem fünfzehnten Jahrhundert Sitz der Regierung. Ihre Geschichte reicht
+zurück bis zu einem kleinen Fischerdorf, das sich im Mittelalter zu einem wichtigen Handelshafen
+entwickelte. Heute ist die Stadt bekannt für ihren Hafen, ihre alten Kirchen und Schlösser sowie eine
+große Zahl von Museen, die jedes Jahr viele Besucher anziehen. Die Wirtschaft beruht auf
+Dienstleistungen, Handel und Schifffahrt, und die Universität gehört zu den ältesten der Region. Der
+öffentliche Verkehr umfasst Busse, Züge und eine moderne U-Bahn, die das Zentrum mit dem Flughafen
+verbindet. Wissenschaftler haben das Klima der Gegend viele Jahre lang untersucht und festgestellt,
+dass sich das Wetter schnell ändert. Die Sprache, die dort gesprochen wird, hat Wörter aus mehreren
+Nachbarländern übernommen, und die meisten Menschen verstehen auch Englisch. Nach dem Krieg wuchs die
+Bevölkerung, und rund um die Altstadt, deren Straßen noch immer eng und gewunden sind, entstanden neue
+Viertel.`,
+
+	"nl": `De stad is de hoofdstad en de grootste stad van het land. Ze ligt aan de oostkust van het
+eiland en is sinds de vijftiende eeuw de zetel van de regering. De geschiedenis gaat terug tot een
+klein vissersdorp dat in de middeleeuwen uitgroeide tot een belangrijke handelshaven. Tegenwoordig is
+de stad bekend om haar haven, haar oude kerken en paleizen en een groot aantal musea, die elk jaar
+veel bezoekers trekken. De economie is gebaseerd op diensten, handel en scheepvaart, en de
+universiteit is een van de oudste in de regio. Het openbaar vervoer omvat bussen, treinen en een
+moderne metro die het centrum met de luchthaven verbindt. Wetenschappers hebben het klimaat van het
+gebied jarenlang bestudeerd en ontdekt dat het weer snel verandert. De taal die daar gesproken wordt
+heeft woorden uit verschillende buurlanden overgenomen, en de meeste mensen begrijpen ook Engels. Na
+de oorlog nam de bevolking toe en werden er nieuwe wijken gebouwd rond de oude binnenstad, waar de
+straten nog altijd smal en kronkelig zijn.`,
+
+	"fr": `La ville est la capitale et la ville la plus peuplée du pays. Elle est située sur la côte est
+de l'île et elle est le siège du gouvernement depuis le quinzième siècle. Son histoire remonte à un
+petit village de pêcheurs qui est devenu un port de commerce important au Moyen Âge. Aujourd'hui, la
+ville est connue pour son port, ses vieilles églises et ses palais, ainsi que pour un grand nombre de
+musées qui attirent chaque année de nombreux visiteurs. L'économie repose sur les services, le
+commerce et la navigation, et l'université est l'une des plus anciennes de la région. Les transports
+publics comprennent des bus, des trains et un métro moderne qui relie le centre à l'aéroport. Les
+scientifiques ont étudié le climat de la région pendant de nombreuses années et ont constaté que le
+temps change rapidement. La langue qui y est parlée a emprunté des mots à plusieurs pays voisins, et
+la plupart des gens comprennent aussi l'anglais. Après la guerre, la population a augmenté et de
+nouveaux quartiers ont été construits autour de la vieille ville, où les rues sont encore étroites.`,
+
+	"es": `La ciudad es la capital y la ciudad más poblada del país. Está situada en la costa este de la
+isla y ha sido la sede del gobierno desde el siglo quince. Su historia se remonta a un pequeño pueblo
+de pescadores que se convirtió en un importante puerto comercial durante la Edad Media. Hoy en día la
+ciudad es conocida por su puerto, sus antiguas iglesias y palacios y un gran número de museos, que
+atraen a muchos visitantes cada año. La economía se basa en los servicios, el comercio y la
+navegación, y la universidad es una de las más antiguas de la región. El transporte público incluye
+autobuses, trenes y un moderno metro que une el centro con el aeropuerto. Los científicos han
+estudiado el clima de la zona durante muchos años y han descubierto que el tiempo cambia rápidamente.
+La lengua que se habla allí ha tomado palabras de varios países vecinos, y la mayoría de la gente
+también entiende inglés. Después de la guerra la población aumentó y se construyeron nuevos barrios
+alrededor del casco antiguo, donde las calles siguen siendo estrechas y sinuosas.`,
+
+	"it": `La città è la capitale e la città più popolosa del paese. Si trova sulla costa orientale
+dell'isola ed è sede del governo dal quindicesimo secolo. La sua storia risale a un piccolo villaggio
+di pescatori che nel medioevo divenne un importante porto commerciale. Oggi la città è conosciuta per
+il suo porto, le sue antiche chiese e i suoi palazzi e per un gran numero di musei, che ogni anno
+attirano molti visitatori. L'economia si basa sui servizi, sul commercio e sulla navigazione, e
+l'università è una delle più antiche della regione. Il trasporto pubblico comprende autobus, treni e
+una moderna metropolitana che collega il centro con l'aeroporto. Gli scienziati hanno studiato il
+clima della zona per molti anni e hanno scoperto che il tempo cambia rapidamente. La lingua che vi si
+parla ha preso in prestito parole da diversi paesi vicini, e la maggior parte delle persone capisce
+anche l'inglese. Dopo la guerra la popolazione è aumentata e sono stati costruiti nuovi quartieri
+intorno al centro storico, dove le strade sono ancora strette e tortuose.`,
+
+	"pt": `A cidade é a capital e a cidade mais populosa do país. Está situada na costa leste da ilha e
+é a sede do governo desde o século quinze. A sua história remonta a uma pequena aldeia de pescadores
+que se tornou um importante porto comercial durante a Idade Média. Hoje a cidade é conhecida pelo seu
+porto, pelas suas antigas igrejas e palácios e por um grande número de museus, que atraem muitos
+visitantes todos os anos. A economia baseia-se nos serviços, no comércio e na navegação, e a
+universidade é uma das mais antigas da região. Os transportes públicos incluem autocarros, comboios e
+um metro moderno que liga o centro ao aeroporto. Os cientistas estudaram o clima da região durante
+muitos anos e descobriram que o tempo muda rapidamente. A língua que ali se fala adotou palavras de
+vários países vizinhos, e a maioria das pessoas também compreende inglês. Depois da guerra a população
+aumentou e foram construídos novos bairros à volta da cidade velha, onde as ruas ainda são estreitas e
+sinuosas.`,
+
+	"fi": `Kaupunki on maan pääkaupunki ja suurin kaupunki. Se sijaitsee saaren itärannikolla, ja se on
+ollut hallituksen kotipaikka viidennestätoista vuosisadasta lähtien. Sen historia ulottuu pieneen
+kalastajakylään, joka kasvoi keskiajalla tärkeäksi kauppasatamaksi. Nykyään kaupunki tunnetaan
+satamastaan, vanhoista kirkoistaan ja linnoistaan sekä suuresta määrästä museoita, jotka houkuttelevat
+joka vuosi paljon kävijöitä. Talous perustuu palveluihin, kauppaan ja merenkulkuun, ja yliopisto on
+yksi alueen vanhimmista. Julkiseen liikenteeseen kuuluu busseja, junia ja moderni metro, joka yhdistää
+keskustan lentoasemaan. Tutkijat ovat tutkineet alueen ilmastoa monen vuoden ajan ja havainneet, että
+sää vaihtelee nopeasti. Siellä puhuttu kieli on lainannut sanoja useista naapurimaista, ja useimmat
+ihmiset ymmärtävät myös englantia. Sodan jälkeen väestö kasvoi, ja vanhan kaupungin ympärille
+rakennettiin uusia kaupunginosia, joissa kadut ovat yhä kapeita ja mutkittelevia.`,
+}
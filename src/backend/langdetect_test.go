@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetectLanguage(t *testing.T) {
+	testCases := []struct {
+		text string
+		want string
+	}{
+		{"Go is an open source programming language that makes it simple to build secure, scalable systems.", "en"},
+		{"København er Danmarks hovedstad og landets største by med omkring en million indbyggere.", "da"},
+		{"Stockholm är Sveriges huvudstad och landets största stad med nästan en miljon invånare.", "sv"},
+		{"Berlin ist die Hauptstadt der Bundesrepublik Deutschland und mit rund vier Millionen Einwohnern die größte Stadt.", "de"},
+		{"Paris est la capitale de la France et la ville la plus peuplée du pays depuis des siècles.", "fr"},
+		{"Madrid es la capital de España y la ciudad más poblada del país, con más de tres millones de habitantes.", "es"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.want, func(t *testing.T) {
+			lang, confidence := detectLanguage(tc.text)
+			assert.Equal(t, tc.want, lang)
+			assert.Greater(t, confidence, 0.0)
+		})
+	}
+}
+
+func TestDetectLanguageEmpty(t *testing.T) {
+	lang, confidence := detectLanguage("  123 !! ")
+	assert.Equal(t, "", lang)
+	assert.Equal(t, 0.0, confidence)
+}
+
+func TestPageLanguage(t *testing.T) {
+	danish := Page{
+		Title:   "Aarhus",
+		Content: "Aarhus er Danmarks næststørste by og ligger i Østjylland ved Aarhus Bugt. Byen har omkring 300.000 indbyggere og er hjemsted for Aarhus Universitet, der blev grundlagt i 1928.",
+	}
+	lang, detected, confidence := pageLanguage(danish, "en")
+	assert.Equal(t, "da", lang, "confident detection overrides the source language")
+	assert.Equal(t, "da", detected)
+	assert.GreaterOrEqual(t, confidence, langDetectMinConfidence)
+
+	short := Page{Title: "Go", Content: "TestContent"}
+	lang, _, confidence = pageLanguage(short, "en")
+	assert.Equal(t, "en", lang, "too little text keeps the source language")
+	assert.Less(t, confidence, langDetectMinConfidence)
+}
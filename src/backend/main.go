@@ -1,10 +1,13 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -12,6 +15,9 @@ import (
 )
 
 func main() {
+	// ctx is cancelled on SIGINT/SIGTERM so background work can stop cleanly.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	log.Printf("CONN_STR: %s", CONN_STR)
 	// initialiserer databasen og forbinder til den.
@@ -50,6 +56,11 @@ func main() {
 		log.Println("Successfully forced all users to reset their passwords")
 	}*/
 
+	// The pages index is created with the synonym rules in its settings.
+	if err := reloadSynonymRules(); err != nil {
+		log.Printf("Warning: searching without synonym rules: %v", err)
+	}
+
 	//Initialize Elasticsearch
 	initElasticsearch()
 
@@ -57,25 +68,12 @@ func main() {
 		log.Fatalf("Failed to sync pages: %v", err)
 	}
 
-	logPath := os.Getenv("SEARCH_LOG_PATH")
-	if logPath == "" {
-		logPath = "search.log" // Default for Docker
-	}
-
-	f, err := os.OpenFile(logPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
-	if err != nil {
-		log.Printf("Warning: could not open search log file: %v, using stdout instead", err)
-		searchLogger = log.New(os.Stdout, "SEARCH: ", log.LstdFlags)
-	} else {
-		log.Printf("Search logs will be written to %s", logPath)
-		searchLogger = log.New(f, "SEARCH: ", log.LstdFlags)
-		defer f.Close()
-	}
+	searchEvents = newSearchEventStoreFromEnv()
 
 	checkTables()
 
 	// Start the cron scheduler to run checkTables periodically
-	startCronScheduler()
+	startCronScheduler(ctx)
 
 	err = db.Ping()
 	if err != nil {
@@ -85,9 +83,12 @@ func main() {
 
 	startMonitoring()
 
+	// On-demand scrapes from the admin API stop on shutdown too.
+	scrapeCtx = ctx
+
 	//Scraper hvis ønsket - hvis miljø variabel er sat til 1.
 	if os.Getenv("SCRAPING_ENABLED") == "1" {
-		StartScraping(logPath)
+		go StartScraping(ctx)
 	}
 
 	// Detter er Gorilla Mux's route handler, i stedet for Flasks indbyggede router-handler
@@ -109,6 +110,13 @@ func main() {
 	appRouter.HandleFunc("/register", registerHandler).Methods("GET") //Register-side
 	appRouter.HandleFunc("/search", searchHandler).Methods("GET")
 	appRouter.HandleFunc("/reset-password", resetPasswordHandler).Methods("GET")
+	appRouter.HandleFunc("/submit", submitPageHandler).Methods("GET")
+	appRouter.HandleFunc("/r", clickRedirectHandler).Methods("GET")
+	appRouter.HandleFunc("/account/privacy", privacyPageHandler).Methods("GET")
+	appRouter.HandleFunc("/account/searches", searchesPageHandler).Methods("GET")
+	appRouter.HandleFunc("/collections", collectionsPageHandler).Methods("GET")
+	appRouter.HandleFunc("/collections/{id:[0-9]+}", collectionPageHandler).Methods("GET")
+	appRouter.HandleFunc("/shared/collections/{token:[0-9a-f]+}", sharedCollectionPageHandler).Methods("GET")
 
 	// Definerer api-erne
 	appRouter.HandleFunc("/api/login", apiLogin).Methods("POST")
@@ -118,6 +126,52 @@ func main() {
 	appRouter.HandleFunc("/api/register", apiRegisterHandler).Methods("POST")
 	appRouter.HandleFunc("/api/weather", weatherHandler).Methods("GET") //weather-side
 	appRouter.HandleFunc("/api/reset-password", apiResetPasswordHandler).Methods("POST")
+	appRouter.HandleFunc("/api/pages/versions", pageVersionsHandler).Methods("GET")
+	appRouter.HandleFunc("/api/pages/diff", pageDiffHandler).Methods("GET")
+	appRouter.HandleFunc("/api/submissions", apiSubmitPageHandler).Methods("POST")
+	appRouter.HandleFunc("/api/feedback", feedbackHandler).Methods("POST")
+	appRouter.HandleFunc("/api/account/search-history", searchHistorySettingHandler).Methods("POST")
+	appRouter.HandleFunc("/api/account/searches/export", exportSearchesHandler).Methods("GET")
+	appRouter.HandleFunc("/api/account/history", searchHistoryHandler).Methods("GET")
+	appRouter.HandleFunc("/api/account/history/clear", clearSearchHistoryHandler).Methods("POST")
+	appRouter.HandleFunc("/api/account/saved-searches", savedSearchesHandler).Methods("GET")
+	appRouter.HandleFunc("/api/account/saved-searches", saveSearchHandler).Methods("POST")
+	appRouter.HandleFunc("/api/account/saved-searches/{id:[0-9]+}/delete", deleteSavedSearchHandler).Methods("POST")
+	appRouter.HandleFunc("/api/account/saved-searches/{id:[0-9]+}/alert", searchAlertHandler).Methods("POST")
+	appRouter.HandleFunc("/api/account/saved-searches/{id:[0-9]+}/alert/delete", deleteSearchAlertHandler).Methods("POST")
+	appRouter.HandleFunc("/api/collections", collectionsHandler).Methods("GET")
+	appRouter.HandleFunc("/api/collections", createCollectionHandler).Methods("POST")
+	appRouter.HandleFunc("/api/collections/{id:[0-9]+}", collectionHandler).Methods("GET")
+	appRouter.HandleFunc("/api/collections/{id:[0-9]+}/delete", deleteCollectionHandler).Methods("POST")
+	appRouter.HandleFunc("/api/collections/{id:[0-9]+}/share", shareCollectionHandler).Methods("POST")
+	appRouter.HandleFunc("/api/collections/{id:[0-9]+}/pages", bookmarkHandler).Methods("POST")
+	appRouter.HandleFunc("/api/collections/{id:[0-9]+}/pages/delete", removeBookmarkHandler).Methods("POST")
+	appRouter.HandleFunc("/api/bookmarks", bookmarkHandler).Methods("POST")
+
+	// Admin-only api-er
+	adminRouter := appRouter.PathPrefix("/api/admin").Subrouter()
+	adminRouter.Use(requireAdmin)
+	adminRouter.HandleFunc("/scrape", adminScrapeHandler).Methods("POST")
+	adminRouter.HandleFunc("/jobs/{id:[0-9]+}", adminJobHandler).Methods("GET")
+	adminRouter.HandleFunc("/pages/rescrape", adminRescrapeHandler).Methods("POST")
+	adminRouter.HandleFunc("/submissions/{id:[0-9]+}/{action:approve|reject}", reviewSubmissionHandler).Methods("POST")
+	adminRouter.HandleFunc("/analytics", analyticsHandler).Methods("GET")
+	adminRouter.HandleFunc("/analytics/zero-results", zeroResultsHandler).Methods("GET")
+	adminRouter.HandleFunc("/analytics/clicks", queryClicksHandler).Methods("GET")
+	adminRouter.HandleFunc("/feedback", feedbackReportHandler).Methods("GET")
+	adminRouter.HandleFunc("/overrides", overrideHandler).Methods("POST")
+	adminRouter.HandleFunc("/synonyms", listSynonymsHandler).Methods("GET")
+	adminRouter.HandleFunc("/synonyms", createSynonymHandler).Methods("POST")
+	adminRouter.HandleFunc("/synonyms/history", synonymHistoryHandler).Methods("GET")
+	adminRouter.HandleFunc("/synonyms/{id:[0-9]+}", updateSynonymHandler).Methods("PUT")
+	adminRouter.HandleFunc("/synonyms/{id:[0-9]+}", deleteSynonymHandler).Methods("DELETE")
+
+	// Admin-only sider
+	adminPages := appRouter.PathPrefix("/admin").Subrouter()
+	adminPages.Use(requireAdmin)
+	adminPages.HandleFunc("/submissions", moderationHandler).Methods("GET")
+	adminPages.HandleFunc("/analytics", analyticsPageHandler).Methods("GET")
+	adminPages.HandleFunc("/feedback", feedbackPageHandler).Methods("GET")
 
 	// sørger for at vi kan bruge de statiske filer som ligger i static-mappen. ex: css.
 	r.PathPrefix("/static/").Handler(http.StripPrefix("/static/", http.FileServer(http.Dir(staticPath))))
@@ -125,8 +179,21 @@ func main() {
 	fmt.Println("Registering /metrics endpoint...")
 	r.Handle("/metrics", promhttp.Handler())
 
+	srv := &http.Server{Addr: ":8080", Handler: r}
+	go func() {
+		<-ctx.Done()
+		log.Println("Shutting down server...")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Error during server shutdown: %v", err)
+		}
+	}()
+
 	fmt.Println("Server running on http://localhost:8080")
 	//Starter serveren.
-	log.Fatal(http.ListenAndServe(":8080", r))
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
 
 }
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+const defaultMediaWikiEndpoint = "https://%s.wikipedia.org/w/api.php"
+
+var (
+	errWikiPageNotFound   = errors.New("wikipedia page not found")
+	errDisambiguationPage = errors.New("wikipedia page is a disambiguation page")
+)
+
+// httpDoer is the part of *http.Client the MediaWiki client uses, so tests
+// can swap in a client that talks to a local fake server.
+type httpDoer interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// mediaWikiClient talks to the MediaWiki Action API. endpoint is a format
+// string with a single %s for the language subdomain.
+type mediaWikiClient struct {
+	http     httpDoer
+	endpoint string
+}
+
+// wikiArticle is what we keep from an Action API page lookup.
+type wikiArticle struct {
+	PageID     int
+	RevisionID int64
+	Title      string
+	URL        string
+	Language   string
+	Extract    string
+	Categories []string
+	// Titles that redirected to this article during the lookup.
+	Redirects      []string
+	Disambiguation bool
+}
+
+// wikiClient is set up in config.go once the environment has been loaded.
+var wikiClient *mediaWikiClient
+
+func newMediaWikiClientFromEnv(client httpDoer) *mediaWikiClient {
+	endpoint := os.Getenv("MEDIAWIKI_API_URL")
+	if endpoint == "" {
+		endpoint = defaultMediaWikiEndpoint
+	}
+	return &mediaWikiClient{http: client, endpoint: endpoint}
+}
+
+func (c *mediaWikiClient) apiURL(lang string) string {
+	if strings.Contains(c.endpoint, "%s") {
+		return fmt.Sprintf(c.endpoint, lang)
+	}
+	return c.endpoint
+}
+
+// get sends an Action API request and decodes the JSON answer into out.
+func (c *mediaWikiClient) get(ctx context.Context, lang string, params url.Values, out any) error {
+	params.Set("format", "json")
+	params.Set("formatversion", "2")
+
+	req, err := http.NewRequestWithContext(withAPIRequest(ctx), http.MethodGet, c.apiURL(lang)+"?"+params.Encode(), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("mediawiki API returned %s", resp.Status)
+	}
+
+	var apiErr struct {
+		Error *struct {
+			Code string `json:"code"`
+			Info string `json:"info"`
+		} `json:"error"`
+	}
+	var raw json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+		return fmt.Errorf("error decoding mediawiki response: %w", err)
+	}
+	if err := json.Unmarshal(raw, &apiErr); err == nil && apiErr.Error != nil {
+		return fmt.Errorf("mediawiki API error %s: %s", apiErr.Error.Code, apiErr.Error.Info)
+	}
+	return json.Unmarshal(raw, out)
+}
+
+// Article looks up a title, following redirects, and returns its plain-text
+// extract together with the canonical title, URL, page ID and categories.
+func (c *mediaWikiClient) Article(ctx context.Context, lang, title string) (*wikiArticle, error) {
+	params := url.Values{}
+	params.Set("action", "query")
+	params.Set("prop", "extracts|categories|info|pageprops")
+	params.Set("ppprop", "disambiguation")
+	params.Set("explaintext", "1")
+	params.Set("exsectionformat", "plain")
+	params.Set("inprop", "url")
+	params.Set("cllimit", "max")
+	params.Set("clshow", "!hidden")
+	params.Set("redirects", "1")
+	params.Set("titles", title)
+
+	var res struct {
+		Query struct {
+			Redirects []struct {
+				From string `json:"from"`
+				To   string `json:"to"`
+			} `json:"redirects"`
+			Pages []struct {
+				PageID       int    `json:"pageid"`
+				Title        string `json:"title"`
+				Missing      bool   `json:"missing"`
+				Invalid      bool   `json:"invalid"`
+				Extract      string `json:"extract"`
+				CanonicalURL string `json:"canonicalurl"`
+				LastRevID    int64  `json:"lastrevid"`
+				Categories   []struct {
+					Title string `json:"title"`
+				} `json:"categories"`
+				PageProps map[string]string `json:"pageprops"`
+			} `json:"pages"`
+		} `json:"query"`
+	}
+	if err := c.get(ctx, lang, params, &res); err != nil {
+		return nil, err
+	}
+
+	if len(res.Query.Pages) == 0 {
+		return nil, errWikiPageNotFound
+	}
+	p := res.Query.Pages[0]
+	if p.Missing || p.Invalid || p.PageID == 0 {
+		return nil, fmt.Errorf("%w: %s (%s)", errWikiPageNotFound, title, lang)
+	}
+
+	article := &wikiArticle{
+		PageID:     p.PageID,
+		RevisionID: p.LastRevID,
+		Title:      p.Title,
+		URL:        p.CanonicalURL,
+		Language:   lang,
+		Extract:    strings.TrimSpace(p.Extract),
+	}
+	_, article.Disambiguation = p.PageProps["disambiguation"]
+	for _, cat := range p.Categories {
+		// Strip the localized namespace, e.g. "Kategori:" or "Category:".
+		name := cat.Title
+		if i := strings.Index(name, ":"); i >= 0 {
+			name = name[i+1:]
+		}
+		article.Categories = append(article.Categories, name)
+	}
+	for _, r := range res.Query.Redirects {
+		article.Redirects = append(article.Redirects, r.From)
+	}
+	return article, nil
+}
+
+// LastRevision returns the canonical title and current revision ID of an
+// article, following redirects, so a recrawl can tell whether it changed
+// without fetching its content.
+func (c *mediaWikiClient) LastRevision(ctx context.Context, lang, title string) (string, int64, error) {
+	params := url.Values{}
+	params.Set("action", "query")
+	params.Set("prop", "info")
+	params.Set("redirects", "1")
+	params.Set("titles", title)
+
+	var res struct {
+		Query struct {
+			Pages []struct {
+				PageID    int    `json:"pageid"`
+				Title     string `json:"title"`
+				Missing   bool   `json:"missing"`
+				Invalid   bool   `json:"invalid"`
+				LastRevID int64  `json:"lastrevid"`
+			} `json:"pages"`
+		} `json:"query"`
+	}
+	if err := c.get(ctx, lang, params, &res); err != nil {
+		return "", 0, err
+	}
+	if len(res.Query.Pages) == 0 {
+		return "", 0, errWikiPageNotFound
+	}
+	p := res.Query.Pages[0]
+	if p.Missing || p.Invalid || p.PageID == 0 {
+		return "", 0, fmt.Errorf("%w: %s (%s)", errWikiPageNotFound, title, lang)
+	}
+	return p.Title, p.LastRevID, nil
+}
+
+// Search returns up to limit article titles matching query, best match
+// first. When nothing matches but the API suggests a spelling correction,
+// the suggestion is searched instead, once.
+func (c *mediaWikiClient) Search(ctx context.Context, lang, query string, limit int) ([]string, error) {
+	return c.search(ctx, lang, query, limit, true)
+}
+
+func (c *mediaWikiClient) search(ctx context.Context, lang, query string, limit int, followSuggestion bool) ([]string, error) {
+	params := url.Values{}
+	params.Set("action", "query")
+	params.Set("list", "search")
+	params.Set("srsearch", query)
+	params.Set("srnamespace", "0")
+	params.Set("srlimit", strconv.Itoa(limit))
+	params.Set("srinfo", "suggestion")
+	params.Set("srprop", "")
+
+	var res struct {
+		Query struct {
+			SearchInfo struct {
+				Suggestion string `json:"suggestion"`
+			} `json:"searchinfo"`
+			Search []struct {
+				Title string `json:"title"`
+			} `json:"search"`
+		} `json:"query"`
+	}
+	if err := c.get(ctx, lang, params, &res); err != nil {
+		return nil, err
+	}
+
+	if len(res.Query.Search) == 0 {
+		suggestion := res.Query.SearchInfo.Suggestion
+		if followSuggestion && suggestion != "" && !strings.EqualFold(suggestion, query) {
+			return c.search(ctx, lang, suggestion, limit, false)
+		}
+		return nil, nil
+	}
+
+	titles := make([]string, 0, len(res.Query.Search))
+	for _, hit := range res.Query.Search {
+		titles = append(titles, hit.Title)
+	}
+	return titles, nil
+}
+
+// Links returns up to limit article titles linked from title, used to find
+// the candidates listed on a disambiguation page.
+func (c *mediaWikiClient) Links(ctx context.Context, lang, title string, limit int) ([]string, error) {
+	params := url.Values{}
+	params.Set("action", "query")
+	params.Set("prop", "links")
+	params.Set("plnamespace", "0")
+	params.Set("pllimit", strconv.Itoa(limit))
+	params.Set("titles", title)
+
+	var res struct {
+		Query struct {
+			Pages []struct {
+				Links []struct {
+					Title string `json:"title"`
+				} `json:"links"`
+			} `json:"pages"`
+		} `json:"query"`
+	}
+	if err := c.get(ctx, lang, params, &res); err != nil {
+		return nil, err
+	}
+
+	var titles []string
+	for _, p := range res.Query.Pages {
+		for _, l := range p.Links {
+			titles = append(titles, l.Title)
+		}
+	}
+	return titles, nil
+}
+
+// Structure renders an article and extracts its section headings, infobox,
+// image captions and outgoing article links.
+func (c *mediaWikiClient) Structure(ctx context.Context, lang, title string) (*articleStructure, error) {
+	params := url.Values{}
+	params.Set("action", "parse")
+	params.Set("page", title)
+	params.Set("prop", "text|sections|links")
+	params.Set("redirects", "1")
+	params.Set("disableeditsection", "1")
+	params.Set("disabletoc", "1")
+
+	var res struct {
+		Parse struct {
+			Text     string `json:"text"`
+			Sections []struct {
+				Line string `json:"line"`
+			} `json:"sections"`
+			Links []struct {
+				NS     int    `json:"ns"`
+				Title  string `json:"title"`
+				Exists bool   `json:"exists"`
+			} `json:"links"`
+		} `json:"parse"`
+	}
+	if err := c.get(ctx, lang, params, &res); err != nil {
+		return nil, err
+	}
+
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(res.Parse.Text))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing article HTML: %w", err)
+	}
+
+	structure := &articleStructure{
+		Infobox:       parseInfobox(doc),
+		ImageCaptions: parseImageCaptions(doc),
+	}
+	for _, s := range res.Parse.Sections {
+		if heading := htmlText(s.Line); heading != "" {
+			structure.Sections = append(structure.Sections, heading)
+		}
+	}
+	for _, l := range res.Parse.Links {
+		if l.NS == 0 && l.Exists && len(structure.Links) < maxOutgoingLinks {
+			structure.Links = append(structure.Links, l.Title)
+		}
+	}
+	return structure, nil
+}
+
+// toPage converts an article to the Page stored in the database.
+func (a *wikiArticle) toPage() Page {
+	return Page{
+		Title:      a.Title,
+		URL:        a.URL,
+		Content:    a.Extract,
+		Language:   a.Language,
+		PageID:     a.PageID,
+		RevisionID: a.RevisionID,
+		Categories: a.Categories,
+		Redirects:  a.Redirects,
+		Summary:    firstParagraph(a.Extract),
+		Source:     sourceWikipedia,
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newFakeMediaWiki(t *testing.T, handler http.HandlerFunc) *mediaWikiClient {
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	return &mediaWikiClient{http: srv.Client(), endpoint: srv.URL + "/%s/w/api.php"}
+}
+
+func TestMediaWikiArticle(t *testing.T) {
+	client := newFakeMediaWiki(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/da/w/api.php", r.URL.Path)
+		assert.Equal(t, "Kbh", r.URL.Query().Get("titles"))
+		w.Write([]byte(`{"batchcomplete":true,"query":{
+			"redirects":[{"from":"Kbh","to":"København"}],
+			"pages":[{"pageid":1234,"ns":0,"title":"København","lastrevid":99,
+				"canonicalurl":"https://da.wikipedia.org/wiki/K%C3%B8benhavn",
+				"extract":"København er Danmarks hovedstad.\n",
+				"categories":[{"ns":14,"title":"Kategori:Hovedstæder i Europa"}]}]}}`))
+	})
+
+	article, err := client.Article(context.Background(), "da", "Kbh")
+	assert.NoError(t, err)
+	assert.Equal(t, 1234, article.PageID)
+	assert.Equal(t, "København", article.Title)
+	assert.Equal(t, "https://da.wikipedia.org/wiki/K%C3%B8benhavn", article.URL)
+	assert.Equal(t, "København er Danmarks hovedstad.", article.Extract)
+	assert.Equal(t, []string{"Hovedstæder i Europa"}, article.Categories)
+	assert.Equal(t, []string{"Kbh"}, article.Redirects)
+}
+
+func TestMediaWikiArticleErrors(t *testing.T) {
+	testCases := []struct {
+		name string
+		body string
+		code int
+		want error
+	}{
+		{name: "Missing page", code: 200, body: `{"query":{"pages":[{"ns":0,"title":"Nope","missing":true}]}}`, want: errWikiPageNotFound},
+		{name: "API error", code: 200, body: `{"error":{"code":"badvalue","info":"bad"}}`},
+		{name: "Server error", code: 503, body: `oops`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := newFakeMediaWiki(t, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tc.code)
+				w.Write([]byte(tc.body))
+			})
+
+			_, err := client.Article(context.Background(), "en", "Nope")
+			assert.Error(t, err)
+			if tc.want != nil {
+				assert.True(t, errors.Is(err, tc.want))
+			}
+		})
+	}
+}
+
+func TestMediaWikiSearchFallsBackToSuggestion(t *testing.T) {
+	client := newFakeMediaWiki(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("srsearch") {
+		case "golang progamming":
+			w.Write([]byte(`{"query":{"searchinfo":{"suggestion":"golang programming"},"search":[]}}`))
+		case "golang programming":
+			w.Write([]byte(`{"query":{"search":[{"ns":0,"title":"Go (programming language)"},{"ns":0,"title":"Golang"}]}}`))
+		default:
+			t.Errorf("unexpected search %q", r.URL.Query().Get("srsearch"))
+		}
+	})
+
+	titles, err := client.Search(context.Background(), "en", "golang progamming", 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Go (programming language)", "Golang"}, titles)
+}
+
+func TestMediaWikiSearchFollowsOneSuggestion(t *testing.T) {
+	requests := 0
+	client := newFakeMediaWiki(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		// Each query suggests the other one.
+		suggestion := "colour"
+		if r.URL.Query().Get("srsearch") == "colour" {
+			suggestion = "color"
+		}
+		w.Write([]byte(`{"query":{"searchinfo":{"suggestion":"` + suggestion + `"},"search":[]}}`))
+	})
+
+	titles, err := client.Search(context.Background(), "en", "color", 2)
+	assert.NoError(t, err)
+	assert.Empty(t, titles)
+	assert.Equal(t, 2, requests)
+}
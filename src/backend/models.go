@@ -10,6 +10,7 @@ type User struct {
 	Email           string `json:"email"`
 	Password        string `json:"password"`
 	PasswordChanged bool
+	IsAdmin         bool `json:"is_admin"`
 }
 
 type PageData struct {
@@ -26,6 +27,27 @@ type Page struct {
 	Content     string    `json:"content"`
 	Language    string    `json:"language"`
 	LastUpdated time.Time `json:"last_updated"`
+	PageID      int       `json:"page_id,omitempty"`
+	RevisionID  int64     `json:"-"`
+	Categories  []string  `json:"categories,omitempty"`
+	// Source is sourceWikipedia or sourceWeb.
+	Source      string    `json:"-"`
+	PublishedAt time.Time `json:"published_at,omitzero"`
+	// Pages with near-identical content share a cluster ID.
+	ClusterID string `json:"cluster_id,omitempty"`
+	// Variant titles that redirect to this page.
+	Redirects []string `json:"-"`
+
+	// Structured content extracted from the article.
+	Summary       string            `json:"summary,omitempty"`
+	Sections      []string          `json:"sections,omitempty"`
+	Infobox       map[string]string `json:"infobox,omitempty"`
+	ImageCaptions []string          `json:"image_captions,omitempty"`
+	Links         []string          `json:"links,omitempty"`
+
+	// HTTP validators from the last fetch, used for conditional recrawls.
+	ETag         string `json:"-"`
+	LastModified string `json:"-"`
 }
 
 type WeatherResponse struct {
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math/bits"
+	"strings"
+	"unicode"
+)
+
+// Near-duplicate detection with 64-bit SimHash (Charikar) over word
+// shingles. Pages whose fingerprints differ in at most
+// nearDuplicateMaxDistance bits share a cluster, and search shows one page
+// per cluster.
+//
+// Candidates are found by splitting the fingerprint into four 16-bit bands:
+// two fingerprints within three bits of each other must agree on at least
+// one band. With four differing bits every band may differ, so the distance
+// is capped at maxNearDuplicateDistance; a larger one would need more bands,
+// and thus a migration for their indexes.
+
+const (
+	simhashShingleSize       = 2
+	maxNearDuplicateDistance = 3
+)
+
+// Overridden from the environment in config.go.
+var nearDuplicateMaxDistance = 3
+
+// simhash fingerprints text so that similar texts get fingerprints with a
+// small Hamming distance.
+func simhash(text string) uint64 {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	if len(words) == 0 {
+		return 0
+	}
+
+	var weights [64]int
+	shingles := max(1, len(words)-simhashShingleSize+1)
+	for i := 0; i < shingles; i++ {
+		h := fnv.New64a()
+		h.Write([]byte(strings.Join(words[i:min(i+simhashShingleSize, len(words))], " ")))
+		sum := h.Sum64()
+		for bit := 0; bit < 64; bit++ {
+			if sum&(1<<bit) != 0 {
+				weights[bit]++
+			} else {
+				weights[bit]--
+			}
+		}
+	}
+
+	var fp uint64
+	for bit, w := range weights {
+		if w > 0 {
+			fp |= 1 << bit
+		}
+	}
+	return fp
+}
+
+func hammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// simhashBand returns the i'th 16-bit band of a fingerprint, matching the
+// band expressions indexed on pages.simhash.
+func simhashBand(fp uint64, i int) int64 {
+	return int64((fp >> (16 * i)) & 0xffff)
+}
+
+// assignCluster stores the fingerprint of the page at url and links it to
+// the cluster of its nearest near-duplicate. A page with no near-duplicate
+// is its own cluster, identified by its URL. Returns the cluster ID.
+func assignCluster(url string, fp uint64) (string, error) {
+	rows, err := db.Query(`
+		SELECT url, simhash, COALESCE(cluster_id, url)
+		FROM pages
+		WHERE url <> $1 AND gone_at IS NULL AND simhash IS NOT NULL
+		  AND (((simhash >> 0) & 65535) = $2
+		    OR ((simhash >> 16) & 65535) = $3
+		    OR ((simhash >> 32) & 65535) = $4
+		    OR ((simhash >> 48) & 65535) = $5)
+	`, url, simhashBand(fp, 0), simhashBand(fp, 1), simhashBand(fp, 2), simhashBand(fp, 3))
+	if err != nil {
+		return "", fmt.Errorf("error finding near-duplicates: %w", err)
+	}
+
+	cluster, nearest, best := url, "", nearDuplicateMaxDistance+1
+	for rows.Next() {
+		var otherURL, otherCluster string
+		var otherFP int64
+		if err := rows.Scan(&otherURL, &otherFP, &otherCluster); err != nil {
+			rows.Close()
+			return "", fmt.Errorf("error scanning near-duplicate: %w", err)
+		}
+		if d := hammingDistance(fp, uint64(otherFP)); d < best {
+			cluster, nearest, best = otherCluster, otherURL, d
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return "", fmt.Errorf("error finding near-duplicates: %w", err)
+	}
+
+	if _, err := db.Exec("UPDATE pages SET simhash = $2, cluster_id = $3 WHERE url = $1",
+		url, int64(fp), cluster); err != nil {
+		return "", fmt.Errorf("error saving page fingerprint: %w", err)
+	}
+
+	if nearest != "" {
+		nearDuplicatesTotal.Inc()
+		log.Printf("Page %s is a near-duplicate of %s (distance %d)", url, nearest, best)
+	}
+	return cluster, nil
+}
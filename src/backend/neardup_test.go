@@ -0,0 +1,68 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+const neardupArticle = `Copenhagen is the capital and most populous city of Denmark, with a population
+of around 660,000 in the municipality and 1.4 million in the urban area. The city is situated on the
+islands of Zealand and Amager, separated from Malmö in Sweden by the Øresund strait. Originally a Viking
+fishing village established in the 10th century, Copenhagen became the capital of Denmark in the early
+15th century. In the 17th century it consolidated its position as a regional centre of power with its
+institutions, defences and armed forces. Since the turn of the 21st century Copenhagen has seen strong
+urban and cultural development, facilitated by investment in its institutions and infrastructure.`
+
+func TestSimhashNearDuplicates(t *testing.T) {
+	// SimHash is only stable on article-length text, so use two paragraphs.
+	article := neardupArticle + "\n" + languageSamples["en"]
+	fp := simhash(article)
+	assert.Equal(t, fp, simhash(article))
+
+	for _, word := range []string{"strong", "village", "harbour", "century"} {
+		edited := strings.Replace(article, word, "remarkable", 1)
+		assert.LessOrEqual(t, hammingDistance(fp, simhash(edited)), nearDuplicateMaxDistance,
+			"a one-word edit (%s) should stay a near-duplicate", word)
+	}
+
+	other := simhash(languageSamples["da"])
+	assert.Greater(t, hammingDistance(fp, other), nearDuplicateMaxDistance,
+		"unrelated text should not be a near-duplicate")
+}
+
+func TestAssignCluster(t *testing.T) {
+	fp := simhash(neardupArticle)
+
+	testCases := []struct {
+		name      string
+		otherFP   uint64
+		wantClust string
+	}{
+		{name: "Joins the cluster of a near-duplicate", otherFP: fp ^ 0b101, wantClust: "https://en.wikipedia.org/wiki/Copenhagen"},
+		{name: "Starts its own cluster", otherFP: fp ^ 0xff, wantClust: "https://en.m.wikipedia.org/wiki/Copenhagen"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockDB, mock := setupMockDB()
+			defer mockDB.Close()
+
+			url := "https://en.m.wikipedia.org/wiki/Copenhagen"
+			mock.ExpectQuery("SELECT url, simhash").
+				WithArgs(url, simhashBand(fp, 0), simhashBand(fp, 1), simhashBand(fp, 2), simhashBand(fp, 3)).
+				WillReturnRows(sqlmock.NewRows([]string{"url", "simhash", "cluster_id"}).
+					AddRow("https://en.wikipedia.org/wiki/Copenhagen", int64(tc.otherFP), "https://en.wikipedia.org/wiki/Copenhagen"))
+			mock.ExpectExec("UPDATE pages SET simhash").
+				WithArgs(url, int64(fp), tc.wantClust).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+
+			cluster, err := assignCluster(url, fp)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.wantClust, cluster)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/temoto/robotstxt"
+)
+
+const defaultScraperUserAgent = "GoSearchBot/1.0 (+https://gosearch.dk/about)"
+
+var errDisallowedByRobots = errors.New("URL disallowed by robots.txt")
+
+// crawlPolicy keeps robots.txt rules and per-domain pacing that are shared by
+// every request the scraper makes, no matter which collector sends it.
+type crawlPolicy struct {
+	userAgent    string
+	robotsTTL    time.Duration
+	minDelay     time.Duration
+	maxPerDomain int
+	base         http.RoundTripper
+	// exemptAPIs skips robots.txt for API requests. APIs are made for
+	// automated clients and have their own usage rules, and Wikipedia's
+	// robots.txt disallows /w/, including /w/api.php, so scraping Wikipedia
+	// through the MediaWiki API needs SCRAPER_ROBOTS_EXEMPT_API=1. API
+	// requests keep the User-Agent and per-domain limits either way.
+	exemptAPIs bool
+
+	mu      sync.Mutex
+	robots  map[string]*robotsEntry
+	domains map[string]*domainLimiter
+}
+
+// robotsEntry is a host's robots.txt, or the error fetching it. ready is
+// closed once the fetch is done; the other fields must not be read before.
+type robotsEntry struct {
+	ready   chan struct{}
+	data    *robotstxt.RobotsData
+	err     error
+	expires time.Time
+}
+
+func (e *robotsEntry) expired() bool {
+	select {
+	case <-e.ready:
+		return time.Now().After(e.expires)
+	default:
+		return false
+	}
+}
+
+// robotsRetryTTL caps how long a failed robots.txt fetch is cached. Until it
+// expires, the host is not crawled.
+const robotsRetryTTL = 10 * time.Minute
+
+var robotsDisallowAll, _ = robotstxt.FromString("User-agent: *\nDisallow: /\n")
+
+// domainLimiter bounds the number of in-flight requests to one host and
+// spaces consecutive requests by at least the configured delay.
+type domainLimiter struct {
+	slots chan struct{}
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+// scraperPolicy and scraperHTTPClient are set up in config.go once the
+// environment has been loaded. Every outgoing scraper request goes through
+// scraperHTTPClient.
+var (
+	scraperPolicy     *crawlPolicy
+	scraperHTTPClient httpDoer
+)
+
+type apiRequestKey struct{}
+
+// withAPIRequest marks requests made with ctx as API calls, such as the
+// MediaWiki client's requests to /w/api.php.
+func withAPIRequest(ctx context.Context) context.Context {
+	return context.WithValue(ctx, apiRequestKey{}, true)
+}
+
+func isAPIRequest(ctx context.Context) bool {
+	api, _ := ctx.Value(apiRequestKey{}).(bool)
+	return api
+}
+
+func newCrawlPolicyFromEnv() *crawlPolicy {
+	userAgent := os.Getenv("SCRAPER_USER_AGENT")
+	if userAgent == "" {
+		userAgent = defaultScraperUserAgent
+	}
+
+	policy := newCrawlPolicy(
+		userAgent,
+		getEnvDuration("SCRAPER_DOMAIN_DELAY", time.Second),
+		getEnvInt("SCRAPER_DOMAIN_CONCURRENCY", 2),
+		getEnvDuration("SCRAPER_ROBOTS_TTL", 24*time.Hour),
+		publicTransport(),
+	)
+	policy.exemptAPIs = os.Getenv("SCRAPER_ROBOTS_EXEMPT_API") == "1"
+	return policy
+}
+
+// publicTransport only connects to public addresses. Scraped URLs come from
+// users, sitemaps and feeds, and their redirects can point anywhere, so
+// without it a submitted page could have internal services fetched and
+// published as a search result. It ignores HTTP_PROXY and HTTPS_PROXY: a
+// proxy would make the connections, so their addresses could not be
+// checked here.
+func publicTransport() *http.Transport {
+	dialer := &net.Dialer{Timeout: 10 * time.Second, Control: refusePrivateAddresses}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return transport
+}
+
+// checkScraperRedirect only follows redirects to web pages. Each hop is
+// dialled through publicTransport again.
+func checkScraperRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("stopped after 10 redirects")
+	}
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return fmt.Errorf("refusing redirect to %s", req.URL)
+	}
+	return nil
+}
+
+func newCrawlPolicy(userAgent string, minDelay time.Duration, maxPerDomain int, robotsTTL time.Duration, base http.RoundTripper) *crawlPolicy {
+	if maxPerDomain < 1 {
+		maxPerDomain = 1
+	}
+	return &crawlPolicy{
+		userAgent:    userAgent,
+		robotsTTL:    robotsTTL,
+		minDelay:     minDelay,
+		maxPerDomain: maxPerDomain,
+		base:         base,
+		robots:       make(map[string]*robotsEntry),
+		domains:      make(map[string]*domainLimiter),
+	}
+}
+
+// RoundTrip makes crawlPolicy usable as the transport of a colly collector or
+// a plain http.Client.
+func (p *crawlPolicy) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Host
+
+	delay := p.minDelay
+	if !p.exemptAPIs || !isAPIRequest(req.Context()) {
+		robots, err := p.robotsFor(req.Context(), req.URL.Scheme, host)
+		if err != nil {
+			scraperSkippedURLs.WithLabelValues(host, "robots_unavailable").Inc()
+			return nil, fmt.Errorf("could not load robots.txt for %s: %w", host, err)
+		}
+
+		if !robots.TestAgent(req.URL.RequestURI(), p.userAgent) {
+			scraperSkippedURLs.WithLabelValues(host, "robots_disallowed").Inc()
+			log.Printf("Skipping %s: disallowed by robots.txt", req.URL)
+			return nil, errDisallowedByRobots
+		}
+
+		if crawlDelay := robots.FindGroup(p.userAgent).CrawlDelay; crawlDelay > delay {
+			delay = crawlDelay
+		}
+	}
+
+	limiter := p.limiterFor(host)
+	release, err := limiter.acquire(req.Context(), delay)
+	if err != nil {
+		scraperSkippedURLs.WithLabelValues(host, "cancelled").Inc()
+		return nil, err
+	}
+	defer release()
+
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", p.userAgent)
+
+	scraperRequestsTotal.WithLabelValues(host).Inc()
+	return p.base.RoundTrip(req)
+}
+
+// robotsFor returns the cached robots.txt rules for a host, fetching them
+// again once the cached copy has expired. Concurrent callers share one fetch.
+func (p *crawlPolicy) robotsFor(ctx context.Context, scheme, host string) (*robotstxt.RobotsData, error) {
+	p.mu.Lock()
+	entry, ok := p.robots[host]
+	if !ok || entry.expired() {
+		entry = &robotsEntry{ready: make(chan struct{})}
+		p.robots[host] = entry
+		p.mu.Unlock()
+
+		// The fetch outlives a cancelled caller, as others may be waiting.
+		entry.data, entry.err = p.fetchRobots(context.WithoutCancel(ctx), scheme, host)
+		ttl := p.robotsTTL
+		if entry.err != nil || entry.data == robotsDisallowAll {
+			ttl = min(ttl, robotsRetryTTL)
+		}
+		entry.expires = time.Now().Add(ttl)
+		close(entry.ready)
+	} else {
+		p.mu.Unlock()
+	}
+
+	select {
+	case <-entry.ready:
+		return entry.data, entry.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// fetchRobots follows RFC 9309: a 4xx means there are no rules, and a 5xx
+// that the whole host is disallowed until robots.txt can be fetched again.
+func (p *crawlPolicy) fetchRobots(ctx context.Context, scheme, host string) (*robotstxt.RobotsData, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, scheme+"://"+host+"/robots.txt", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", p.userAgent)
+
+	resp, err := p.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 500 {
+		return robotsDisallowAll, nil
+	}
+	return robotstxt.FromResponse(resp)
+}
+
+func (p *crawlPolicy) limiterFor(host string) *domainLimiter {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	limiter, ok := p.domains[host]
+	if !ok {
+		limiter = &domainLimiter{slots: make(chan struct{}, p.maxPerDomain)}
+		p.domains[host] = limiter
+	}
+	return limiter
+}
+
+// acquire blocks until a concurrency slot is free and the host's delay has
+// passed. The returned func must be called to give the slot back.
+func (l *domainLimiter) acquire(ctx context.Context, delay time.Duration) (func(), error) {
+	select {
+	case l.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	release := func() { <-l.slots }
+
+	l.mu.Lock()
+	now := time.Now()
+	start := l.next
+	if start.Before(now) {
+		start = now
+	}
+	l.next = start.Add(delay)
+	l.mu.Unlock()
+
+	if wait := time.Until(start); wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			release()
+			return nil, ctx.Err()
+		}
+	}
+
+	return release, nil
+}
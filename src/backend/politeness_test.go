@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCrawlPolicyRespectsRobotsTxt(t *testing.T) {
+	var userAgent string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			w.Write([]byte("User-agent: *\nDisallow: /private\n"))
+			return
+		}
+		userAgent = r.Header.Get("User-Agent")
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	policy := newCrawlPolicy("TestBot/1.0", 0, 1, time.Hour, http.DefaultTransport)
+	client := &http.Client{Transport: policy}
+
+	resp, err := client.Get(srv.URL + "/wiki/Go")
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "TestBot/1.0", userAgent, "Requests should carry the configured User-Agent")
+
+	_, err = client.Get(srv.URL + "/private/page")
+	assert.True(t, errors.Is(err, errDisallowedByRobots), "Disallowed paths should not be fetched")
+}
+
+func TestCrawlPolicyExemptsAPIsOnlyWhenConfigured(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			w.Write([]byte("User-agent: *\nDisallow: /w/\n"))
+			return
+		}
+		w.Write([]byte("{}"))
+	}))
+	defer srv.Close()
+
+	policy := newCrawlPolicy("TestBot/1.0", 0, 1, time.Hour, http.DefaultTransport)
+	client := &http.Client{Transport: policy}
+	req, _ := http.NewRequestWithContext(withAPIRequest(context.Background()), "GET", srv.URL+"/w/api.php", nil)
+
+	_, err := client.Do(req)
+	assert.True(t, errors.Is(err, errDisallowedByRobots), "API requests should obey robots.txt by default")
+
+	policy.exemptAPIs = true
+	resp, err := client.Do(req)
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+	}
+}
+
+func TestCrawlPolicyRobotsFailures(t *testing.T) {
+	var fetches atomic.Int32
+	status := http.StatusServiceUnavailable
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			fetches.Add(1)
+			time.Sleep(50 * time.Millisecond)
+			w.WriteHeader(status)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	policy := newCrawlPolicy("TestBot/1.0", 0, 4, time.Hour, http.DefaultTransport)
+	client := &http.Client{Transport: policy}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := client.Get(srv.URL + "/page")
+			assert.True(t, errors.Is(err, errDisallowedByRobots), "A 5xx robots.txt should disallow the host")
+		}()
+	}
+	wg.Wait()
+	assert.EqualValues(t, 1, fetches.Load(), "Concurrent requests should share one robots.txt fetch")
+
+	_, err := client.Get(srv.URL + "/page")
+	assert.True(t, errors.Is(err, errDisallowedByRobots))
+	assert.EqualValues(t, 1, fetches.Load(), "A 5xx robots.txt should be cached")
+
+	// Once the failure expires, a 4xx allows everything.
+	status = http.StatusNotFound
+	policy.robots[srv.Listener.Addr().String()].expires = time.Now()
+	resp, err := client.Get(srv.URL + "/page")
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+	}
+	assert.EqualValues(t, 2, fetches.Load())
+}
+
+func TestCrawlPolicyHonoursCrawlDelay(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			w.Write([]byte("User-agent: *\nCrawl-delay: 0.2\n"))
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	policy := newCrawlPolicy("TestBot/1.0", 0, 2, time.Hour, http.DefaultTransport)
+	client := &http.Client{Transport: policy}
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		resp, err := client.Get(srv.URL + "/page")
+		assert.NoError(t, err)
+		resp.Body.Close()
+	}
+	assert.GreaterOrEqual(t, time.Since(start), 400*time.Millisecond, "Requests should be spaced by the crawl delay")
+}
+
+func TestPublicTransportRefusesInternalAddresses(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("internal"))
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: publicTransport(), CheckRedirect: checkScraperRedirect}
+	_, err := client.Get(srv.URL)
+	assert.ErrorContains(t, err, "is not public")
+
+	req := httptest.NewRequest("GET", "file:///etc/passwd", nil)
+	assert.Error(t, checkScraperRedirect(req, []*http.Request{httptest.NewRequest("GET", "https://example.com/", nil)}))
+}
+
+func TestRefusePrivateAddresses(t *testing.T) {
+	for _, address := range []string{"127.0.0.1:80", "10.1.2.3:80", "169.254.169.254:80", "100.64.0.1:80",
+		"0.1.2.3:80", "224.0.0.1:80", "[::1]:80", "[fd00::1]:80", "[::ffff:192.168.0.1]:80"} {
+		assert.Error(t, refusePrivateAddresses("tcp", address, nil), address)
+	}
+	for _, address := range []string{"93.184.216.34:443", "[2606:4700::6810:84e5]:443"} {
+		assert.NoError(t, refusePrivateAddresses("tcp", address, nil), address)
+	}
+}
@@ -0,0 +1,401 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Privacy controls for recorded searches: client addresses are anonymized
+// before they are stored, events and clicks are purged after a retention
+// period, users can opt out of having their searches tied to their account,
+// and users can download what has been recorded about their own searches.
+
+const (
+	ipAnonymizeHash     = "hash"
+	ipAnonymizeTruncate = "truncate"
+	ipAnonymizeOff      = "off"
+)
+
+// searchIPAnonymization is how client addresses are stored with searches
+// and clicks: as a keyed hash (the default), with the host part zeroed, or
+// not at all. Set from SEARCH_IP_ANONYMIZATION in config.go.
+var searchIPAnonymization = ipAnonymizeHash
+
+// searchEventRetention is how long search events and clicks are kept; zero
+// keeps them forever. Overridden from SEARCH_EVENTS_RETENTION in config.go.
+var searchEventRetention = 90 * 24 * time.Hour
+
+// searchEventPurger is implemented by event stores that can delete old
+// events.
+type searchEventPurger interface {
+	Purge(before time.Time) (int64, error)
+}
+
+// searchEventExporter is implemented by event stores that can list the
+// events of one user.
+type searchEventExporter interface {
+	UserEvents(userID int) ([]searchEvent, error)
+}
+
+// anonymizeIP returns what is stored of a client address.
+func anonymizeIP(ip string) string {
+	if ip == "" {
+		return ""
+	}
+	switch searchIPAnonymization {
+	case ipAnonymizeOff:
+		return ""
+	case ipAnonymizeTruncate:
+		return truncateIP(ip)
+	default:
+		return hashClientIP(ip)
+	}
+}
+
+// truncateIP keeps the first 24 bits of an IPv4 address and the first 48 of
+// an IPv6 address, zeroing the rest.
+func truncateIP(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ""
+	}
+	addr = addr.Unmap().WithZone("")
+	bits := 48
+	if addr.Is4() {
+		bits = 24
+	}
+	prefix, err := addr.Prefix(bits)
+	if err != nil {
+		return ""
+	}
+	return prefix.Addr().String()
+}
+
+// searchIdentity returns the user ID and anonymized client address to record
+// with a search or click. Both are left out for users who opted out of
+// search history.
+func searchIdentity(r *http.Request) (int, string) {
+	userID, ok := sessionUserID(r)
+	if !ok {
+		return 0, anonymizeIP(clientIP(r))
+	}
+	if searchHistoryOptedOut(userID) {
+		return 0, ""
+	}
+	return userID, anonymizeIP(clientIP(r))
+}
+
+// searchHistoryOptedOut reports whether userID opted out of search history.
+// When that cannot be checked, it errs on the side of not recording.
+func searchHistoryOptedOut(userID int) bool {
+	var optedOut bool
+	err := db.QueryRow("SELECT search_history_opt_out FROM users WHERE id = $1", userID).Scan(&optedOut)
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("Error checking search history setting of user %d: %v", userID, err)
+		return true
+	}
+	return optedOut
+}
+
+func setSearchHistoryOptOut(userID int, optOut bool) error {
+	_, err := db.Exec("UPDATE users SET search_history_opt_out = $2 WHERE id = $1", userID, optOut)
+	if err != nil {
+		return fmt.Errorf("error saving search history setting of user %d: %w", userID, err)
+	}
+	return nil
+}
+
+// purgeSearchData deletes search events, clicks and history older than the
+// retention period.
+func purgeSearchData() {
+	if searchEventRetention <= 0 {
+		return
+	}
+	before := time.Now().Add(-searchEventRetention)
+
+	if purger, ok := searchEvents.(searchEventPurger); ok {
+		n, err := purger.Purge(before)
+		if err != nil {
+			log.Printf("Error purging search events: %v", err)
+		} else if n > 0 {
+			log.Printf("Purged %d search events older than %s", n, before.Format(time.RFC3339))
+		}
+	}
+
+	for _, table := range []string{"search_clicks", "search_history"} {
+		res, err := db.Exec("DELETE FROM "+table+" WHERE created_at < $1", before)
+		if err != nil {
+			log.Printf("Error purging %s: %v", table, err)
+			continue
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			log.Printf("Purged %d rows from %s older than %s", n, table, before.Format(time.RFC3339))
+		}
+	}
+}
+
+func (postgresEventStore) Purge(before time.Time) (int64, error) {
+	res, err := db.Exec("DELETE FROM search_events WHERE created_at < $1", before)
+	if err != nil {
+		return 0, fmt.Errorf("error purging search events: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+func (postgresEventStore) UserEvents(userID int) ([]searchEvent, error) {
+	rows, err := db.Query(`
+		SELECT created_at, query, normalized_query, COALESCE(language, ''), filters, result_count, latency_ms,
+		       COALESCE(client_ip_anon, '')
+		FROM search_events WHERE user_id = $1 ORDER BY created_at
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error loading search events of user %d: %w", userID, err)
+	}
+	defer rows.Close()
+
+	events := []searchEvent{}
+	for rows.Next() {
+		ev := searchEvent{UserID: userID}
+		var filters []byte
+		if err := rows.Scan(&ev.Time, &ev.Query, &ev.NormalizedQuery, &ev.Language, &filters, &ev.ResultCount,
+			&ev.LatencyMS, &ev.ClientIPAnon); err != nil {
+			return nil, fmt.Errorf("error scanning search event: %w", err)
+		}
+		if ev.Filters, err = decodeFilters(filters); err != nil {
+			return nil, err
+		}
+		events = append(events, ev)
+	}
+	return events, rows.Err()
+}
+
+// Purge rewrites the event file without events older than before. Lines in
+// the old text format carry the raw client address and no time, so they are
+// dropped as well. The scraper sees a new file and reads it from the start,
+// which only re-queues terms it already knows.
+func (s *jsonlEventStore) Purge(before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	in, err := os.Open(s.path)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".purge-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	var purged int64
+	reader := bufio.NewReader(in)
+	writer := bufio.NewWriter(tmp)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			var ev searchEvent
+			if line[0] == '{' && json.Unmarshal(line, &ev) == nil && !ev.Time.Before(before) {
+				if _, err := writer.Write(line); err != nil {
+					tmp.Close()
+					return 0, err
+				}
+			} else {
+				purged++
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			tmp.Close()
+			return 0, err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return 0, err
+	}
+
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return purged, err
+	}
+	s.file.Close()
+	s.file = f
+	return purged, nil
+}
+
+func (s *jsonlEventStore) UserEvents(userID int) ([]searchEvent, error) {
+	events, err := s.readEvents(func(ev searchEvent) bool { return ev.UserID == userID })
+	if err != nil {
+		return nil, fmt.Errorf("error loading search events of user %d: %w", userID, err)
+	}
+	if events == nil {
+		events = []searchEvent{}
+	}
+	return events, nil
+}
+
+type exportedClick struct {
+	Time     time.Time `json:"time"`
+	Query    string    `json:"query"`
+	URL      string    `json:"url"`
+	Position int       `json:"position"`
+}
+
+func userClicks(userID int) ([]exportedClick, error) {
+	rows, err := db.Query(`
+		SELECT created_at, query, url, position FROM search_clicks WHERE user_id = $1 ORDER BY created_at
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error loading search clicks of user %d: %w", userID, err)
+	}
+	defer rows.Close()
+
+	clicks := []exportedClick{}
+	for rows.Next() {
+		var c exportedClick
+		if err := rows.Scan(&c.Time, &c.Query, &c.URL, &c.Position); err != nil {
+			return nil, fmt.Errorf("error scanning search click: %w", err)
+		}
+		clicks = append(clicks, c)
+	}
+	return clicks, rows.Err()
+}
+
+// privacyPageHandler shows a user's search history setting and lets them
+// change it or download their searches.
+// GET /account/privacy
+func privacyPageHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := sessionUserID(r)
+	if !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	var optedOut bool
+	err := db.QueryRow("SELECT search_history_opt_out FROM users WHERE id = $1", userID).Scan(&optedOut)
+	if err != nil {
+		log.Printf("Error loading search history setting of user %d: %v", userID, err)
+		http.Error(w, "Error loading settings", http.StatusInternalServerError)
+		return
+	}
+
+	tmpl, err := template.ParseFiles(templatePath+"layout.html", templatePath+"privacy.html")
+	if err != nil {
+		log.Printf("Error parsing templates: %v", err)
+		http.Error(w, "Error loading templates", http.StatusInternalServerError)
+		return
+	}
+	data := map[string]any{
+		"Title":         "Privacy",
+		"UserLoggedIn":  true,
+		"OptedOut":      optedOut,
+		"RetentionDays": int(searchEventRetention.Hours() / 24),
+		"Message":       r.URL.Query().Get("message"),
+	}
+	if err := tmpl.ExecuteTemplate(w, "layout.html", data); err != nil {
+		log.Printf("Error executing template: %v", err)
+		http.Error(w, "Error rendering page", http.StatusInternalServerError)
+	}
+}
+
+// searchHistorySettingHandler turns a user's search history on or off.
+// POST /api/account/search-history (history=on|off)
+func searchHistorySettingHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := sessionUserID(r)
+	if !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+
+	var optOut bool
+	var message string
+	switch r.FormValue("history") {
+	case "on":
+		optOut, message = false, "Your searches will be saved to your account."
+	case "off":
+		optOut, message = true, "Your searches will no longer be linked to your account."
+	default:
+		http.Error(w, "history must be on or off", http.StatusBadRequest)
+		return
+	}
+	if err := setSearchHistoryOptOut(userID, optOut); err != nil {
+		log.Printf("%v", err)
+		http.Error(w, "Error saving settings", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/account/privacy?message="+template.URLQueryEscaper(message), http.StatusSeeOther)
+}
+
+// exportSearchesHandler sends a user everything recorded about their own
+// searches and clicks as a JSON download.
+// GET /api/account/searches/export
+func exportSearchesHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := sessionUserID(r)
+	if !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	exporter, ok := searchEvents.(searchEventExporter)
+	if !ok {
+		http.Error(w, "Search export is not available for this event store", http.StatusNotImplemented)
+		return
+	}
+
+	searches, err := exporter.UserEvents(userID)
+	if err != nil {
+		log.Printf("%v", err)
+		http.Error(w, "Error exporting searches", http.StatusInternalServerError)
+		return
+	}
+	clicks, err := userClicks(userID)
+	if err != nil {
+		log.Printf("%v", err)
+		http.Error(w, "Error exporting searches", http.StatusInternalServerError)
+		return
+	}
+	history, err := allSearchHistory(userID)
+	if err != nil {
+		log.Printf("%v", err)
+		http.Error(w, "Error exporting searches", http.StatusInternalServerError)
+		return
+	}
+	saved, err := loadSavedSearches(userID)
+	if err != nil {
+		log.Printf("%v", err)
+		http.Error(w, "Error exporting searches", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Disposition", `attachment; filename="searches.json"`)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"user_id":        userID,
+		"exported_at":    time.Now().UTC(),
+		"searches":       searches,
+		"clicks":         clicks,
+		"history":        history,
+		"saved_searches": saved,
+	})
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnonymizeIP(t *testing.T) {
+	previous := searchIPAnonymization
+	defer func() { searchIPAnonymization = previous }()
+
+	testCases := []struct {
+		mode string
+		ip   string
+		want string
+	}{
+		{ipAnonymizeTruncate, "203.0.113.9", "203.0.113.0"},
+		{ipAnonymizeTruncate, "::ffff:203.0.113.9", "203.0.113.0"},
+		{ipAnonymizeTruncate, "2001:db8:85a3:8d3:1319:8a2e:370:7348", "2001:db8:85a3::"},
+		{ipAnonymizeTruncate, "not an address", ""},
+		{ipAnonymizeOff, "203.0.113.9", ""},
+		{ipAnonymizeHash, "", ""},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.mode+" "+tc.ip, func(t *testing.T) {
+			searchIPAnonymization = tc.mode
+			assert.Equal(t, tc.want, anonymizeIP(tc.ip))
+		})
+	}
+
+	searchIPAnonymization = ipAnonymizeHash
+	hashed := anonymizeIP("203.0.113.9")
+	assert.Len(t, hashed, 32)
+	assert.NotEqual(t, hashed, anonymizeIP("203.0.113.10"))
+}
+
+func TestRecordSearchOptedOut(t *testing.T) {
+	events := &fakeEventStore{}
+	previous := searchEvents
+	searchEvents = events
+	defer func() { searchEvents = previous }()
+	mockDB, mock := setupMockDB()
+	defer mockDB.Close()
+
+	mock.ExpectQuery("SELECT search_history_opt_out FROM users").WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"search_history_opt_out"}).AddRow(true))
+	mock.ExpectQuery("SELECT search_history_opt_out FROM users").WithArgs(3).
+		WillReturnError(errors.New("connection refused"))
+
+	for range 2 {
+		req := loggedInRequest(t, "GET", "/api/search?q=go", "", 3)
+		req.RemoteAddr = "203.0.113.9:52100"
+		recordSearch(req, "go", "", 1, time.Millisecond)
+	}
+
+	assert.Len(t, events.events, 2)
+	for _, ev := range events.events {
+		assert.Equal(t, "go", ev.NormalizedQuery, "Opted-out searches still count")
+		assert.Zero(t, ev.UserID)
+		assert.Empty(t, ev.ClientIPAnon)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestJSONLEventStorePurge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "search.log")
+	now := time.Now().UTC()
+	appendLog(t, path, "SEARCH: query=\"legacy\" from=1.2.3.4:1\n")
+	// Events from before client_ip_hash was renamed are kept and exported.
+	appendLog(t, path, `{"time":"`+now.Format(time.RFC3339Nano)+`","query":"renamed","normalized_query":"renamed","user_id":3,"client_ip_hash":"abc"}`+"\n")
+	store, err := newJSONLEventStore(path)
+	assert.NoError(t, err)
+	defer store.Close()
+	assert.NoError(t, store.Record(searchEvent{Time: now.Add(-48 * time.Hour), Query: "old", NormalizedQuery: "old", UserID: 3}))
+	assert.NoError(t, store.Record(searchEvent{Time: now, Query: "new", NormalizedQuery: "new", UserID: 3}))
+
+	purged, err := store.Purge(now.Add(-24 * time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), purged)
+
+	// Writes after the purge go to the rewritten file.
+	assert.NoError(t, store.Record(searchEvent{Time: now, Query: "newer", NormalizedQuery: "newer", UserID: 4}))
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), "legacy")
+	assert.NotContains(t, string(data), `"old"`)
+	assert.Equal(t, 3, strings.Count(string(data), "\n"))
+
+	events, err := store.UserEvents(3)
+	assert.NoError(t, err)
+	if assert.Len(t, events, 2) {
+		assert.Equal(t, "abc", events[0].ClientIPAnon)
+		assert.Equal(t, "new", events[1].Query)
+	}
+}
+
+func TestPurgeSearchData(t *testing.T) {
+	previous := searchEvents
+	searchEvents = postgresEventStore{}
+	defer func() { searchEvents = previous }()
+	mockDB, mock := setupMockDB()
+	defer mockDB.Close()
+
+	mock.ExpectExec("DELETE FROM search_events WHERE created_at < \\$1").
+		WithArgs(sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 12))
+	mock.ExpectExec("DELETE FROM search_clicks WHERE created_at < \\$1").
+		WithArgs(sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec("DELETE FROM search_history WHERE created_at < \\$1").
+		WithArgs(sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 5))
+	purgeSearchData()
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	retention := searchEventRetention
+	searchEventRetention = 0
+	defer func() { searchEventRetention = retention }()
+	purgeSearchData()
+	assert.NoError(t, mock.ExpectationsWereMet(), "A zero retention keeps everything")
+}
+
+func TestSearchHistorySettingHandler(t *testing.T) {
+	testCases := []struct {
+		name       string
+		body       string
+		userID     int
+		mockSetup  func(mock sqlmock.Sqlmock)
+		wantStatus int
+		wantTarget string
+	}{
+		{
+			name:   "Turns history off",
+			body:   "history=off",
+			userID: 3,
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec("UPDATE users SET search_history_opt_out").WithArgs(3, true).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+			wantStatus: http.StatusSeeOther,
+			wantTarget: "/account/privacy?message=",
+		},
+		{
+			name:       "Rejects other values",
+			body:       "history=maybe",
+			userID:     3,
+			mockSetup:  func(mock sqlmock.Sqlmock) {},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Sends anonymous users to log in",
+			body:       "history=off",
+			mockSetup:  func(mock sqlmock.Sqlmock) {},
+			wantStatus: http.StatusSeeOther,
+			wantTarget: "/login",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockDB, mock := setupMockDB()
+			defer mockDB.Close()
+			tc.mockSetup(mock)
+
+			req := loggedInRequest(t, "POST", "/api/account/search-history", tc.body, tc.userID)
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			rr := httptest.NewRecorder()
+			searchHistorySettingHandler(rr, req)
+
+			assert.Equal(t, tc.wantStatus, rr.Code)
+			assert.True(t, strings.HasPrefix(rr.Header().Get("Location"), tc.wantTarget))
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestExportSearchesHandler(t *testing.T) {
+	previous := searchEvents
+	searchEvents = postgresEventStore{}
+	defer func() { searchEvents = previous }()
+	mockDB, mock := setupMockDB()
+	defer mockDB.Close()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	mock.ExpectQuery("FROM search_events WHERE user_id = \\$1").WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"created_at", "query", "normalized_query", "language", "filters",
+			"result_count", "latency_ms", "client_ip_anon"}).
+			AddRow(now, "Go", "go", "da", []byte(`{"language":"da"}`), 4, int64(20), "abc"))
+	mock.ExpectQuery("FROM search_clicks WHERE user_id = \\$1").WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"created_at", "query", "url", "position"}).
+			AddRow(now, "Go", "https://go.dev/", 1))
+	mock.ExpectQuery("FROM search_history WHERE user_id = \\$1 ORDER BY created_at").WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"query", "filters", "created_at"}).AddRow("Go", nil, now))
+	mock.ExpectQuery("FROM saved_searches s").WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "query", "filters", "created_at", "alert_channel"}).
+			AddRow(1, "golang", "Go", nil, now, ""))
+
+	rr := httptest.NewRecorder()
+	exportSearchesHandler(rr, loggedInRequest(t, "GET", "/api/account/searches/export", "", 3))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Header().Get("Content-Disposition"), "attachment")
+	var export struct {
+		UserID   int             `json:"user_id"`
+		Searches []searchEvent   `json:"searches"`
+		Clicks   []exportedClick `json:"clicks"`
+		History  []historyEntry  `json:"history"`
+		Saved    []savedSearch   `json:"saved_searches"`
+	}
+	assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &export))
+	assert.Equal(t, 3, export.UserID)
+	if assert.Len(t, export.Searches, 1) {
+		assert.Equal(t, map[string]string{"language": "da"}, export.Searches[0].Filters)
+		assert.Equal(t, 3, export.Searches[0].UserID)
+	}
+	if assert.Len(t, export.Clicks, 1) {
+		assert.Equal(t, "https://go.dev/", export.Clicks[0].URL)
+	}
+	assert.Len(t, export.History, 1)
+	assert.Len(t, export.Saved, 1)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	rr = httptest.NewRecorder()
+	exportSearchesHandler(rr, loggedInRequest(t, "GET", "/api/account/searches/export", "", 0))
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+}
@@ -86,6 +86,75 @@ var (
 		},
 		[]string{"auth_status"},
 	)
+
+	scraperRequestsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "scraper_requests_total",
+			Help: "Total number of requests sent by the scraper per domain",
+		},
+		[]string{"domain"},
+	)
+
+	scraperSkippedURLs = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "scraper_skipped_urls_total",
+			Help: "URLs the scraper did not fetch, by domain and reason",
+		},
+		[]string{"domain", "reason"},
+	)
+
+	scrapeJobsQueued = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "scraper_jobs_queued",
+			Help: "Scrape jobs that are due and waiting for a worker",
+		},
+	)
+
+	scrapeJobsInFlight = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "scraper_jobs_in_flight",
+			Help: "Scrape jobs currently being processed",
+		},
+	)
+
+	scrapeJobsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "scraper_jobs_total",
+			Help: "Processed scrape jobs by result",
+		},
+		[]string{"result"},
+	)
+
+	pagesRecrawledTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "pages_recrawled_total",
+			Help: "Recrawled pages by result (refreshed, unchanged, gone, failed)",
+		},
+		[]string{"result"},
+	)
+
+	ingestedURLsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "ingest_urls_queued_total",
+			Help: "URLs queued from ingestion sources by source kind (sitemap, feed)",
+		},
+		[]string{"kind"},
+	)
+
+	nearDuplicatesTotal = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "pages_near_duplicates_total",
+			Help: "Saved pages that were linked to a near-duplicate cluster",
+		},
+	)
+
+	pageSubmissionsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "page_submissions_total",
+			Help: "User-submitted URLs by outcome (pending, approved, rejected, rate_limited)",
+		},
+		[]string{"status"},
+	)
 )
 
 type statusRecorder struct {
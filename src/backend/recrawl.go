@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Recrawl settings. Overridden from the environment in config.go.
+var (
+	recrawlMaxAge    = 30 * 24 * time.Hour
+	recrawlBatchSize = 100
+)
+
+type recrawlStats struct {
+	Refreshed int
+	Unchanged int
+	Gone      int
+	Failed    int
+}
+
+func (s recrawlStats) String() string {
+	return fmt.Sprintf("%d refreshed, %d unchanged, %d gone, %d failed", s.Refreshed, s.Unchanged, s.Gone, s.Failed)
+}
+
+// recrawlStalePages refetches up to recrawlBatchSize pages whose content is
+// older than recrawlMaxAge, oldest first. Pages that fail are stamped as
+// crawled too, so a batch of broken pages does not hold up the rest.
+func recrawlStalePages(ctx context.Context) recrawlStats {
+	var stats recrawlStats
+	cutoff := time.Now().Add(-recrawlMaxAge)
+
+	rows, err := db.Query(`
+		SELECT url, title, content, COALESCE(source_language, language), source, COALESCE(etag, ''), COALESCE(http_last_modified, ''),
+		       COALESCE(revision_id, 0)
+		FROM pages
+		WHERE gone_at IS NULL
+		  AND last_updated < $1
+		  AND (last_crawled_at IS NULL OR last_crawled_at < $1)
+		ORDER BY COALESCE(last_crawled_at, last_updated)
+		LIMIT $2
+	`, cutoff, recrawlBatchSize)
+	if err != nil {
+		log.Printf("Error selecting stale pages: %v", err)
+		return stats
+	}
+
+	var stale []Page
+	for rows.Next() {
+		var p Page
+		if err := rows.Scan(&p.URL, &p.Title, &p.Content, &p.Language, &p.Source, &p.ETag, &p.LastModified, &p.RevisionID); err != nil {
+			log.Printf("Error scanning stale page: %v", err)
+			continue
+		}
+		stale = append(stale, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		log.Printf("Error reading stale pages: %v", err)
+		return stats
+	}
+
+	for _, page := range stale {
+		if ctx.Err() != nil {
+			break
+		}
+		result, err := recrawlPage(ctx, page)
+		if err != nil {
+			log.Printf("Error recrawling %s: %v", page.URL, err)
+			result = "failed"
+			if err := markPageCrawled(page.URL, page.ETag, page.LastModified); err != nil {
+				log.Printf("Error updating %s: %v", page.URL, err)
+			}
+		}
+		pagesRecrawledTotal.WithLabelValues(result).Inc()
+
+		switch result {
+		case "refreshed":
+			stats.Refreshed++
+		case "unchanged":
+			stats.Unchanged++
+		case "gone":
+			stats.Gone++
+		default:
+			stats.Failed++
+		}
+	}
+
+	log.Printf("Recrawl finished: %s", stats)
+	return stats
+}
+
+// recrawlPage revalidates one stored page and reports whether it was
+// "refreshed", "unchanged" or "gone". Wikipedia pages are compared by
+// revision ID, since the API does not answer conditional requests; other
+// pages are revalidated with a conditional request.
+func recrawlPage(ctx context.Context, stored Page) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, scrapeTermTimeout)
+	defer cancel()
+
+	if stored.Source == sourceWikipedia {
+		changed, err := wikipediaRevisionChanged(ctx, stored)
+		switch {
+		case errors.Is(err, errWikiPageNotFound):
+			return "gone", tombstonePage(stored.URL)
+		case err != nil:
+			return "", err
+		case !changed:
+			return "unchanged", markPageCrawled(stored.URL, stored.ETag, stored.LastModified)
+		}
+		return refreshPage(ctx, stored, stored.ETag, stored.LastModified)
+	}
+
+	status, etag, lastModified, err := revalidateURL(ctx, stored.URL, stored.ETag, stored.LastModified)
+	switch {
+	case err != nil:
+		return "", err
+	case status == http.StatusNotModified:
+		return "unchanged", markPageCrawled(stored.URL, stored.ETag, stored.LastModified)
+	case status == http.StatusNotFound || status == http.StatusGone:
+		return "gone", tombstonePage(stored.URL)
+	case status != http.StatusOK:
+		return "", fmt.Errorf("unexpected status %d", status)
+	}
+	return refreshPage(ctx, stored, etag, lastModified)
+}
+
+// wikipediaRevisionChanged reports whether a Wikipedia article was edited or
+// moved since it was stored. Pages stored before revision IDs were kept
+// always count as changed.
+func wikipediaRevisionChanged(ctx context.Context, stored Page) (bool, error) {
+	if stored.RevisionID == 0 {
+		return true, nil
+	}
+	title, revisionID, err := wikiClient.LastRevision(ctx, stored.Language, stored.Title)
+	if err != nil {
+		return false, err
+	}
+	return title != stored.Title || revisionID != stored.RevisionID, nil
+}
+
+// refreshPage fetches a stored page again and saves it if it changed.
+func refreshPage(ctx context.Context, stored Page, etag, lastModified string) (string, error) {
+	fetched, err := fetchStoredPage(ctx, stored)
+	if errors.Is(err, errWikiPageNotFound) || errors.Is(err, errDisambiguationPage) {
+		return "gone", tombstonePage(stored.URL)
+	}
+	if err != nil {
+		return "", err
+	}
+	fetched.ETag, fetched.LastModified = etag, lastModified
+
+	if fetched.URL == stored.URL && fetched.Title == stored.Title && fetched.Content == stored.Content {
+		return "unchanged", markPageCrawled(stored.URL, etag, lastModified)
+	}
+	if err := savePageToDBWithLang(fetched, stored.Language); err != nil {
+		return "", err
+	}
+	if fetched.URL != stored.URL {
+		// The article moved; the old URL no longer has content of its own.
+		if err := tombstonePage(stored.URL); err != nil {
+			log.Printf("Error tombstoning moved page %s: %v", stored.URL, err)
+		}
+	}
+	return "refreshed", nil
+}
+
+// fetchStoredPage fetches a stored page again the way it was first
+// fetched: through the MediaWiki API or as an ordinary web page.
+func fetchStoredPage(ctx context.Context, stored Page) (Page, error) {
+	if stored.Source == sourceWeb {
+		return scrapeURL(ctx, ingestEntry{URL: stored.URL})
+	}
+	return scrapeWikipedia(ctx, stored.Title, stored.Language)
+}
+
+// loadStoredPage returns the fields of a stored page needed to fetch it
+// again, or sql.ErrNoRows.
+func loadStoredPage(url string) (Page, error) {
+	var p Page
+	err := db.QueryRow(`
+		SELECT url, title, COALESCE(source_language, language), source FROM pages WHERE url = $1
+	`, url).Scan(&p.URL, &p.Title, &p.Language, &p.Source)
+	return p, err
+}
+
+// revalidateURL sends a conditional HEAD request for url and returns the
+// status together with the validators to store for next time.
+func revalidateURL(ctx context.Context, url, etag, lastModified string) (int, string, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return 0, "", "", err
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if lastModified != "" {
+		req.Header.Set("If-Modified-Since", lastModified)
+	}
+
+	resp, err := scraperHTTPClient.Do(req)
+	if err != nil {
+		return 0, "", "", err
+	}
+	resp.Body.Close()
+
+	return resp.StatusCode, resp.Header.Get("ETag"), resp.Header.Get("Last-Modified"), nil
+}
+
+func markPageCrawled(url, etag, lastModified string) error {
+	_, err := db.Exec(`
+		UPDATE pages SET last_crawled_at = NOW(), etag = $1, http_last_modified = $2
+		WHERE url = $3
+	`, etag, lastModified, url)
+	return err
+}
+
+// tombstonePage keeps the row so the URL is not scraped again from a stale
+// search term, but hides it from search.
+func tombstonePage(url string) error {
+	_, err := db.Exec(`
+		UPDATE pages SET gone_at = NOW(), last_crawled_at = NOW()
+		WHERE url = $1
+	`, url)
+	if err == nil {
+		log.Printf("Page is gone, tombstoned: %s", url)
+	}
+	return err
+}
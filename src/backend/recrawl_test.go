@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+var stalePageColumns = []string{"url", "title", "content", "language", "source", "etag", "http_last_modified", "revision_id"}
+
+func TestRecrawlStalePagesStampsFailures(t *testing.T) {
+	mockDB, mock := setupMockDB()
+	defer mockDB.Close()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+	previous := scraperHTTPClient
+	scraperHTTPClient = srv.Client()
+	defer func() { scraperHTTPClient = previous }()
+
+	mock.ExpectQuery("FROM pages").WithArgs(sqlmock.AnyArg(), recrawlBatchSize).
+		WillReturnRows(sqlmock.NewRows(stalePageColumns).
+			AddRow(srv.URL+"/broken", "Broken", "Old content", "en", sourceWeb, `"v1"`, "", 0))
+	// The failed page goes to the back of the queue with its validators kept.
+	mock.ExpectExec("UPDATE pages SET last_crawled_at = NOW\\(\\)").
+		WithArgs(`"v1"`, "", srv.URL+"/broken").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	stats := recrawlStalePages(context.Background())
+
+	assert.Equal(t, recrawlStats{Failed: 1}, stats)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRecrawlPageComparesWikipediaRevisions(t *testing.T) {
+	mockDB, mock := setupMockDB()
+	defer mockDB.Close()
+
+	previous := wikiClient
+	wikiClient = newFakeMediaWiki(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "info", r.URL.Query().Get("prop"), "Only the revision should be looked up")
+		w.Write([]byte(`{"query":{"pages":[{"pageid":1234,"title":"København","lastrevid":99}]}}`))
+	})
+	defer func() { wikiClient = previous }()
+
+	const pageURL = "https://da.wikipedia.org/wiki/K%C3%B8benhavn"
+	mock.ExpectExec("UPDATE pages SET last_crawled_at = NOW\\(\\)").WithArgs("", "", pageURL).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	stored := Page{URL: pageURL, Title: "København", Language: "da", Source: sourceWikipedia, RevisionID: 99}
+	result, err := recrawlPage(context.Background(), stored)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "unchanged", result)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestWikipediaRevisionChanged(t *testing.T) {
+	previous := wikiClient
+	wikiClient = newFakeMediaWiki(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"query":{"pages":[{"pageid":1234,"title":"København","lastrevid":100}]}}`))
+	})
+	defer func() { wikiClient = previous }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	changed, err := wikipediaRevisionChanged(ctx, Page{Title: "København", Language: "da", RevisionID: 99})
+	assert.NoError(t, err)
+	assert.True(t, changed, "A newer revision means the page changed")
+
+	changed, err = wikipediaRevisionChanged(ctx, Page{Title: "København", Language: "da"})
+	assert.NoError(t, err)
+	assert.True(t, changed, "Pages stored without a revision ID are always refetched")
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+)
+
+const (
+	jobPending = "pending"
+	jobDead    = "dead"
+
+	jobKindTerm = "term"
+	jobKindURL  = "url"
+)
+
+// Retry settings for failed scrape jobs. Overridden from the environment in
+// config.go.
+var (
+	scrapeMaxAttempts = 5
+	scrapeRetryBase   = 10 * time.Minute
+	scrapeRetryMax    = 24 * time.Hour
+)
+
+type scrapeJob struct {
+	ID int
+	// Kind is jobKindTerm for logged search terms, which are looked up on
+	// Wikipedia, and jobKindURL for URLs from ingestion sources. Term holds
+	// the term or the URL.
+	Kind     string
+	Term     string
+	Attempts int
+	// Entry is the source metadata of a URL job.
+	Entry ingestEntry
+}
+
+// enqueueScrapeJob adds a term to the queue. Terms that already have a job,
+// whatever its status, are left alone.
+func enqueueScrapeJob(term string) error {
+	_, err := db.Exec(`
+		INSERT INTO scrape_jobs (kind, term, status, next_attempt_at)
+		VALUES ('term', $1, 'pending', NOW())
+		ON CONFLICT (kind, term) DO NOTHING
+	`, term)
+	if err != nil {
+		return fmt.Errorf("error enqueueing scrape job for %q: %w", term, err)
+	}
+	return nil
+}
+
+// enqueueURLJob queues a URL found in an ingestion source. A URL that was
+// already crawled is queued again only when the source reports it changed
+// after the last crawl. Reports whether the URL was (re)queued.
+func enqueueURLJob(entry ingestEntry) (bool, error) {
+	payload, err := json.Marshal(entry)
+	if err != nil {
+		return false, err
+	}
+	changed := entry.LastMod
+	if changed.IsZero() {
+		changed = entry.Published
+	}
+
+	res, err := db.Exec(`
+		INSERT INTO scrape_jobs (kind, term, payload, status, next_attempt_at)
+		VALUES ('url', $1, $2, 'pending', NOW())
+		ON CONFLICT (kind, term) DO UPDATE
+		SET payload = EXCLUDED.payload, status = 'pending', attempts = 0, last_error = NULL,
+		    next_attempt_at = NOW(), updated_at = NOW()
+		WHERE scrape_jobs.status IN ('done', 'dead') AND scrape_jobs.updated_at < $3
+	`, entry.URL, string(payload), sql.NullTime{Time: changed, Valid: !changed.IsZero()})
+	if err != nil {
+		return false, fmt.Errorf("error enqueueing scrape job for %s: %w", entry.URL, err)
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// requeueScrapeJob queues a term or URL to run now, as requested by an
+// admin. Unlike the enqueue functions it also resets jobs that are done or
+// dead, keeping any source metadata of a URL job; a job that is running is
+// left alone. Returns the job's ID.
+func requeueScrapeJob(kind, term string) (int, error) {
+	var id int
+	err := db.QueryRow(`
+		INSERT INTO scrape_jobs (kind, term, status, next_attempt_at)
+		VALUES ($1, $2, 'pending', NOW())
+		ON CONFLICT (kind, term) DO UPDATE
+		SET status = 'pending', attempts = 0, last_error = NULL, next_attempt_at = NOW(), updated_at = NOW()
+		WHERE scrape_jobs.status <> 'running'
+		RETURNING id
+	`, kind, term).Scan(&id)
+	if err == sql.ErrNoRows {
+		err = db.QueryRow("SELECT id FROM scrape_jobs WHERE kind = $1 AND term = $2", kind, term).Scan(&id)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("error requeueing scrape job for %q: %w", term, err)
+	}
+	return id, nil
+}
+
+// scrapeJobStatus is a job as reported by the admin API.
+type scrapeJobStatus struct {
+	ID            int       `json:"id"`
+	Kind          string    `json:"kind"`
+	Term          string    `json:"term"`
+	Status        string    `json:"status"`
+	Attempts      int       `json:"attempts"`
+	LastError     string    `json:"last_error,omitempty"`
+	NextAttemptAt time.Time `json:"next_attempt_at"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// loadScrapeJob returns sql.ErrNoRows when there is no job with that ID.
+func loadScrapeJob(id int) (*scrapeJobStatus, error) {
+	var job scrapeJobStatus
+	var lastError sql.NullString
+	err := db.QueryRow(`
+		SELECT id, kind, term, status, attempts, last_error, next_attempt_at, created_at, updated_at
+		FROM scrape_jobs WHERE id = $1
+	`, id).Scan(&job.ID, &job.Kind, &job.Term, &job.Status, &job.Attempts, &lastError,
+		&job.NextAttemptAt, &job.CreatedAt, &job.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	job.LastError = lastError.String
+	return &job, nil
+}
+
+// claimScrapeJob picks the next due job and marks it as running. Rows locked
+// by another worker are skipped, so several workers can claim concurrently.
+// It returns nil when no job is due.
+func claimScrapeJob() (*scrapeJob, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			log.Printf("Rollback error: %v", err)
+		}
+	}()
+
+	var job scrapeJob
+	var payload sql.NullString
+	err = tx.QueryRow(`
+		SELECT id, kind, term, attempts, payload FROM scrape_jobs
+		WHERE status = 'pending' AND next_attempt_at <= NOW()
+		ORDER BY next_attempt_at
+		LIMIT 1
+		FOR UPDATE SKIP LOCKED
+	`).Scan(&job.ID, &job.Kind, &job.Term, &job.Attempts, &payload)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error claiming scrape job: %w", err)
+	}
+	if payload.Valid {
+		if err := json.Unmarshal([]byte(payload.String), &job.Entry); err != nil {
+			log.Printf("Ignoring malformed payload of scrape job %d: %v", job.ID, err)
+		}
+	}
+
+	job.Attempts++
+	_, err = tx.Exec(`
+		UPDATE scrape_jobs SET status = 'running', attempts = $1, updated_at = NOW()
+		WHERE id = $2
+	`, job.Attempts, job.ID)
+	if err != nil {
+		return nil, fmt.Errorf("error marking scrape job %d as running: %w", job.ID, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+func completeScrapeJob(job *scrapeJob) {
+	_, err := db.Exec(`
+		UPDATE scrape_jobs SET status = 'done', last_error = NULL, updated_at = NOW()
+		WHERE id = $1
+	`, job.ID)
+	if err != nil {
+		log.Printf("Error completing scrape job %d: %v", job.ID, err)
+	}
+}
+
+// failScrapeJob records the error and schedules a retry with exponential
+// backoff, or moves the job to the dead-letter status once it has used up
+// its attempts.
+func failScrapeJob(job *scrapeJob, jobErr error) {
+	status := jobPending
+	nextAttempt := time.Now().Add(scrapeRetryBackoff(job.Attempts))
+	if job.Attempts >= scrapeMaxAttempts {
+		status = jobDead
+		log.Printf("Scrape job for %q failed %d times, giving up: %v", job.Term, job.Attempts, jobErr)
+	} else {
+		log.Printf("Scrape job for %q failed (attempt %d/%d), retrying at %s: %v",
+			job.Term, job.Attempts, scrapeMaxAttempts, nextAttempt.Format(time.RFC3339), jobErr)
+	}
+
+	_, err := db.Exec(`
+		UPDATE scrape_jobs SET status = $1, last_error = $2, next_attempt_at = $3, updated_at = NOW()
+		WHERE id = $4
+	`, status, jobErr.Error(), nextAttempt, job.ID)
+	if err != nil {
+		log.Printf("Error recording failure for scrape job %d: %v", job.ID, err)
+	}
+}
+
+// releaseScrapeJob returns a claimed job to the queue without counting the
+// attempt, used when a run is interrupted rather than failed.
+func releaseScrapeJob(job *scrapeJob) {
+	_, err := db.Exec(`
+		UPDATE scrape_jobs SET status = 'pending', attempts = attempts - 1, updated_at = NOW()
+		WHERE id = $1
+	`, job.ID)
+	if err != nil {
+		log.Printf("Error releasing scrape job %d: %v", job.ID, err)
+	}
+}
+
+// scrapeRetryBackoff doubles the wait for every failed attempt, capped at
+// scrapeRetryMax.
+func scrapeRetryBackoff(attempts int) time.Duration {
+	delay := scrapeRetryBase
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= scrapeRetryMax {
+			return scrapeRetryMax
+		}
+	}
+	return delay
+}
+
+// requeueStaleScrapeJobs puts jobs that have been running for too long back
+// in the queue, e.g. after the process died halfway through a scrape.
+func requeueStaleScrapeJobs(olderThan time.Duration) {
+	res, err := db.Exec(`
+		UPDATE scrape_jobs SET status = 'pending', updated_at = NOW()
+		WHERE status = 'running' AND updated_at < $1
+	`, time.Now().Add(-olderThan))
+	if err != nil {
+		log.Printf("Error requeueing stale scrape jobs: %v", err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		log.Printf("Requeued %d stale scrape jobs", n)
+	}
+}
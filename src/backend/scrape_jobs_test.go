@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScrapeRetryBackoff(t *testing.T) {
+	assert.Equal(t, scrapeRetryBase, scrapeRetryBackoff(1))
+	assert.Equal(t, 2*scrapeRetryBase, scrapeRetryBackoff(2))
+	assert.Equal(t, 4*scrapeRetryBase, scrapeRetryBackoff(3))
+	assert.Equal(t, scrapeRetryMax, scrapeRetryBackoff(50), "Backoff should be capped")
+}
+
+func TestFailScrapeJobDeadLetter(t *testing.T) {
+	testCases := []struct {
+		name     string
+		attempts int
+		status   string
+	}{
+		{name: "Retry", attempts: 1, status: jobPending},
+		{name: "Dead letter", attempts: scrapeMaxAttempts, status: jobDead},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockDB, mock := setupMockDB()
+			defer mockDB.Close()
+
+			mock.ExpectExec("UPDATE scrape_jobs SET status").
+				WithArgs(tc.status, "boom", sqlmock.AnyArg(), 7).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+
+			failScrapeJob(&scrapeJob{ID: 7, Term: "go", Attempts: tc.attempts}, errors.New("boom"))
+
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestClaimScrapeJobEmptyQueue(t *testing.T) {
+	mockDB, mock := setupMockDB()
+	defer mockDB.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("FOR UPDATE SKIP LOCKED").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "kind", "term", "attempts", "payload"}))
+	mock.ExpectRollback()
+
+	job, err := claimScrapeJob()
+	assert.NoError(t, err)
+	assert.Nil(t, job)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
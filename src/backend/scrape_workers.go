@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Worker pool settings. Overridden from the environment in config.go.
+var (
+	scrapeConcurrency = 4
+	scrapeTermTimeout = 2 * time.Minute
+	// scrapeTopK is how many search matches are ingested per term.
+	scrapeTopK = 1
+	// scrapeDisambiguation is "enqueue" to queue the articles listed on a
+	// disambiguation page, or "skip" to ignore the page.
+	scrapeDisambiguation      = "enqueue"
+	scrapeDisambiguationLinks = 10
+)
+
+var scrapeRunning atomic.Bool
+
+// scrapeKicked records that jobs were queued on demand, so a run that holds
+// scrapeRunning looks at the queue again before it finishes.
+var scrapeKicked atomic.Bool
+
+// scrapeCtx bounds worker runs started on demand by kickScrapeWorkers. main
+// replaces it with a context that is cancelled on shutdown.
+var scrapeCtx = context.Background()
+
+// kickScrapeWorkers processes due jobs in the background right away instead
+// of waiting for the next cron run, and indexes what they saved. If a run is
+// already in progress it picks up the new jobs before it finishes.
+func kickScrapeWorkers() {
+	scrapeKicked.Store(true)
+	if !scrapeRunning.CompareAndSwap(false, true) {
+		return
+	}
+	go func() {
+		defer finishScrapeRun(scrapeCtx)
+		drainScrapeQueue(scrapeCtx)
+	}()
+}
+
+// finishScrapeRun releases scrapeRunning, and starts another run for jobs
+// that were kicked after the last look at the queue.
+func finishScrapeRun(ctx context.Context) {
+	scrapeRunning.Store(false)
+	if scrapeKicked.Load() && ctx.Err() == nil {
+		kickScrapeWorkers()
+	}
+}
+
+// drainScrapeQueue runs the workers and indexes what they saved until a
+// round finds no due jobs and none were kicked meanwhile. It returns how many
+// jobs were handled.
+func drainScrapeQueue(ctx context.Context) int {
+	total := 0
+	for ctx.Err() == nil {
+		scrapeKicked.Store(false)
+		processed := runScrapeWorkers(ctx, scrapeConcurrency)
+		if processed > 0 {
+			total += processed
+			indexScrapedPages(ctx)
+			continue
+		}
+		if !scrapeKicked.Load() {
+			break
+		}
+	}
+	return total
+}
+
+// indexScrapedPages makes pages saved by a worker run searchable and alerts
+// the saved searches they match.
+func indexScrapedPages(ctx context.Context) {
+	if esClient == nil {
+		return
+	}
+	indexedAt, err := alertWindowEnd()
+	if err != nil {
+		log.Printf("%v", err)
+		return
+	}
+	if err := syncPagesToElasticsearch(); err != nil {
+		log.Printf("Error syncing to Elasticsearch: %v", err)
+		return
+	}
+	log.Println("Synced scraped pages to Elasticsearch successfully.")
+	sendSearchAlerts(ctx, indexedAt)
+}
+
+// runScrapeWorkers starts workers that claim and process due jobs until the
+// queue is empty or ctx is cancelled, and returns how many jobs were handled.
+func runScrapeWorkers(ctx context.Context, workers int) int {
+	if workers < 1 {
+		workers = 1
+	}
+	updateQueuedScrapeJobs()
+
+	var processed atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				job, err := claimScrapeJob()
+				if err != nil {
+					log.Printf("%v", err)
+					return
+				}
+				if job == nil {
+					return
+				}
+				processScrapeJob(ctx, job)
+				processed.Add(1)
+				updateQueuedScrapeJobs()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		log.Println("Scraper stopped before the queue was drained.")
+	}
+	return int(processed.Load())
+}
+
+// processScrapeJob scrapes and saves a single term or URL, bounded by
+// scrapeTermTimeout.
+func processScrapeJob(ctx context.Context, job *scrapeJob) {
+	scrapeJobsInFlight.Inc()
+	defer scrapeJobsInFlight.Dec()
+
+	jobCtx, cancel := context.WithTimeout(ctx, scrapeTermTimeout)
+	defer cancel()
+
+	var pages []Page
+	var err error
+	switch job.Kind {
+	case jobKindURL:
+		var page Page
+		page, err = scrapeURLJob(jobCtx, job)
+		pages = []Page{page}
+	default:
+		pages, err = tryScrapeInLanguages(jobCtx, job.Term, []string{"da", "en"})
+	}
+	if err == nil {
+		err = savePages(pages)
+	}
+
+	switch {
+	case err == nil:
+		completeScrapeJob(job)
+		scrapeJobsTotal.WithLabelValues("succeeded").Inc()
+	case ctx.Err() != nil:
+		// Shutting down: the term itself did not fail, so hand it back.
+		releaseScrapeJob(job)
+	default:
+		failScrapeJob(job, err)
+		scrapeJobsTotal.WithLabelValues("failed").Inc()
+	}
+}
+
+// scrapeURLJob fetches the page of a URL job. A URL that belongs to a
+// stored Wikipedia article, queued by an admin re-scrape, is fetched through
+// the MediaWiki API like a recrawl would; anything else as a web page.
+func scrapeURLJob(ctx context.Context, job *scrapeJob) (Page, error) {
+	stored, err := loadStoredPage(job.Term)
+	switch {
+	case err == nil && stored.Source == sourceWikipedia:
+		return fetchStoredPage(ctx, stored)
+	case err != nil && err != sql.ErrNoRows:
+		return Page{}, fmt.Errorf("error loading stored page %s: %w", job.Term, err)
+	}
+
+	entry := job.Entry
+	entry.URL = job.Term
+	return scrapeURL(ctx, entry)
+}
+
+// savePages stores every page and returns the first error, so a job is only
+// marked done when all of its matches were saved.
+func savePages(pages []Page) error {
+	var firstErr error
+	for _, page := range pages {
+		if err := savePageToDBWithLang(page, page.Language); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func updateQueuedScrapeJobs() {
+	var queued int
+	err := db.QueryRow(`
+		SELECT COUNT(*) FROM scrape_jobs WHERE status = 'pending' AND next_attempt_at <= NOW()
+	`).Scan(&queued)
+	if err != nil {
+		log.Printf("Error counting queued scrape jobs: %v", err)
+		return
+	}
+	scrapeJobsQueued.Set(float64(queued))
+}
@@ -1,166 +1,303 @@
 package main
 
 import (
-	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
-	"os"
-	"regexp"
-	"strings"
+	"time"
 
-	"github.com/gocolly/colly"
-	"golang.org/x/text/cases"
-	"golang.org/x/text/language"
+	"github.com/lib/pq"
 )
 
-func extractSearchTerms(logPath string) []string {
-	file, err := os.Open(logPath)
-	if err != nil {
-		log.Printf("Could not open log: %v", err)
-		return nil
-	}
-	defer file.Close()
-
-	re := regexp.MustCompile(`query="([^"]+)"`)
-	termsMap := make(map[string]bool)
-	scanner := bufio.NewScanner(file)
-
-	for scanner.Scan() {
-		line := scanner.Text()
-		match := re.FindStringSubmatch(line)
-		if len(match) > 1 {
-			term := strings.ToLower(strings.TrimSpace(match[1]))
-			termsMap[term] = true
-			fmt.Printf("Extracted search term: %s\n", term)
-		}
+// StartScraping queues every recorded search term, drains the queue with a
+// pool of workers and indexes the pages they saved. Runs that overlap an
+// unfinished one return immediately.
+func StartScraping(ctx context.Context) {
+	if !scrapeRunning.CompareAndSwap(false, true) {
+		log.Println("Scraper is already running, skipping this run.")
+		return
 	}
+	defer finishScrapeRun(ctx)
 
-	var terms []string
-	for k := range termsMap {
-		terms = append(terms, k)
+	if searchEvents != nil {
+		queueSearchedTerms()
 	}
-	return terms
-}
 
-func alreadyProcessed(term string) bool {
-	var exists bool
-	err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM processed_searches WHERE search_term = $1)", term).Scan(&exists)
-	if err != nil {
-		log.Printf("Error checking processed term: %v", err)
-		return false // Fallback: antag at den ikke er behandlet
-	}
-	return exists
-}
+	// Sitemaps and feeds add their URLs to the same queue.
+	runIngestSources(ctx)
 
-func markAsProcessed(term string) {
-	_, err := db.Exec("INSERT INTO processed_searches (search_term) VALUES ($1) ON CONFLICT DO NOTHING", term)
-	if err != nil {
-		log.Printf("Error marking term as processed: %v", err)
+	requeueStaleScrapeJobs(30 * time.Minute)
+
+	if drainScrapeQueue(ctx) == 0 {
+		fmt.Println("No scrape jobs due.")
 	}
 }
 
-func StartScraping(logPath string) {
-	searchTerms := extractSearchTerms(logPath)
-	if len(searchTerms) == 0 {
-		fmt.Println("No search terms found.")
-		return
+// queueSearchedTerms queues every term searched for since the last run, one
+// batch of events at a time.
+func queueSearchedTerms() {
+	for {
+		searchTerms, commit, more, err := searchEvents.NewTerms()
+		if err != nil {
+			log.Printf("Could not read search events: %v", err)
+			return
+		}
+		for _, term := range searchTerms {
+			if err := enqueueScrapeJob(term); err != nil {
+				// Only move past these events once every term made it into
+				// the queue.
+				log.Printf("%v", err)
+				return
+			}
+		}
+		if err := commit(); err != nil {
+			log.Printf("Error saving search event position: %v", err)
+			return
+		}
+		if !more {
+			return
+		}
 	}
+}
 
-	for _, term := range searchTerms {
-		if alreadyProcessed(term) {
-			fmt.Printf("Skipping already processed term: %s\n", term)
-			continue
+// tryScrapeInLanguages resolves a logged term to the best matching articles
+// with Wikipedia's search, trying each language in turn, and returns the
+// first scrapeTopK articles that could be fetched. Matches that are already
+// stored, or disambiguation pages that were dealt with, count as handled and
+// return no pages and no error.
+func tryScrapeInLanguages(ctx context.Context, term string, langs []string) ([]Page, error) {
+	for _, lang := range langs {
+		if err := ctx.Err(); err != nil {
+			return nil, err
 		}
 
-		page, lang, err := tryScrapeInLanguages(term, []string{"da", "en"})
+		titles, err := wikiClient.Search(ctx, lang, term, scrapeTopK)
 		if err != nil {
-			log.Printf("Failed to scrape any language for term '%s': %v", term, err)
+			log.Printf("Failed searching %s (%s): %v", term, lang, err)
 			continue
 		}
 
-		err = savePageToDBWithLang(page, lang)
-		if err != nil {
-			log.Printf("Error saving page to DB: %v", err)
-			continue
-		}
+		var pages []Page
+		handled := false
+		for _, title := range titles {
+			if url, ok := knownPageURL(lang, title); ok {
+				fmt.Printf("Already stored: %s (%s) -> %s\n", title, lang, url)
+				handled = true
+				continue
+			}
 
-		markAsProcessed(term)
+			fmt.Printf("Trying to fetch: %s (%s)\n", title, lang)
+			page, err := scrapeWikipedia(ctx, title, lang)
+			if errors.Is(err, errDisambiguationPage) {
+				handled = handleDisambiguation(ctx, lang, title) || handled
+				continue
+			}
+			if err != nil {
+				log.Printf("Failed scraping %s (%s): %v", title, lang, err)
+				continue
+			}
+			pages = append(pages, page)
+		}
+		if len(pages) > 0 || handled {
+			return pages, nil
+		}
 	}
+	return nil, fmt.Errorf("no valid Wikipedia page found for term '%s'", term)
 }
 
-func tryScrapeInLanguages(term string, langs []string) (Page, string, error) {
-	for _, lang := range langs {
-		url := buildWikipediaURL(term, lang)
-		fmt.Printf("Trying to scrape: %s\n", url)
-		page, err := scrapeWikipedia(url, lang)
-		if err == nil && page.Title != "" {
-			return page, lang, nil
-		}
-		log.Printf("Failed scraping %s (%s): %v", term, lang, err)
+// scrapeWikipedia fetches an article through the MediaWiki API.
+func scrapeWikipedia(ctx context.Context, title string, lang string) (Page, error) {
+	article, err := wikiClient.Article(ctx, lang, title)
+	if err != nil {
+		return Page{}, err
 	}
-	return Page{}, "", fmt.Errorf("no valid Wikipedia page found for term '%s'", term)
-}
+	if article.Disambiguation {
+		return Page{}, fmt.Errorf("%q: %w", article.Title, errDisambiguationPage)
+	}
+	if article.Extract == "" {
+		return Page{}, fmt.Errorf("article %q has no text", article.Title)
+	}
+	page := article.toPage()
 
-func buildWikipediaURL(term, lang string) string {
-	term = strings.ReplaceAll(term, " ", "_")
-	c := cases.Title(language.Und)
-	return fmt.Sprintf("https://%s.wikipedia.org/wiki/%s", lang, c.String(term))
+	// The structured parts are a bonus; the page is still worth keeping
+	// without them.
+	structure, err := wikiClient.Structure(ctx, lang, article.Title)
+	if err != nil {
+		log.Printf("Could not extract structure for %s (%s): %v", article.Title, lang, err)
+		return page, nil
+	}
+	page.Sections = structure.Sections
+	page.Infobox = structure.Infobox
+	page.ImageCaptions = structure.ImageCaptions
+	page.Links = structure.Links
+	return page, nil
 }
 
-func scrapeWikipedia(url string, lang string) (Page, error) {
-	c := colly.NewCollector(
-		colly.AllowedDomains(fmt.Sprintf("%s.wikipedia.org", lang)),
-	)
-
-	page := Page{URL: url, Language: lang}
-	var statusCode int
-
-	c.OnResponse(func(r *colly.Response) {
-		statusCode = r.StatusCode
-	})
-
-	c.OnHTML("#firstHeading", func(e *colly.HTMLElement) {
-		page.Title = e.Text
-	})
-
-	c.OnHTML("div.mw-parser-output", func(e *colly.HTMLElement) {
-		text := ""
-		e.ForEach("p", func(_ int, el *colly.HTMLElement) {
-			text += el.Text + "\n"
-		})
-		page.Content = text
-	})
+// handleDisambiguation either skips a disambiguation page or queues the
+// articles it lists, depending on scrapeDisambiguation. It reports whether
+// the page was dealt with.
+func handleDisambiguation(ctx context.Context, lang, title string) bool {
+	if scrapeDisambiguation != "enqueue" {
+		log.Printf("Skipping disambiguation page %s (%s)", title, lang)
+		scraperSkippedURLs.WithLabelValues(lang+".wikipedia.org", "disambiguation").Inc()
+		return true
+	}
 
-	err := c.Visit(url)
+	links, err := wikiClient.Links(ctx, lang, title, scrapeDisambiguationLinks)
 	if err != nil {
-		return page, err
+		log.Printf("Error listing candidates on disambiguation page %s (%s): %v", title, lang, err)
+		return false
 	}
 
-	if statusCode == 404 {
-		return page, fmt.Errorf("page not found (404)")
+	for _, link := range links {
+		term := normalizeSearchTerm(link)
+		if term == "" {
+			continue
+		}
+		if err := enqueueScrapeJob(term); err != nil {
+			log.Printf("%v", err)
+		}
 	}
+	log.Printf("Queued %d candidates from disambiguation page %s (%s)", len(links), title, lang)
+	return true
+}
 
-	return page, nil
+// knownPageURL returns the stored page a title or one of its redirects
+// points to, so variant titles are not fetched again.
+func knownPageURL(lang, title string) (string, bool) {
+	var url string
+	err := db.QueryRow(`
+		SELECT url FROM page_redirects WHERE language = $1 AND LOWER(title) = LOWER($2)
+		UNION ALL
+		SELECT url FROM pages WHERE COALESCE(source_language, language) = $1 AND LOWER(title) = LOWER($2) AND gone_at IS NULL
+		LIMIT 1
+	`, lang, title).Scan(&url)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("Error looking up known page for %q: %v", title, err)
+		}
+		return "", false
+	}
+	return url, true
 }
 
+// savePageToDBWithLang stores a page fetched from the lang edition of
+// Wikipedia. The page itself is stored under the language detected from its
+// text, which usually but not always matches the source.
 func savePageToDBWithLang(page Page, lang string) error {
 	if page.Title == "" || page.URL == "" || page.Content == "" {
 		return fmt.Errorf("invalid page data")
 	}
+	contentLang, detected, confidence := pageLanguage(page, lang)
 
-	_, err := db.Exec(`
-		INSERT INTO pages (url, title, content, language, last_updated)
-		VALUES ($1, $2, $3, $4, NOW())
+	infobox, err := json.Marshal(nonNilMap(page.Infobox))
+	if err != nil {
+		return fmt.Errorf("error encoding infobox: %v", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	// Keep the version we are about to overwrite.
+	archived, err := archivePageVersion(tx, page.URL, page.Content)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO pages (url, title, content, language, last_updated, etag, http_last_modified, last_crawled_at,
+		                   page_id, revision_id, categories,
+		                   summary, sections, infobox, image_captions, outgoing_links,
+		                   source_language, detected_language, language_confidence, source, published_at)
+		VALUES ($1, $2, $3, $4, NOW(), $5, $6, NOW(), $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19)
 		ON CONFLICT (url) DO UPDATE
 		SET title = EXCLUDED.title,
 		    content = EXCLUDED.content,
 		    language = EXCLUDED.language,
-		    last_updated = NOW()
-	`, page.URL, page.Title, page.Content, lang)
+		    source_language = EXCLUDED.source_language,
+		    detected_language = EXCLUDED.detected_language,
+		    language_confidence = EXCLUDED.language_confidence,
+		    source = EXCLUDED.source,
+		    published_at = EXCLUDED.published_at,
+		    last_updated = NOW(),
+		    etag = EXCLUDED.etag,
+		    http_last_modified = EXCLUDED.http_last_modified,
+		    last_crawled_at = NOW(),
+		    gone_at = NULL,
+		    page_id = EXCLUDED.page_id,
+		    revision_id = EXCLUDED.revision_id,
+		    categories = EXCLUDED.categories,
+		    summary = EXCLUDED.summary,
+		    sections = EXCLUDED.sections,
+		    infobox = EXCLUDED.infobox,
+		    image_captions = EXCLUDED.image_captions,
+		    outgoing_links = EXCLUDED.outgoing_links
+	`, page.URL, page.Title, page.Content, contentLang, page.ETag, page.LastModified,
+		sql.NullInt64{Int64: int64(page.PageID), Valid: page.PageID != 0},
+		sql.NullInt64{Int64: page.RevisionID, Valid: page.RevisionID != 0},
+		pq.Array(nonNilStrings(page.Categories)),
+		page.Summary,
+		pq.Array(nonNilStrings(page.Sections)),
+		string(infobox),
+		pq.Array(nonNilStrings(page.ImageCaptions)),
+		pq.Array(nonNilStrings(page.Links)),
+		lang,
+		sql.NullString{String: detected, Valid: detected != ""},
+		confidence,
+		firstNonEmpty(page.Source, sourceWikipedia),
+		sql.NullTime{Time: page.PublishedAt, Valid: !page.PublishedAt.IsZero()})
 	if err != nil {
 		return fmt.Errorf("error inserting or updating page: %v", err)
 	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing page: %v", err)
+	}
+	if archived {
+		if err := prunePageVersions(page.URL); err != nil {
+			log.Printf("Error pruning versions of %s: %v", page.URL, err)
+		}
+	}
 
-	log.Printf("Saved page to DB [%s]: %s", lang, page.Title)
+	if _, err := assignCluster(page.URL, simhash(page.Content)); err != nil {
+		log.Printf("Error clustering %s: %v", page.URL, err)
+	}
+
+	for _, title := range page.Redirects {
+		_, err := db.Exec(`
+			INSERT INTO page_redirects (language, title, url)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (language, title) DO UPDATE SET url = EXCLUDED.url
+		`, lang, title, page.URL)
+		if err != nil {
+			log.Printf("Error saving redirect %q -> %s: %v", title, page.URL, err)
+		}
+	}
+
+	if contentLang != lang {
+		log.Printf("Page %s from %s.wikipedia.org detected as %s (confidence %.2f)", page.Title, lang, contentLang, confidence)
+	}
+	log.Printf("Saved page to DB [%s]: %s", contentLang, page.Title)
 	return nil
 }
+
+// nonNilStrings makes sure a nil slice is stored as an empty array rather
+// than NULL.
+func nonNilStrings(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
+
+func nonNilMap(m map[string]string) map[string]string {
+	if m == nil {
+		return map[string]string{}
+	}
+	return m
+}
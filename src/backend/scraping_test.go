@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScrapeWikipedia(t *testing.T) {
+	testCases := []struct {
+		name    string
+		title   string
+		lang    string
+		wantErr error
+		check   func(t *testing.T, page Page)
+	}{
+		{
+			name:  "Article",
+			title: "Go (programming language)",
+			lang:  "en",
+			check: func(t *testing.T, page Page) {
+				assert.Equal(t, "Go (programming language)", page.Title)
+				assert.Equal(t, "https://en.wikipedia.org/wiki/Go_(programming_language)", page.URL)
+				assert.Equal(t, "Go is a high-level general purpose programming language that is statically typed and compiled.", page.Summary)
+				assert.Equal(t, []string{"History", "Design"}, page.Sections)
+				assert.Equal(t, "Robert Griesemer, Rob Pike, Ken Thompson", page.Infobox["Designed by"])
+				assert.Equal(t, []string{"Programming languages created in 2009"}, page.Categories)
+				assert.Equal(t, sourceWikipedia, page.Source)
+			},
+		},
+		{
+			name:  "Redirect",
+			title: "Kbh",
+			lang:  "da",
+			check: func(t *testing.T, page Page) {
+				assert.Equal(t, "København", page.Title)
+				assert.Equal(t, []string{"Kbh"}, page.Redirects)
+				assert.Contains(t, page.Content, "Danmarks hovedstad")
+			},
+		},
+		{
+			name:    "Missing page",
+			title:   "Xyzzy Plugh Gosearch",
+			lang:    "en",
+			wantErr: errWikiPageNotFound,
+		},
+		{
+			name:    "Disambiguation page",
+			title:   "Mercury",
+			lang:    "en",
+			wantErr: errDisambiguationPage,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			useFixtureClient(t)
+
+			page, err := scrapeWikipedia(context.Background(), tc.title, tc.lang)
+			if tc.wantErr != nil {
+				assert.ErrorIs(t, err, tc.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			tc.check(t, page)
+		})
+	}
+}
+
+func TestScrapeWikipediaEmptyContent(t *testing.T) {
+	useFixtureClient(t)
+
+	_, err := scrapeWikipedia(context.Background(), "Tom side", "da")
+	assert.ErrorContains(t, err, "has no text")
+}
+
+func TestTryScrapeInLanguages(t *testing.T) {
+	testCases := []struct {
+		name      string
+		term      string
+		langs     []string
+		mockSetup func(mock sqlmock.Sqlmock)
+		wantPages []string
+		wantErr   bool
+	}{
+		{
+			name:  "Search hit",
+			term:  "golang",
+			langs: []string{"en"},
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT url FROM page_redirects").
+					WithArgs("en", "Go (programming language)").WillReturnError(sql.ErrNoRows)
+			},
+			wantPages: []string{"Go (programming language)"},
+		},
+		{
+			name:  "Already stored",
+			term:  "golang",
+			langs: []string{"en"},
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT url FROM page_redirects").
+					WithArgs("en", "Go (programming language)").
+					WillReturnRows(sqlmock.NewRows([]string{"url"}).AddRow("https://en.wikipedia.org/wiki/Go_(programming_language)"))
+			},
+		},
+		{
+			name:  "Disambiguation page queues its candidates",
+			term:  "mercury",
+			langs: []string{"en"},
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT url FROM page_redirects").
+					WithArgs("en", "Mercury").WillReturnError(sql.ErrNoRows)
+				for _, link := range []string{"Mercury (element)", "Mercury (planet)", "Freddie Mercury"} {
+					mock.ExpectExec("INSERT INTO scrape_jobs").WithArgs(normalizeSearchTerm(link)).
+						WillReturnResult(sqlmock.NewResult(1, 1))
+				}
+			},
+		},
+		{
+			name:  "Falls back to the next language",
+			term:  "kbh",
+			langs: []string{"en", "da"},
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT url FROM page_redirects").
+					WithArgs("da", "København").WillReturnError(sql.ErrNoRows)
+			},
+			wantPages: []string{"København"},
+		},
+		{
+			name:      "No match anywhere",
+			term:      "xyzzy plugh gosearch",
+			langs:     []string{"da", "en"},
+			mockSetup: func(mock sqlmock.Sqlmock) {},
+			wantErr:   true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			useFixtureClient(t)
+			mockDB, mock := setupMockDB()
+			defer mockDB.Close()
+			tc.mockSetup(mock)
+
+			pages, err := tryScrapeInLanguages(context.Background(), tc.term, tc.langs)
+			if tc.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			var titles []string
+			for _, p := range pages {
+				titles = append(titles, p.Title)
+			}
+			assert.Equal(t, tc.wantPages, titles)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestScrapeURLFixtures(t *testing.T) {
+	testCases := []struct {
+		name        string
+		url         string
+		wantErr     bool
+		wantURL     string
+		wantTitle   string
+		wantContent string
+	}{
+		{
+			name:    "Not found",
+			url:     "https://docs.example.com/missing",
+			wantErr: true,
+		},
+		{
+			name:        "Redirect",
+			url:         "https://docs.example.com/old-install",
+			wantURL:     "https://docs.example.com/install",
+			wantTitle:   "Install",
+			wantContent: "Download the latest release.",
+		},
+		{
+			name:        "ISO-8859-1 from the Content-Type header",
+			url:         "https://nyheder.example.dk/koebenhavn",
+			wantURL:     "https://nyheder.example.dk/koebenhavn",
+			wantTitle:   "Københavns Rådhus får nyt tårnur",
+			wantContent: "Rådhusets tårnur bliver udskiftet i år.",
+		},
+		{
+			name:        "windows-1252 from a meta tag",
+			url:         "https://blog.example.com/cafe",
+			wantURL:     "https://blog.example.com/cafe",
+			wantTitle:   "Café “Über” – menu",
+			wantContent: "Crème brûlée for 5 €.",
+		},
+		{
+			name:    "Empty page",
+			url:     "https://docs.example.com/empty",
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			useFixtureClient(t)
+
+			page, err := scrapeURL(context.Background(), ingestEntry{URL: tc.url})
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.wantURL, page.URL)
+			assert.Equal(t, tc.wantTitle, page.Title)
+			assert.Contains(t, page.Content, tc.wantContent)
+		})
+	}
+}
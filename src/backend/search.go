@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"html/template"
@@ -9,6 +10,8 @@ import (
 	"net/http"
 	"strings"
 	"time"
+
+	"github.com/lib/pq"
 )
 
 func searchHandler(w http.ResponseWriter, r *http.Request) {
@@ -21,24 +24,34 @@ func searchHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	//TO LOG THE QUERY//
-	log.Printf("Search query: %q from %s", queryParam, r.RemoteAddr)
-	searchLogger.Printf("query=%q from=%s", queryParam, r.RemoteAddr)
+	log.Printf("Search query: %q", queryParam)
+	started := time.Now()
+
+	// Optional language filter, e.g. language=da
+	language := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("language")))
 
 	//Nuild search against Elasticsearch
-	pages, err := searchPagesInEs(queryParam)
+	pages, err := searchPagesInEs(queryParam, language)
 	if err != nil {
 		log.Printf("Error searching Elasticsearch: %v", err)
 		http.Error(w, "Error during search", http.StatusInternalServerError)
 		return
 	}
+	recordSearch(r, queryParam, language, len(pages), time.Since(started))
 
 	// Build search results from Elasticsearch response
 	var searchResults []map[string]string
-	for _, page := range pages {
+	for i, page := range pages {
+		// Prefer the lead paragraph as the snippet when we have one
+		description := page.Summary
+		if description == "" {
+			description = page.Content
+		}
 		searchResults = append(searchResults, map[string]string{
 			"title":       page.Title,
 			"url":         page.URL,
-			"description": page.Content,
+			"click_url":   clickURL(queryParam, page.URL, i+1),
+			"description": description,
 		})
 	}
 
@@ -50,8 +63,18 @@ func searchHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	data := map[string]interface{}{
-		"Query":   queryParam,
-		"Results": searchResults,
+		"Query":        queryParam,
+		"Language":     language,
+		"Results":      searchResults,
+		"UserLoggedIn": userIsLoggedIn(r),
+	}
+	if userID, ok := sessionUserID(r); ok {
+		// Offer the user's collections in the bookmark form.
+		names, err := collectionNames(userID)
+		if err != nil {
+			log.Printf("%v", err)
+		}
+		data["Collections"] = names
 	}
 
 	if err := tmpl.ExecuteTemplate(w, "layout.html", data); err != nil {
@@ -60,24 +83,44 @@ func searchHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func searchPagesInEs(query string) ([]Page, error) {
+// searchPagesInEs searches the pages index. When language is set, only
+// pages in that language are returned.
+func searchPagesInEs(query, language string) ([]Page, error) {
 	///// TESTS FALLBACK ///////////
 	if esClient == nil {
 		// Simple DB search for test mode
 		var pages []Page
-		sqlStmt := "SELECT title, url, content FROM pages WHERE content LIKE ?"
-		likeQ := "%" + query + "%"
-		rows, err := db.Query(sqlStmt, likeQ)
+		// Match any of the rewrites the synonym rules give, like the
+		// synonym_graph filter does in Elasticsearch.
+		var matches []string
+		var args []any
+		for _, variant := range expandQuery(query, currentSynonymRules()) {
+			matches = append(matches, "content LIKE ?")
+			args = append(args, "%"+variant+"%")
+		}
+		sqlStmt := "SELECT title, url, content, COALESCE(cluster_id, url) FROM pages WHERE (" +
+			strings.Join(matches, " OR ") + ") AND gone_at IS NULL"
+		if language != "" {
+			sqlStmt += " AND language = ?"
+			args = append(args, language)
+		}
+		rows, err := db.Query(sqlStmt, args...)
 		if err != nil {
 			return nil, err
 		}
 		defer rows.Close()
 
+		seenClusters := make(map[string]bool)
 		for rows.Next() {
 			var p Page
-			if err := rows.Scan(&p.Title, &p.URL, &p.Content); err != nil {
+			if err := rows.Scan(&p.Title, &p.URL, &p.Content, &p.ClusterID); err != nil {
 				continue
 			}
+			// One result per near-duplicate cluster, like collapse in ES.
+			if seenClusters[p.ClusterID] {
+				continue
+			}
+			seenClusters[p.ClusterID] = true
 			pages = append(pages, p)
 		}
 		return pages, nil
@@ -85,14 +128,19 @@ func searchPagesInEs(query string) ([]Page, error) {
 	/////// PRODUCTION: real Elasticsearch search ───────────────────────────
 	var pages []Page
 
-	searchBody := strings.NewReader(fmt.Sprintf(`{
-		"query": {
-			"multi_match": {
-				"query": "%s",
-				"fields": ["title^3", "url^2", "content"]
-			}
-		}
-	}`, query))
+	pinned, buried, err := loadQueryOverrides(normalizeSearchTerm(query))
+	if err != nil {
+		log.Printf("Searching without result overrides: %v", err)
+	}
+	// Collapse near-duplicates so each cluster shows up once, as its best hit.
+	body, err := json.Marshal(map[string]any{
+		"query":    searchQuery(query, language, pinned, buried),
+		"collapse": map[string]any{"field": "cluster_id"},
+	})
+	if err != nil {
+		return pages, err
+	}
+	searchBody := strings.NewReader(string(body))
 
 	res, err := esClient.Search(
 		esClient.Search.WithContext(context.Background()),
@@ -124,6 +172,46 @@ func searchPagesInEs(query string) ([]Page, error) {
 	return pages, nil
 }
 
+// searchQuery builds the Elasticsearch query for a search. Pinned URLs are
+// returned first even when they don't match the text; buried URLs still
+// match but come last.
+func searchQuery(query, language string, pinned, buried []string) map[string]any {
+	// content_* are the language-analyzed copies of content; each page only
+	// has the one for its own language.
+	match := map[string]any{
+		"multi_match": map[string]any{
+			"query":  query,
+			"fields": []string{"title^3", "url^2", "summary^2", "infobox_text^2", "sections", "image_captions", "content", "content_*"},
+		},
+	}
+	boolQuery := map[string]any{"must": match}
+	if len(pinned) > 0 {
+		boolQuery = map[string]any{
+			"should": []any{
+				match,
+				map[string]any{"constant_score": map[string]any{
+					"filter": map[string]any{"terms": map[string]any{"url": pinned}},
+					"boost":  pinnedBoost,
+				}},
+			},
+			"minimum_should_match": 1,
+		}
+	}
+	if language != "" {
+		boolQuery["filter"] = map[string]any{"term": map[string]any{"language": language}}
+	}
+
+	q := map[string]any{"bool": boolQuery}
+	if len(buried) > 0 {
+		q = map[string]any{"boosting": map[string]any{
+			"positive":       q,
+			"negative":       map[string]any{"terms": map[string]any{"url": buried}},
+			"negative_boost": buriedBoost,
+		}}
+	}
+	return q
+}
+
 func syncPagesToElasticsearch() error {
 	// Først, slet indekset hvis det eksisterer
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -156,18 +244,10 @@ func syncPagesToElasticsearch() error {
 	}
 
 	// Opret indekset med korrekte mappings
-	mappings := `{
-        "mappings": {
-            "properties": {
-                "title": { "type": "text" },
-                "url": { "type": "keyword" },
-                "content": { "type": "text" },
-                "language": { "type": "keyword" },
-                "last_updated": { "type": "date" }
-            }
-        }
-    }`
-
+	mappings, err := pagesIndexMappings()
+	if err != nil {
+		return err
+	}
 	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
 	createRes, err := esClient.Indices.Create(
 		"pages",
@@ -185,7 +265,10 @@ func syncPagesToElasticsearch() error {
 	}
 
 	// Hent og indekser alle sider fra databasen
-	rows, err := db.Query("SELECT title, url, content FROM pages")
+	rows, err := db.Query(`
+		SELECT title, url, content, language, COALESCE(cluster_id, url), COALESCE(summary, ''),
+		       sections, infobox, image_captions, outgoing_links, categories, published_at
+		FROM pages WHERE gone_at IS NULL`)
 	if err != nil {
 		return fmt.Errorf("error querying pages from DB: %w", err)
 	}
@@ -193,19 +276,48 @@ func syncPagesToElasticsearch() error {
 
 	count := 0
 	for rows.Next() {
-		var title, url, content string
-		if err := rows.Scan(&title, &url, &content); err != nil {
+		var title, url, content, language, clusterID, summary string
+		var sections, imageCaptions, links, categories []string
+		var infoboxJSON []byte
+		var publishedAt sql.NullTime
+		if err := rows.Scan(&title, &url, &content, &language, &clusterID, &summary, pq.Array(&sections), &infoboxJSON,
+			pq.Array(&imageCaptions), pq.Array(&links), pq.Array(&categories), &publishedAt); err != nil {
 			log.Printf("Error scanning row: %v", err)
 			continue
 		}
 
+		infobox := map[string]string{}
+		if len(infoboxJSON) > 0 {
+			if err := json.Unmarshal(infoboxJSON, &infobox); err != nil {
+				log.Printf("Error decoding infobox for %s: %v", url, err)
+			}
+		}
+		var infoboxText []string
+		for k, v := range infobox {
+			infoboxText = append(infoboxText, k+": "+v)
+		}
+
 		// Opret dokument med de rigtige feltnavne
 		docMap := map[string]interface{}{
-			"title":        title,
-			"url":          url,
-			"content":      content,
-			"language":     "",
-			"last_updated": time.Now().Format(time.RFC3339),
+			"title":          title,
+			"url":            url,
+			"content":        content,
+			"summary":        summary,
+			"sections":       sections,
+			"infobox":        infobox,
+			"infobox_text":   strings.Join(infoboxText, "\n"),
+			"image_captions": imageCaptions,
+			"links":          links,
+			"categories":     categories,
+			"language":       language,
+			"cluster_id":     clusterID,
+			"last_updated":   time.Now().Format(time.RFC3339),
+		}
+		if publishedAt.Valid {
+			docMap["published_at"] = publishedAt.Time.Format(time.RFC3339)
+		}
+		if _, ok := languageAnalyzers[language]; ok {
+			docMap["content_"+language] = content
 		}
 
 		doc, err := json.Marshal(docMap)
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Every search is recorded as a structured event. Events go to the
+// search_events table by default, or to a JSON-lines file when
+// SEARCH_EVENTS_STORE=jsonl. The scraper reads new search terms back from
+// the same store.
+
+const scraperEventConsumer = "scraper"
+
+type searchEvent struct {
+	Time            time.Time `json:"time"`
+	Query           string    `json:"query"`
+	NormalizedQuery string    `json:"normalized_query"`
+	// Language is the language the search was restricted to, if any.
+	Language    string            `json:"language,omitempty"`
+	Filters     map[string]string `json:"filters,omitempty"`
+	ResultCount int               `json:"result_count"`
+	LatencyMS   int64             `json:"latency_ms"`
+	UserID      int               `json:"user_id,omitempty"`
+	// ClientIPAnon is the anonymized client address: a keyed hash or the
+	// address with its host part zeroed, depending on searchIPAnonymization.
+	ClientIPAnon string `json:"client_ip_anon,omitempty"`
+}
+
+// UnmarshalJSON also reads the client_ip_hash key that older event files
+// used for ClientIPAnon.
+func (ev *searchEvent) UnmarshalJSON(data []byte) error {
+	type plainEvent searchEvent
+	var v struct {
+		plainEvent
+		ClientIPHash string `json:"client_ip_hash"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*ev = searchEvent(v.plainEvent)
+	if ev.ClientIPAnon == "" {
+		ev.ClientIPAnon = v.ClientIPHash
+	}
+	return nil
+}
+
+type searchEventStore interface {
+	Record(ev searchEvent) error
+	// NewTerms returns the normalized, de-duplicated queries of at most
+	// searchEventBatchSize events recorded since the last commit, a function
+	// that commits this read, and whether more events may be waiting. Call
+	// the function once the terms are safely queued.
+	NewTerms() ([]string, func() error, bool, error)
+}
+
+// searchEventBatchSize bounds how many events or log lines NewTerms reads at
+// once.
+const searchEventBatchSize = 1000
+
+// searchEvents is set up in main; searches are not recorded while it is nil.
+var searchEvents searchEventStore
+
+// searchIPSalt keys client IP hashes. Set in config.go.
+var searchIPSalt string
+
+// newSearchEventStoreFromEnv picks the store named by SEARCH_EVENTS_STORE:
+// "postgres" (the default) or "jsonl", which appends to SEARCH_LOG_PATH.
+func newSearchEventStoreFromEnv() searchEventStore {
+	if os.Getenv("SEARCH_EVENTS_STORE") != "jsonl" {
+		return postgresEventStore{}
+	}
+	path := os.Getenv("SEARCH_LOG_PATH")
+	if path == "" {
+		path = "search.log"
+	}
+	store, err := newJSONLEventStore(path)
+	if err != nil {
+		log.Printf("Warning: could not open search event log %s, storing events in the database instead: %v", path, err)
+		return postgresEventStore{}
+	}
+	log.Printf("Search events will be written to %s", path)
+	return store
+}
+
+// recordSearch stores a search event and adds the search to the user's
+// history, logging rather than failing the search when that is not possible.
+func recordSearch(r *http.Request, query, language string, results int, latency time.Duration) {
+	userID, clientIP := searchIdentity(r)
+	if userID != 0 {
+		if err := addSearchHistory(userID, query, searchFilters(language)); err != nil {
+			log.Printf("%v", err)
+		}
+	}
+	if searchEvents == nil {
+		return
+	}
+	ev := searchEvent{
+		Time:            time.Now().UTC(),
+		Query:           query,
+		NormalizedQuery: normalizeSearchTerm(query),
+		Language:        language,
+		ResultCount:     results,
+		LatencyMS:       latency.Milliseconds(),
+		Filters:         searchFilters(language),
+		UserID:          userID,
+		ClientIPAnon:    clientIP,
+	}
+	if err := searchEvents.Record(ev); err != nil {
+		log.Printf("Error recording search event: %v", err)
+	}
+}
+
+// searchFilters returns the filters a search was restricted by, or nil.
+func searchFilters(language string) map[string]string {
+	if language == "" {
+		return nil
+	}
+	return map[string]string{"language": language}
+}
+
+// encodeFilters turns search filters into a value for a jsonb column.
+func encodeFilters(filters map[string]string) (sql.NullString, error) {
+	if len(filters) == 0 {
+		return sql.NullString{}, nil
+	}
+	data, err := json.Marshal(filters)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+	return sql.NullString{String: string(data), Valid: true}, nil
+}
+
+// decodeFilters reads search filters back from a jsonb column.
+func decodeFilters(data []byte) (map[string]string, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	var filters map[string]string
+	if err := json.Unmarshal(data, &filters); err != nil {
+		return nil, fmt.Errorf("error decoding search filters: %w", err)
+	}
+	return filters, nil
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func hashClientIP(ip string) string {
+	if ip == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, []byte(searchIPSalt))
+	mac.Write([]byte(ip))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// postgresEventStore keeps events in search_events. Readers track their
+// position by event ID in search_event_cursors.
+type postgresEventStore struct{}
+
+func (postgresEventStore) Record(ev searchEvent) error {
+	filters, err := encodeFilters(ev.Filters)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`
+		INSERT INTO search_events
+			(created_at, query, normalized_query, language, filters, result_count, latency_ms, user_id, client_ip_anon)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`, ev.Time, ev.Query, ev.NormalizedQuery, sql.NullString{String: ev.Language, Valid: ev.Language != ""},
+		filters, ev.ResultCount, ev.LatencyMS, sql.NullInt64{Int64: int64(ev.UserID), Valid: ev.UserID != 0},
+		sql.NullString{String: ev.ClientIPAnon, Valid: ev.ClientIPAnon != ""})
+	if err != nil {
+		return fmt.Errorf("error saving search event: %w", err)
+	}
+	return nil
+}
+
+func (postgresEventStore) NewTerms() ([]string, func() error, bool, error) {
+	var lastID int64
+	err := db.QueryRow("SELECT last_event_id FROM search_event_cursors WHERE consumer = $1",
+		scraperEventConsumer).Scan(&lastID)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, nil, false, fmt.Errorf("error loading search event cursor: %w", err)
+	}
+
+	rows, err := db.Query(`
+		SELECT id, normalized_query FROM search_events WHERE id > $1 ORDER BY id LIMIT $2
+	`, lastID, searchEventBatchSize)
+	if err != nil {
+		return nil, nil, false, fmt.Errorf("error reading search events: %w", err)
+	}
+	defer rows.Close()
+
+	var raw []string
+	next := lastID
+	for rows.Next() {
+		var term string
+		if err := rows.Scan(&next, &term); err != nil {
+			return nil, nil, false, fmt.Errorf("error scanning search event: %w", err)
+		}
+		raw = append(raw, term)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, false, fmt.Errorf("error reading search events: %w", err)
+	}
+
+	commit := func() error {
+		_, err := db.Exec(`
+			INSERT INTO search_event_cursors (consumer, last_event_id, updated_at)
+			VALUES ($1, $2, NOW())
+			ON CONFLICT (consumer) DO UPDATE
+			SET last_event_id = EXCLUDED.last_event_id, updated_at = NOW()
+		`, scraperEventConsumer, next)
+		return err
+	}
+	return dedupeTerms(raw), commit, len(raw) == searchEventBatchSize, nil
+}
+
+// jsonlEventStore appends one JSON object per line to a file and tails it
+// like the old text search log, which it can still read.
+type jsonlEventStore struct {
+	path string
+	mu   sync.Mutex
+	file *os.File
+}
+
+func newJSONLEventStore(path string) (*jsonlEventStore, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &jsonlEventStore{path: path, file: f}, nil
+}
+
+func (s *jsonlEventStore) Record(ev searchEvent) error {
+	line, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.file.Write(append(line, '\n'))
+	return err
+}
+
+// readEvents returns the JSON events in the file for which keep is true.
+func (s *jsonlEventStore) readEvents(keep func(searchEvent) bool) ([]searchEvent, error) {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var events []searchEvent
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 && line[0] == '{' {
+			var ev searchEvent
+			if json.Unmarshal(line, &ev) == nil && keep(ev) {
+				events = append(events, ev)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return events, nil
+}
+
+func (s *jsonlEventStore) NewTerms() ([]string, func() error, bool, error) {
+	terms, commit, more := extractSearchTerms(s.path)
+	return terms, commit, more, nil
+}
+
+func (s *jsonlEventStore) Close() error {
+	return s.file.Close()
+}
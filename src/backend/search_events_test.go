@@ -0,0 +1,113 @@
+package main
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeEventStore struct {
+	events []searchEvent
+}
+
+func (s *fakeEventStore) Record(ev searchEvent) error {
+	s.events = append(s.events, ev)
+	return nil
+}
+
+func (s *fakeEventStore) NewTerms() ([]string, func() error, bool, error) {
+	return nil, func() error { return nil }, false, nil
+}
+
+func TestRecordSearch(t *testing.T) {
+	events := &fakeEventStore{}
+	previous := searchEvents
+	searchEvents = events
+	defer func() { searchEvents = previous }()
+	mockDB, mock := setupMockDB()
+	defer mockDB.Close()
+	mock.ExpectQuery("SELECT search_history_opt_out FROM users").WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"search_history_opt_out"}).AddRow(false))
+	mock.ExpectExec("INSERT INTO search_history").
+		WithArgs(3, "  Go  Lang ", "go lang", sql.NullString{String: `{"language":"da"}`, Valid: true}).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	req := loggedInRequest(t, "GET", "/api/search?q=Go&language=da", "", 3)
+	req.RemoteAddr = "203.0.113.9:52100"
+	recordSearch(req, "  Go  Lang ", "da", 4, 35*time.Millisecond)
+
+	anonymous := loggedInRequest(t, "GET", "/api/search?q=go", "", 0)
+	anonymous.RemoteAddr = "203.0.113.9:52200"
+	recordSearch(anonymous, "go", "", 0, time.Millisecond)
+
+	assert.Len(t, events.events, 2)
+	ev := events.events[0]
+	assert.Equal(t, "go lang", ev.NormalizedQuery)
+	assert.Equal(t, "da", ev.Language)
+	assert.Equal(t, map[string]string{"language": "da"}, ev.Filters)
+	assert.Equal(t, 4, ev.ResultCount)
+	assert.Equal(t, int64(35), ev.LatencyMS)
+	assert.Equal(t, 3, ev.UserID)
+	assert.NotContains(t, ev.ClientIPAnon, "203.0.113.9")
+	assert.Equal(t, ev.ClientIPAnon, events.events[1].ClientIPAnon, "The same client should hash the same regardless of port")
+	assert.Zero(t, events.events[1].UserID)
+	assert.Nil(t, events.events[1].Filters)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresEventStore(t *testing.T) {
+	mockDB, mock := setupMockDB()
+	defer mockDB.Close()
+	store := postgresEventStore{}
+
+	mock.ExpectExec("INSERT INTO search_events").
+		WithArgs(sqlmock.AnyArg(), "Go", "go", sql.NullString{}, sql.NullString{}, 2, int64(12),
+			sql.NullInt64{}, sql.NullString{String: "abc", Valid: true}).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	assert.NoError(t, store.Record(searchEvent{Time: time.Now(), Query: "Go", NormalizedQuery: "go",
+		ResultCount: 2, LatencyMS: 12, ClientIPAnon: "abc"}))
+
+	mock.ExpectQuery("SELECT last_event_id FROM search_event_cursors").WithArgs(scraperEventConsumer).
+		WillReturnRows(sqlmock.NewRows([]string{"last_event_id"}).AddRow(10))
+	mock.ExpectQuery("SELECT id, normalized_query FROM search_events").WithArgs(int64(10), searchEventBatchSize).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "normalized_query"}).
+			AddRow(11, "go").AddRow(12, "rust").AddRow(14, "go"))
+	terms, commit, more, err := store.NewTerms()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"go", "rust"}, terms)
+	assert.False(t, more, "A short batch means there are no more events")
+
+	mock.ExpectExec("INSERT INTO search_event_cursors").WithArgs(scraperEventConsumer, int64(14)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	assert.NoError(t, commit())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestJSONLEventStore(t *testing.T) {
+	mockDB, mock := setupMockDB()
+	defer mockDB.Close()
+
+	path := filepath.Join(t.TempDir(), "search.log")
+	// Lines written before the switch to structured events are still read.
+	appendLog(t, path, "SEARCH: 2025/05/01 10:00:00 query=\"Legacy\" from=1.2.3.4:1\n")
+	store, err := newJSONLEventStore(path)
+	assert.NoError(t, err)
+	defer store.Close()
+
+	assert.NoError(t, store.Record(searchEvent{Query: "Kø  Benhavn", NormalizedQuery: "kø benhavn"}))
+	assert.NoError(t, store.Record(searchEvent{Query: "legacy", NormalizedQuery: "legacy"}))
+
+	mock.ExpectQuery("SELECT inode, byte_offset FROM search_log_offsets").WithArgs(path).WillReturnError(sql.ErrNoRows)
+	terms, commit, more, err := store.NewTerms()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"legacy", "kø benhavn"}, terms)
+	assert.False(t, more)
+
+	mock.ExpectExec("INSERT INTO search_log_offsets").WillReturnResult(sqlmock.NewResult(0, 1))
+	assert.NoError(t, commit())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+const maxSearchTermLength = 100
+
+var searchLogQueryRe = regexp.MustCompile(`query="([^"]+)"`)
+
+// logCursor is how far the scraper has read into the search log. The inode
+// tells us whether the file at the log path is still the one we read from.
+// The inode is saved as an int64, as the inode column is a signed bigint.
+type logCursor struct {
+	Inode  uint64
+	Offset int64
+}
+
+func loadLogCursor(path string) (logCursor, error) {
+	var cur logCursor
+	var inode int64
+	err := db.QueryRow("SELECT inode, byte_offset FROM search_log_offsets WHERE path = $1", path).
+		Scan(&inode, &cur.Offset)
+	if err == sql.ErrNoRows {
+		return logCursor{}, nil
+	}
+	cur.Inode = uint64(inode)
+	return cur, err
+}
+
+func saveLogCursor(path string, cur logCursor) error {
+	_, err := db.Exec(`
+		INSERT INTO search_log_offsets (path, inode, byte_offset, updated_at)
+		VALUES ($1, $2, $3, NOW())
+		ON CONFLICT (path) DO UPDATE
+		SET inode = EXCLUDED.inode,
+		    byte_offset = EXCLUDED.byte_offset,
+		    updated_at = NOW()
+	`, path, int64(cur.Inode), cur.Offset)
+	return err
+}
+
+// readSearchLog returns the normalized, de-duplicated terms from at most
+// maxLines lines logged after cur, the cursor to resume from next time, and
+// whether more lines may be waiting. If the log has been rotated since cur
+// was saved, the rest of the rotated file (path + ".1") is read first.
+func readSearchLog(path string, cur logCursor, maxLines int) ([]string, logCursor, bool, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, cur, false, err
+	}
+	inode := fileInode(fi)
+
+	var terms []string
+	if cur.Inode != 0 && inode != cur.Inode {
+		rotated := path + ".1"
+		if rfi, err := os.Stat(rotated); err == nil && fileInode(rfi) == cur.Inode {
+			rest, offset, lines, err := readLogLines(rotated, cur.Offset, maxLines)
+			switch {
+			case err != nil:
+				log.Printf("Could not finish rotated search log %s: %v", rotated, err)
+			case lines == maxLines:
+				// Stay on the rotated file until it is done.
+				return dedupeTerms(rest), logCursor{Inode: cur.Inode, Offset: offset}, true, nil
+			}
+			terms = append(terms, rest...)
+			maxLines -= lines
+		}
+		cur = logCursor{Inode: inode}
+	}
+	if fi.Size() < cur.Offset {
+		// Truncated in place.
+		cur = logCursor{Inode: inode}
+	}
+	cur.Inode = inode
+
+	fresh, offset, lines, err := readLogLines(path, cur.Offset, maxLines)
+	if err != nil {
+		return nil, cur, false, err
+	}
+	cur.Offset = offset
+
+	return dedupeTerms(append(terms, fresh...)), cur, lines == maxLines, nil
+}
+
+// readLogLines extracts terms from at most maxLines complete lines after
+// offset, and returns the offset after them and the number of lines read. A
+// trailing line without a newline is left for the next run, as it may still
+// be being written.
+func readLogLines(path string, offset int64, maxLines int) ([]string, int64, int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, offset, 0, err
+	}
+	defer file.Close()
+
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return nil, offset, 0, err
+	}
+
+	var terms []string
+	lines := 0
+	reader := bufio.NewReader(file)
+	for lines < maxLines {
+		line, err := reader.ReadString('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return terms, offset, lines, err
+		}
+		offset += int64(len(line))
+		lines++
+
+		if term := searchLogTerm(line); term != "" {
+			terms = append(terms, term)
+		}
+	}
+	return terms, offset, lines, nil
+}
+
+// searchLogTerm returns the query of a search log line: a JSON search event,
+// or a line in the older `query="..." from=...` text format.
+func searchLogTerm(line string) string {
+	if strings.HasPrefix(line, "{") {
+		var ev searchEvent
+		if err := json.Unmarshal([]byte(line), &ev); err != nil {
+			return ""
+		}
+		return firstNonEmpty(ev.NormalizedQuery, ev.Query)
+	}
+	if match := searchLogQueryRe.FindStringSubmatch(line); len(match) > 1 {
+		return match[1]
+	}
+	return ""
+}
+
+// normalizeSearchTerm folds case, Unicode form and whitespace so that
+// "  Kø  benhavn" and "kø benhavn" end up as the same job. It returns "" for
+// terms that are not worth scraping.
+func normalizeSearchTerm(term string) string {
+	term = norm.NFC.String(term)
+	term = strings.ToLower(strings.Join(strings.Fields(term), " "))
+	term = strings.TrimFunc(term, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	if len([]rune(term)) > maxSearchTermLength {
+		return ""
+	}
+	return term
+}
+
+func dedupeTerms(raw []string) []string {
+	seen := make(map[string]bool)
+	var terms []string
+	for _, t := range raw {
+		t = normalizeSearchTerm(t)
+		if t == "" || seen[t] {
+			continue
+		}
+		seen[t] = true
+		terms = append(terms, t)
+	}
+	return terms
+}
+
+// extractSearchTerms returns the terms from the next searchEventBatchSize
+// lines of the log, a function that persists the new read position, and
+// whether more lines may be waiting. Call the function once the terms are
+// safely queued.
+func extractSearchTerms(logPath string) ([]string, func() error, bool) {
+	cur, err := loadLogCursor(logPath)
+	if err != nil {
+		log.Printf("Could not load search log offset, reading from the start: %v", err)
+	}
+
+	terms, next, more, err := readSearchLog(logPath, cur, searchEventBatchSize)
+	if err != nil {
+		log.Printf("Could not read log: %v", err)
+		return nil, func() error { return nil }, false
+	}
+
+	for _, term := range terms {
+		fmt.Printf("Extracted search term: %s\n", term)
+	}
+
+	return terms, func() error { return saveLogCursor(logPath, next) }, more
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func appendLog(t *testing.T, path, text string) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	assert.NoError(t, err)
+	defer f.Close()
+	_, err = f.WriteString(text)
+	assert.NoError(t, err)
+}
+
+func TestReadSearchLogIncremental(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "search.log")
+	appendLog(t, path, "SEARCH: query=\"Go\" from=1.2.3.4:1\nSEARCH: query=\"  go \" from=1.2.3.4:2\n")
+
+	terms, cur, _, err := readSearchLog(path, logCursor{}, searchEventBatchSize)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"go"}, terms, "Terms should be normalized and de-duplicated")
+
+	// A half-written line is left for the next run.
+	appendLog(t, path, "SEARCH: query=\"Kø  Benhavn\" from=1.2.3.4:3\nSEARCH: query=\"rust")
+	terms, cur, _, err = readSearchLog(path, cur, searchEventBatchSize)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"kø benhavn"}, terms)
+
+	appendLog(t, path, "\" from=1.2.3.4:4\n")
+	terms, _, _, err = readSearchLog(path, cur, searchEventBatchSize)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"rust"}, terms)
+}
+
+func TestReadSearchLogAfterRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "search.log")
+	appendLog(t, path, "SEARCH: query=\"first\" from=x\n")
+
+	_, cur, _, err := readSearchLog(path, logCursor{}, searchEventBatchSize)
+	assert.NoError(t, err)
+
+	appendLog(t, path, "SEARCH: query=\"second\" from=x\n")
+	assert.NoError(t, os.Rename(path, path+".1"))
+	appendLog(t, path, "SEARCH: query=\"third\" from=x\n")
+
+	terms, _, _, err := readSearchLog(path, cur, searchEventBatchSize)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"second", "third"}, terms, "Lines left in the rotated file should not be lost")
+}
+
+func TestReadSearchLogInBatches(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "search.log")
+	appendLog(t, path, "SEARCH: query=\"first\" from=x\nSEARCH: query=\"second\" from=x\n")
+	_, cur, _, err := readSearchLog(path, logCursor{}, 1)
+	assert.NoError(t, err)
+
+	assert.NoError(t, os.Rename(path, path+".1"))
+	appendLog(t, path, "SEARCH: query=\"third\" from=x\n")
+
+	var batches [][]string
+	for {
+		terms, next, more, err := readSearchLog(path, cur, 1)
+		assert.NoError(t, err)
+		batches = append(batches, terms)
+		cur = next
+		if !more {
+			break
+		}
+	}
+	assert.Equal(t, [][]string{{"second"}, {"third"}, nil}, batches,
+		"Each batch should resume where the previous one stopped, across the rotation")
+}
+
+func TestLogCursorHighInode(t *testing.T) {
+	mockDB, mock := setupMockDB()
+	defer mockDB.Close()
+	cur := logCursor{Inode: 1<<63 + 5, Offset: 42}
+
+	mock.ExpectExec("INSERT INTO search_log_offsets").WithArgs("search.log", int64(-1<<63+5), int64(42)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	assert.NoError(t, saveLogCursor("search.log", cur))
+
+	mock.ExpectQuery("SELECT inode, byte_offset FROM search_log_offsets").WithArgs("search.log").
+		WillReturnRows(sqlmock.NewRows([]string{"inode", "byte_offset"}).AddRow(int64(-1<<63+5), 42))
+	loaded, err := loadLogCursor("search.log")
+	assert.NoError(t, err)
+	assert.Equal(t, cur, loaded)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestNormalizeSearchTerm(t *testing.T) {
+	assert.Equal(t, "hello world", normalizeSearchTerm("  \"Hello   World!\" "))
+	assert.Equal(t, "", normalizeSearchTerm("???"))
+}
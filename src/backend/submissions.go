@@ -0,0 +1,352 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Logged-in users can suggest URLs to index. Suggestions wait in
+// page_submissions until an admin approves them, which queues a URL job, or
+// rejects them.
+
+const (
+	submissionPending  = "pending"
+	submissionApproved = "approved"
+	submissionRejected = "rejected"
+
+	maxSubmissionReasonLength = 500
+)
+
+// submissionsPerDay is how many URLs one user may submit in 24 hours.
+// Overridden from the environment in config.go.
+var submissionsPerDay = 10
+
+var (
+	errAlreadyIndexed        = errors.New("this page is already indexed")
+	errAlreadySubmitted      = errors.New("this page is already waiting for review")
+	errSubmissionRateLimited = errors.New("submission limit reached")
+	errSubmissionNotPending  = errors.New("submission not found or already reviewed")
+)
+
+type pageSubmission struct {
+	ID          int
+	URL         string
+	Reason      string
+	Status      string
+	SubmittedBy string
+	CreatedAt   time.Time
+	ReviewedBy  string
+	ReviewNote  string
+	ReviewedAt  sql.NullTime
+	JobID       sql.NullInt64
+}
+
+// createSubmission stores a suggestion from userID, enforcing the per-user
+// rate limit. A per-user advisory lock makes counting and inserting atomic,
+// so concurrent requests cannot get past the limit together.
+func createSubmission(userID int, url, reason string) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("SELECT pg_advisory_xact_lock(hashtext('page_submissions'), $1)", userID); err != nil {
+		return 0, fmt.Errorf("error locking submissions of user %d: %w", userID, err)
+	}
+
+	var recent int
+	err = tx.QueryRow(`
+		SELECT COUNT(*) FROM page_submissions WHERE submitted_by = $1 AND created_at > $2
+	`, userID, time.Now().Add(-24*time.Hour)).Scan(&recent)
+	if err != nil {
+		return 0, fmt.Errorf("error counting submissions: %w", err)
+	}
+	if recent >= submissionsPerDay {
+		return 0, errSubmissionRateLimited
+	}
+
+	var indexed bool
+	err = tx.QueryRow("SELECT EXISTS(SELECT 1 FROM pages WHERE url = $1 AND gone_at IS NULL)", url).Scan(&indexed)
+	if err != nil {
+		return 0, fmt.Errorf("error checking for indexed page: %w", err)
+	}
+	if indexed {
+		return 0, errAlreadyIndexed
+	}
+
+	var id int
+	err = tx.QueryRow(`
+		INSERT INTO page_submissions (url, reason, submitted_by)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (url) WHERE status = 'pending' DO NOTHING
+		RETURNING id
+	`, url, sql.NullString{String: reason, Valid: reason != ""}, userID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, errAlreadySubmitted
+	}
+	if err != nil {
+		return 0, fmt.Errorf("error saving submission: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error saving submission: %w", err)
+	}
+	return id, nil
+}
+
+// listSubmissions returns up to 200 submissions with the given status,
+// oldest first for the pending queue and newest first otherwise.
+func listSubmissions(status string) ([]pageSubmission, error) {
+	order := "DESC"
+	if status == submissionPending {
+		order = "ASC"
+	}
+	rows, err := db.Query(`
+		SELECT s.id, s.url, COALESCE(s.reason, ''), s.status, COALESCE(submitter.username, ''), s.created_at,
+		       COALESCE(reviewer.username, ''), COALESCE(s.review_note, ''), s.reviewed_at, s.job_id
+		FROM page_submissions s
+		LEFT JOIN users submitter ON submitter.id = s.submitted_by
+		LEFT JOIN users reviewer ON reviewer.id = s.reviewed_by
+		WHERE s.status = $1
+		ORDER BY s.created_at `+order+`
+		LIMIT 200
+	`, status)
+	if err != nil {
+		return nil, fmt.Errorf("error listing submissions: %w", err)
+	}
+	defer rows.Close()
+
+	var submissions []pageSubmission
+	for rows.Next() {
+		var s pageSubmission
+		if err := rows.Scan(&s.ID, &s.URL, &s.Reason, &s.Status, &s.SubmittedBy, &s.CreatedAt,
+			&s.ReviewedBy, &s.ReviewNote, &s.ReviewedAt, &s.JobID); err != nil {
+			return nil, fmt.Errorf("error scanning submission: %w", err)
+		}
+		submissions = append(submissions, s)
+	}
+	return submissions, rows.Err()
+}
+
+// approveSubmission queues the submitted URL and starts the workers, which
+// crawl it and index it straight away. It returns the scrape job's ID.
+func approveSubmission(id, reviewerID int, note string) (int, error) {
+	var url string
+	err := db.QueryRow("SELECT url FROM page_submissions WHERE id = $1 AND status = 'pending'", id).Scan(&url)
+	if err == sql.ErrNoRows {
+		return 0, errSubmissionNotPending
+	}
+	if err != nil {
+		return 0, fmt.Errorf("error loading submission %d: %w", id, err)
+	}
+
+	jobID, err := requeueScrapeJob(jobKindURL, url)
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := db.Exec(`
+		UPDATE page_submissions
+		SET status = 'approved', reviewed_by = $2, review_note = $3, reviewed_at = NOW(), job_id = $4
+		WHERE id = $1 AND status = 'pending'
+	`, id, reviewerID, sql.NullString{String: note, Valid: note != ""}, jobID)
+	if err != nil {
+		return 0, fmt.Errorf("error approving submission %d: %w", id, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		// Reviewed by someone else in the meantime; the job is harmless.
+		return 0, errSubmissionNotPending
+	}
+
+	kickScrapeWorkers()
+	return jobID, nil
+}
+
+func rejectSubmission(id, reviewerID int, note string) error {
+	res, err := db.Exec(`
+		UPDATE page_submissions
+		SET status = 'rejected', reviewed_by = $2, review_note = $3, reviewed_at = NOW()
+		WHERE id = $1 AND status = 'pending'
+	`, id, reviewerID, sql.NullString{String: note, Valid: note != ""})
+	if err != nil {
+		return fmt.Errorf("error rejecting submission %d: %w", id, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errSubmissionNotPending
+	}
+	return nil
+}
+
+type submitPageData struct {
+	Title        string
+	UserLoggedIn bool
+	Message      string
+	Error        string
+	URL          string
+	Reason       string
+}
+
+func renderSubmitPage(w http.ResponseWriter, status int, data submitPageData) {
+	tmpl, err := template.ParseFiles(templatePath+"layout.html", templatePath+"submit.html")
+	if err != nil {
+		log.Printf("Error parsing templates: %v", err)
+		http.Error(w, "Error loading templates", http.StatusInternalServerError)
+		return
+	}
+	data.Title = "Suggest a page"
+	data.UserLoggedIn = true
+
+	w.WriteHeader(status)
+	if err := tmpl.ExecuteTemplate(w, "layout.html", data); err != nil {
+		log.Printf("Error executing template: %v", err)
+	}
+}
+
+// submitPageHandler shows the form for suggesting a URL.
+// GET /submit
+func submitPageHandler(w http.ResponseWriter, r *http.Request) {
+	if !userIsLoggedIn(r) {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	renderSubmitPage(w, http.StatusOK, submitPageData{})
+}
+
+// apiSubmitPageHandler stores a suggested URL from the submit form.
+// POST /api/submissions
+func apiSubmitPageHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := sessionUserID(r)
+	if !ok {
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid data", http.StatusBadRequest)
+		return
+	}
+
+	data := submitPageData{
+		URL:    strings.TrimSpace(r.FormValue("url")),
+		Reason: strings.TrimSpace(r.FormValue("reason")),
+	}
+	url, valid := validPageURL(data.URL)
+	if !valid {
+		data.Error = "Enter a full http:// or https:// address"
+		renderSubmitPage(w, http.StatusBadRequest, data)
+		return
+	}
+	if len([]rune(data.Reason)) > maxSubmissionReasonLength {
+		data.Error = fmt.Sprintf("The reason can be at most %d characters", maxSubmissionReasonLength)
+		renderSubmitPage(w, http.StatusBadRequest, data)
+		return
+	}
+
+	_, err := createSubmission(userID, url, data.Reason)
+	switch {
+	case errors.Is(err, errSubmissionRateLimited):
+		pageSubmissionsTotal.WithLabelValues("rate_limited").Inc()
+		data.Error = fmt.Sprintf("You can suggest at most %d pages a day. Please try again later.", submissionsPerDay)
+		renderSubmitPage(w, http.StatusTooManyRequests, data)
+	case errors.Is(err, errAlreadyIndexed), errors.Is(err, errAlreadySubmitted):
+		data.Error = "Thanks, but " + err.Error() + "."
+		renderSubmitPage(w, http.StatusConflict, data)
+	case err != nil:
+		log.Printf("%v", err)
+		data.Error = "Your suggestion could not be saved. Please try again."
+		renderSubmitPage(w, http.StatusInternalServerError, data)
+	default:
+		pageSubmissionsTotal.WithLabelValues(submissionPending).Inc()
+		renderSubmitPage(w, http.StatusCreated, submitPageData{
+			Message: "Thanks! Your suggestion will be reviewed by an administrator.",
+		})
+	}
+}
+
+// moderationHandler lists submissions for admins to review.
+// GET /admin/submissions?status=pending
+func moderationHandler(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "":
+		status = submissionPending
+	case submissionPending, submissionApproved, submissionRejected:
+	default:
+		http.Error(w, "Unknown status", http.StatusBadRequest)
+		return
+	}
+
+	submissions, err := listSubmissions(status)
+	if err != nil {
+		log.Printf("%v", err)
+		http.Error(w, "Error loading submissions", http.StatusInternalServerError)
+		return
+	}
+
+	tmpl, err := template.ParseFiles(templatePath+"layout.html", templatePath+"moderation.html")
+	if err != nil {
+		log.Printf("Error parsing templates: %v", err)
+		http.Error(w, "Error loading templates", http.StatusInternalServerError)
+		return
+	}
+	data := map[string]any{
+		"Title":        "Submissions",
+		"UserLoggedIn": true,
+		"Status":       status,
+		"Statuses":     []string{submissionPending, submissionApproved, submissionRejected},
+		"Submissions":  submissions,
+		"Message":      r.URL.Query().Get("message"),
+	}
+	if err := tmpl.ExecuteTemplate(w, "layout.html", data); err != nil {
+		log.Printf("Error executing template: %v", err)
+		http.Error(w, "Error rendering page", http.StatusInternalServerError)
+	}
+}
+
+// reviewSubmissionHandler approves or rejects a submission from the
+// moderation view and returns to it.
+// POST /api/admin/submissions/{id}/approve, POST /api/admin/submissions/{id}/reject
+func reviewSubmissionHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid submission ID", http.StatusBadRequest)
+		return
+	}
+	reviewerID, _ := sessionUserID(r)
+	note := strings.TrimSpace(r.FormValue("note"))
+
+	var status, message string
+	switch vars["action"] {
+	case "approve":
+		var jobID int
+		jobID, err = approveSubmission(id, reviewerID, note)
+		status, message = submissionApproved, fmt.Sprintf("Approved submission %d, queued as job %d", id, jobID)
+	case "reject":
+		err = rejectSubmission(id, reviewerID, note)
+		status, message = submissionRejected, fmt.Sprintf("Rejected submission %d", id)
+	default:
+		http.Error(w, "Unknown action", http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, errSubmissionNotPending) {
+		http.Error(w, "Submission not found or already reviewed", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("%v", err)
+		http.Error(w, "Error reviewing submission", http.StatusInternalServerError)
+		return
+	}
+
+	pageSubmissionsTotal.WithLabelValues(status).Inc()
+	http.Redirect(w, r, "/admin/submissions?message="+template.URLQueryEscaper(message), http.StatusSeeOther)
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApiSubmitPageHandler(t *testing.T) {
+	const pageURL = "https://example.com/guide"
+	testCases := []struct {
+		name       string
+		userID     int
+		form       url.Values
+		mockSetup  func(mock sqlmock.Sqlmock)
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "Not logged in",
+			form:       url.Values{"url": {pageURL}},
+			wantStatus: http.StatusSeeOther,
+		},
+		{
+			name:       "Invalid URL",
+			userID:     2,
+			form:       url.Values{"url": {"example.com"}},
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "Enter a full http:// or https:// address",
+		},
+		{
+			name:   "Rate limited",
+			userID: 2,
+			form:   url.Values{"url": {pageURL}},
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec("SELECT pg_advisory_xact_lock").WithArgs(2).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectQuery("SELECT COUNT").WithArgs(2, sqlmock.AnyArg()).
+					WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(submissionsPerDay))
+				mock.ExpectRollback()
+			},
+			wantStatus: http.StatusTooManyRequests,
+			wantBody:   "at most 10 pages a day",
+		},
+		{
+			name:   "Already indexed",
+			userID: 2,
+			form:   url.Values{"url": {pageURL}},
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec("SELECT pg_advisory_xact_lock").WithArgs(2).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectQuery("SELECT COUNT").WithArgs(2, sqlmock.AnyArg()).
+					WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+				mock.ExpectQuery("SELECT EXISTS").WithArgs(pageURL).
+					WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+				mock.ExpectRollback()
+			},
+			wantStatus: http.StatusConflict,
+			wantBody:   "already indexed",
+		},
+		{
+			name:   "Already waiting for review",
+			userID: 2,
+			form:   url.Values{"url": {pageURL}},
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec("SELECT pg_advisory_xact_lock").WithArgs(2).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectQuery("SELECT COUNT").WithArgs(2, sqlmock.AnyArg()).
+					WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+				mock.ExpectQuery("SELECT EXISTS").WithArgs(pageURL).
+					WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+				mock.ExpectQuery("INSERT INTO page_submissions").WillReturnError(sql.ErrNoRows)
+				mock.ExpectRollback()
+			},
+			wantStatus: http.StatusConflict,
+			wantBody:   "already waiting for review",
+		},
+		{
+			name:   "Submitted",
+			userID: 2,
+			form:   url.Values{"url": {pageURL + "#intro"}, "reason": {"Great docs"}},
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectExec("SELECT pg_advisory_xact_lock").WithArgs(2).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectQuery("SELECT COUNT").WithArgs(2, sqlmock.AnyArg()).
+					WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+				mock.ExpectQuery("SELECT EXISTS").WithArgs(pageURL).
+					WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+				mock.ExpectQuery("INSERT INTO page_submissions").
+					WithArgs(pageURL, sql.NullString{String: "Great docs", Valid: true}, 2).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+				mock.ExpectCommit()
+			},
+			wantStatus: http.StatusCreated,
+			wantBody:   "will be reviewed",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockDB, mock := setupMockDB()
+			defer mockDB.Close()
+			if tc.mockSetup != nil {
+				tc.mockSetup(mock)
+			}
+
+			req := loggedInRequest(t, "POST", "/api/submissions", tc.form.Encode(), tc.userID)
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+			apiSubmitPageHandler(w, req)
+
+			assert.Equal(t, tc.wantStatus, w.Code)
+			assert.Contains(t, w.Body.String(), tc.wantBody)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestReviewSubmissionHandler(t *testing.T) {
+	const pageURL = "https://example.com/guide"
+	testCases := []struct {
+		name       string
+		action     string
+		mockSetup  func(mock sqlmock.Sqlmock)
+		wantStatus int
+	}{
+		{
+			name:   "Approve queues a crawl",
+			action: "approve",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT url FROM page_submissions").WithArgs(4).
+					WillReturnRows(sqlmock.NewRows([]string{"url"}).AddRow(pageURL))
+				mock.ExpectQuery("INSERT INTO scrape_jobs").WithArgs(jobKindURL, pageURL).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(40))
+				mock.ExpectExec("UPDATE page_submissions").
+					WithArgs(4, 1, sql.NullString{String: "looks good", Valid: true}, 40).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+			wantStatus: http.StatusSeeOther,
+		},
+		{
+			name:   "Reject",
+			action: "reject",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec("UPDATE page_submissions").
+					WithArgs(4, 1, sql.NullString{String: "looks good", Valid: true}).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+			wantStatus: http.StatusSeeOther,
+		},
+		{
+			name:   "Already reviewed",
+			action: "approve",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("SELECT url FROM page_submissions").WithArgs(4).WillReturnError(sql.ErrNoRows)
+			},
+			wantStatus: http.StatusConflict,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			holdScrapeWorkers(t)
+			mockDB, mock := setupMockDB()
+			defer mockDB.Close()
+			expectAdmin(mock)
+			tc.mockSetup(mock)
+
+			r := mux.NewRouter()
+			r.Handle("/api/admin/submissions/{id:[0-9]+}/{action:approve|reject}", requireAdmin(http.HandlerFunc(reviewSubmissionHandler)))
+			req := loggedInRequest(t, "POST", "/api/admin/submissions/4/"+tc.action, "note=looks+good", 1)
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, tc.wantStatus, w.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestModerationHandler(t *testing.T) {
+	mockDB, mock := setupMockDB()
+	defer mockDB.Close()
+
+	mock.ExpectQuery("FROM page_submissions s").WithArgs(submissionPending).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "url", "reason", "status", "submitter", "created_at",
+			"reviewer", "review_note", "reviewed_at", "job_id"}).
+			AddRow(4, "https://example.com/guide", "Great docs", submissionPending, "alice", time.Now(), "", "", nil, nil))
+
+	w := httptest.NewRecorder()
+	moderationHandler(w, httptest.NewRequest("GET", "/admin/submissions", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "https://example.com/guide")
+	assert.Contains(t, w.Body.String(), `action="/api/admin/submissions/4/approve"`)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	w = httptest.NewRecorder()
+	moderationHandler(w, httptest.NewRequest("GET", "/admin/submissions?status=bogus", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -0,0 +1,523 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+// Admins curate synonym and rewrite rules so that e.g. "kbh" finds
+// København. Rules live in synonym_rules and are cached in memory. They are
+// copied into an Elasticsearch synonyms set used by the search analyzers of
+// the pages index, and applied the same way by the database search used when
+// Elasticsearch is not available.
+
+const (
+	synonymFilterName   = "search_synonyms"
+	synonymAnalyzerName = "search_synonyms"
+	synonymSetName      = "pages-synonyms"
+
+	maxSynonymRuleTerms = 50
+	// maxQueryVariants bounds how many rewritten queries the database search
+	// tries for one search.
+	maxQueryVariants           = 20
+	defaultSynonymHistoryLimit = 50
+)
+
+var errSynonymRuleNotFound = errors.New("synonym rule not found")
+
+// synonymRule makes Terms equivalent to each other or, when Replacement is
+// set, rewrites each of Terms to Replacement.
+type synonymRule struct {
+	ID          int       `json:"id"`
+	Terms       []string  `json:"terms"`
+	Replacement []string  `json:"replacement,omitempty"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// String is the rule in the Solr synonym format read by synonym_graph, e.g.
+// "kbh, københavn" or "kbh => københavn".
+func (r synonymRule) String() string {
+	s := strings.Join(r.Terms, ", ")
+	if len(r.Replacement) > 0 {
+		s += " => " + strings.Join(r.Replacement, ", ")
+	}
+	return s
+}
+
+// synonymRuleChange is one entry of a rule's audit history.
+type synonymRuleChange struct {
+	ID        int64           `json:"id"`
+	RuleID    int             `json:"rule_id"`
+	Action    string          `json:"action"`
+	Before    json.RawMessage `json:"before,omitempty"`
+	After     json.RawMessage `json:"after,omitempty"`
+	ChangedBy string          `json:"changed_by"`
+	ChangedAt time.Time       `json:"changed_at"`
+}
+
+var (
+	synonymRulesMu     sync.RWMutex
+	cachedSynonymRules []synonymRule
+)
+
+// currentSynonymRules returns the rules as of the last reload.
+func currentSynonymRules() []synonymRule {
+	synonymRulesMu.RLock()
+	defer synonymRulesMu.RUnlock()
+	return cachedSynonymRules
+}
+
+// reloadSynonymRules replaces the cached rules with those in the database.
+func reloadSynonymRules() error {
+	rules, err := loadSynonymRules()
+	if err != nil {
+		return err
+	}
+	synonymRulesMu.Lock()
+	cachedSynonymRules = rules
+	synonymRulesMu.Unlock()
+	return nil
+}
+
+func loadSynonymRules() ([]synonymRule, error) {
+	rows, err := db.Query("SELECT id, terms, replacement, updated_at FROM synonym_rules ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("error loading synonym rules: %w", err)
+	}
+	defer rows.Close()
+
+	rules := []synonymRule{}
+	for rows.Next() {
+		var rule synonymRule
+		if err := rows.Scan(&rule.ID, pq.Array(&rule.Terms), pq.Array(&rule.Replacement), &rule.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("error scanning synonym rule: %w", err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+// normalizeSynonymRule normalizes the terms of a rule the way search terms
+// are normalized and checks that synonym_graph can parse them.
+func normalizeSynonymRule(rule synonymRule) (synonymRule, error) {
+	terms, err := normalizeSynonymTerms(rule.Terms)
+	if err != nil {
+		return rule, err
+	}
+	replacement, err := normalizeSynonymTerms(rule.Replacement)
+	if err != nil {
+		return rule, err
+	}
+	switch {
+	case len(terms) == 0:
+		return rule, errors.New("terms is required")
+	case len(replacement) == 0 && len(terms) < 2:
+		return rule, errors.New("a synonym rule needs at least two terms, or a replacement")
+	case len(terms)+len(replacement) > maxSynonymRuleTerms:
+		return rule, fmt.Errorf("a rule can have at most %d terms", maxSynonymRuleTerms)
+	}
+	rule.Terms, rule.Replacement = terms, replacement
+	return rule, nil
+}
+
+func normalizeSynonymTerms(raw []string) ([]string, error) {
+	var terms []string
+	seen := make(map[string]bool)
+	for _, r := range raw {
+		term := normalizeSearchTerm(r)
+		if term == "" || strings.ContainsAny(term, ",\\") || strings.Contains(term, "=>") {
+			return nil, fmt.Errorf("invalid term: %q", r)
+		}
+		if !seen[term] {
+			seen[term] = true
+			terms = append(terms, term)
+		}
+	}
+	return terms, nil
+}
+
+// recordSynonymChange adds an entry to synonym_rule_history. before and
+// after are nil for creations and deletions respectively.
+func recordSynonymChange(tx *sql.Tx, ruleID int, action string, before, after *synonymRule, userID int) error {
+	encode := func(rule *synonymRule) (sql.NullString, error) {
+		if rule == nil {
+			return sql.NullString{}, nil
+		}
+		data, err := json.Marshal(rule)
+		return sql.NullString{String: string(data), Valid: true}, err
+	}
+	beforeJSON, err := encode(before)
+	if err != nil {
+		return err
+	}
+	afterJSON, err := encode(after)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO synonym_rule_history (rule_id, action, before, after, changed_by)
+		VALUES ($1, $2, $3, $4, $5)
+	`, ruleID, action, beforeJSON, afterJSON, sql.NullInt64{Int64: int64(userID), Valid: userID != 0})
+	if err != nil {
+		return fmt.Errorf("error recording synonym rule change: %w", err)
+	}
+	return nil
+}
+
+func createSynonymRule(rule synonymRule, userID int) (synonymRule, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return rule, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(`
+		INSERT INTO synonym_rules (terms, replacement, created_by)
+		VALUES ($1, $2, $3)
+		RETURNING id, updated_at
+	`, pq.Array(rule.Terms), pq.Array(rule.Replacement), sql.NullInt64{Int64: int64(userID), Valid: userID != 0}).
+		Scan(&rule.ID, &rule.UpdatedAt)
+	if err != nil {
+		return rule, fmt.Errorf("error saving synonym rule: %w", err)
+	}
+	if err := recordSynonymChange(tx, rule.ID, "create", nil, &rule, userID); err != nil {
+		return rule, err
+	}
+	if err := tx.Commit(); err != nil {
+		return rule, fmt.Errorf("error committing synonym rule: %w", err)
+	}
+	return rule, nil
+}
+
+func updateSynonymRule(rule synonymRule, userID int) (synonymRule, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return rule, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	before := synonymRule{ID: rule.ID}
+	err = tx.QueryRow("SELECT terms, replacement, updated_at FROM synonym_rules WHERE id = $1 FOR UPDATE", rule.ID).
+		Scan(pq.Array(&before.Terms), pq.Array(&before.Replacement), &before.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return rule, errSynonymRuleNotFound
+	}
+	if err != nil {
+		return rule, fmt.Errorf("error loading synonym rule %d: %w", rule.ID, err)
+	}
+
+	err = tx.QueryRow(`
+		UPDATE synonym_rules SET terms = $2, replacement = $3, updated_at = NOW()
+		WHERE id = $1
+		RETURNING updated_at
+	`, rule.ID, pq.Array(rule.Terms), pq.Array(rule.Replacement)).Scan(&rule.UpdatedAt)
+	if err != nil {
+		return rule, fmt.Errorf("error updating synonym rule %d: %w", rule.ID, err)
+	}
+	if err := recordSynonymChange(tx, rule.ID, "update", &before, &rule, userID); err != nil {
+		return rule, err
+	}
+	if err := tx.Commit(); err != nil {
+		return rule, fmt.Errorf("error committing synonym rule: %w", err)
+	}
+	return rule, nil
+}
+
+func deleteSynonymRule(id, userID int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	before := synonymRule{ID: id}
+	err = tx.QueryRow("DELETE FROM synonym_rules WHERE id = $1 RETURNING terms, replacement, updated_at", id).
+		Scan(pq.Array(&before.Terms), pq.Array(&before.Replacement), &before.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return errSynonymRuleNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("error deleting synonym rule %d: %w", id, err)
+	}
+	if err := recordSynonymChange(tx, id, "delete", &before, nil, userID); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing synonym rule deletion: %w", err)
+	}
+	return nil
+}
+
+func loadSynonymHistory(limit int) ([]synonymRuleChange, error) {
+	rows, err := db.Query(`
+		SELECT h.id, h.rule_id, h.action, h.before, h.after, COALESCE(u.username, ''), h.changed_at
+		FROM synonym_rule_history h LEFT JOIN users u ON u.id = h.changed_by
+		ORDER BY h.id DESC LIMIT $1
+	`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error loading synonym rule history: %w", err)
+	}
+	defer rows.Close()
+
+	history := []synonymRuleChange{}
+	for rows.Next() {
+		var c synonymRuleChange
+		var before, after []byte
+		if err := rows.Scan(&c.ID, &c.RuleID, &c.Action, &before, &after, &c.ChangedBy, &c.ChangedAt); err != nil {
+			return nil, fmt.Errorf("error scanning synonym rule history: %w", err)
+		}
+		c.Before, c.After = before, after
+		history = append(history, c)
+	}
+	return history, rows.Err()
+}
+
+// synonymFilter applies the rules in the synonyms set. It is updateable, so
+// Elasticsearch reloads the search analyzers that use it whenever the set
+// changes, without closing the index.
+func synonymFilter() map[string]any {
+	return map[string]any{"type": "synonym_graph", "synonyms_set": synonymSetName, "updateable": true}
+}
+
+// putSynonymSet replaces the synonyms set with rules. The set must exist
+// before the pages index is created.
+func putSynonymSet(rules []synonymRule) error {
+	if esClient == nil {
+		return nil
+	}
+	set := make([]map[string]string, 0, len(rules))
+	for _, rule := range rules {
+		set = append(set, map[string]string{"id": strconv.Itoa(rule.ID), "synonyms": rule.String()})
+	}
+	body, err := json.Marshal(map[string]any{"synonyms_set": set})
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	res, err := esClient.SynonymsPutSynonym(synonymSetName, bytes.NewReader(body),
+		esClient.SynonymsPutSynonym.WithContext(ctx),
+	)
+	return esResponseError("updating synonyms", res, err)
+}
+
+func esResponseError(action string, res *esapi.Response, err error) error {
+	if err != nil {
+		return fmt.Errorf("error %s: %w", action, err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return fmt.Errorf("error response when %s: %s", action, res.String())
+	}
+	return nil
+}
+
+// applySynonymRules reloads the rules after a change and pushes them to
+// Elasticsearch. The change is already saved, so failures are only logged.
+func applySynonymRules() {
+	if err := reloadSynonymRules(); err != nil {
+		log.Printf("Error reloading synonym rules: %v", err)
+		return
+	}
+	if err := putSynonymSet(currentSynonymRules()); err != nil {
+		log.Printf("Error updating synonyms in Elasticsearch: %v", err)
+	}
+}
+
+// expandQuery returns the queries the database search should look for, in
+// line with what synonym_graph does in Elasticsearch: terms of a synonym rule
+// are swapped for each other, and terms of a rewrite rule are replaced. When
+// no rule applies, the query is returned as it is.
+func expandQuery(query string, rules []synonymRule) []string {
+	normalized := normalizeSearchTerm(query)
+	if normalized == "" || len(rules) == 0 {
+		return []string{query}
+	}
+
+	variants := []string{normalized}
+	changed := false
+	for _, rule := range rules {
+		targets := rule.Replacement
+		if len(targets) == 0 {
+			targets = rule.Terms
+		}
+		var next []string
+		for _, v := range variants {
+			term, ok := firstPhrase(v, rule.Terms)
+			if !ok {
+				next = append(next, v)
+				continue
+			}
+			changed = true
+			for _, target := range targets {
+				next = append(next, replacePhrase(v, term, target))
+			}
+		}
+		variants = dedupeTerms(next)
+		if len(variants) > maxQueryVariants {
+			variants = variants[:maxQueryVariants]
+		}
+	}
+	if !changed {
+		return []string{query}
+	}
+	return variants
+}
+
+// firstPhrase returns the first of terms that appears in query as whole
+// words.
+func firstPhrase(query string, terms []string) (string, bool) {
+	padded := " " + query + " "
+	for _, term := range terms {
+		if strings.Contains(padded, " "+term+" ") {
+			return term, true
+		}
+	}
+	return "", false
+}
+
+func replacePhrase(query, term, replacement string) string {
+	padded := strings.ReplaceAll(" "+query+" ", " "+term+" ", " "+replacement+" ")
+	return strings.TrimSpace(padded)
+}
+
+type synonymRuleRequest struct {
+	Terms       []string `json:"terms"`
+	Replacement []string `json:"replacement"`
+}
+
+// decodeSynonymRule reads and validates a rule from a request body, writing
+// an error response when it is not valid.
+func decodeSynonymRule(w http.ResponseWriter, r *http.Request) (synonymRule, bool) {
+	var req synonymRuleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return synonymRule{}, false
+	}
+	rule, err := normalizeSynonymRule(synonymRule{Terms: req.Terms, Replacement: req.Replacement})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return synonymRule{}, false
+	}
+	return rule, true
+}
+
+// listSynonymsHandler returns all synonym rules.
+// GET /api/admin/synonyms
+func listSynonymsHandler(w http.ResponseWriter, r *http.Request) {
+	rules, err := loadSynonymRules()
+	if err != nil {
+		log.Printf("%v", err)
+		http.Error(w, "Error loading synonym rules", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"rules": rules})
+}
+
+// createSynonymHandler adds a rule.
+// POST /api/admin/synonyms {"terms": ["kbh", "københavn"]} or
+// {"terms": ["kbh"], "replacement": ["københavn"]}
+func createSynonymHandler(w http.ResponseWriter, r *http.Request) {
+	rule, ok := decodeSynonymRule(w, r)
+	if !ok {
+		return
+	}
+	userID, _ := sessionUserID(r)
+	rule, err := createSynonymRule(rule, userID)
+	if err != nil {
+		log.Printf("%v", err)
+		http.Error(w, "Error saving synonym rule", http.StatusInternalServerError)
+		return
+	}
+	applySynonymRules()
+	writeJSON(w, http.StatusCreated, rule)
+}
+
+// updateSynonymHandler replaces the terms of a rule.
+// PUT /api/admin/synonyms/{id}
+func updateSynonymHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid rule ID", http.StatusBadRequest)
+		return
+	}
+	rule, ok := decodeSynonymRule(w, r)
+	if !ok {
+		return
+	}
+	rule.ID = id
+	userID, _ := sessionUserID(r)
+	rule, err = updateSynonymRule(rule, userID)
+	if errors.Is(err, errSynonymRuleNotFound) {
+		http.Error(w, "Synonym rule not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("%v", err)
+		http.Error(w, "Error saving synonym rule", http.StatusInternalServerError)
+		return
+	}
+	applySynonymRules()
+	writeJSON(w, http.StatusOK, rule)
+}
+
+// deleteSynonymHandler removes a rule.
+// DELETE /api/admin/synonyms/{id}
+func deleteSynonymHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid rule ID", http.StatusBadRequest)
+		return
+	}
+	userID, _ := sessionUserID(r)
+	err = deleteSynonymRule(id, userID)
+	if errors.Is(err, errSynonymRuleNotFound) {
+		http.Error(w, "Synonym rule not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("%v", err)
+		http.Error(w, "Error deleting synonym rule", http.StatusInternalServerError)
+		return
+	}
+	applySynonymRules()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// synonymHistoryHandler returns the latest changes to the rules, newest
+// first.
+// GET /api/admin/synonyms/history?limit=50
+func synonymHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	limit := defaultSynonymHistoryLimit
+	if raw := r.URL.Query().Get("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxAnalyticsLimit {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxAnalyticsLimit), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	history, err := loadSynonymHistory(limit)
+	if err != nil {
+		log.Printf("%v", err)
+		http.Error(w, "Error loading synonym rule history", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"history": history})
+}
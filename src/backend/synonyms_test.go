@@ -0,0 +1,201 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeSynonymRule(t *testing.T) {
+	testCases := []struct {
+		name    string
+		rule    synonymRule
+		want    synonymRule
+		wantErr bool
+	}{
+		{
+			name: "Synonyms are normalized and de-duplicated",
+			rule: synonymRule{Terms: []string{" KBH ", "København", "kbh"}},
+			want: synonymRule{Terms: []string{"kbh", "københavn"}},
+		},
+		{
+			name: "Rewrite",
+			rule: synonymRule{Terms: []string{"kbh"}, Replacement: []string{"København"}},
+			want: synonymRule{Terms: []string{"kbh"}, Replacement: []string{"københavn"}},
+		},
+		{name: "A single synonym", rule: synonymRule{Terms: []string{"kbh"}}, wantErr: true},
+		{name: "No terms", rule: synonymRule{Replacement: []string{"københavn"}}, wantErr: true},
+		{name: "Comma in a term", rule: synonymRule{Terms: []string{"kbh, dk", "københavn"}}, wantErr: true},
+		{name: "Arrow in a term", rule: synonymRule{Terms: []string{"kbh => x", "københavn"}}, wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rule, err := normalizeSynonymRule(tc.rule)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.want, rule)
+		})
+	}
+}
+
+func TestExpandQuery(t *testing.T) {
+	rules := []synonymRule{
+		{Terms: []string{"kbh", "københavn", "copenhagen"}},
+		{Terms: []string{"nyc"}, Replacement: []string{"new york"}},
+	}
+
+	testCases := []struct {
+		query string
+		want  []string
+	}{
+		{"Go Lang", []string{"Go Lang"}},
+		{"KBH lufthavn", []string{"kbh lufthavn", "københavn lufthavn", "copenhagen lufthavn"}},
+		{"weather nyc", []string{"weather new york"}},
+		{"kbhx", []string{"kbhx"}},
+		{"kbh nyc", []string{"kbh new york", "københavn new york", "copenhagen new york"}},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.want, expandQuery(tc.query, rules), tc.query)
+	}
+	assert.Equal(t, []string{"kbh"}, expandQuery("kbh", nil))
+}
+
+func TestPagesIndexSynonyms(t *testing.T) {
+	rules := []synonymRule{
+		{Terms: []string{"kbh", "københavn"}},
+		{Terms: []string{"kbh", "kbhvn"}, Replacement: []string{"københavn"}},
+	}
+	assert.Equal(t, "kbh, kbhvn => københavn", rules[1].String())
+
+	var index struct {
+		Settings struct {
+			Analysis struct {
+				Filter map[string]struct {
+					Type        string `json:"type"`
+					SynonymsSet string `json:"synonyms_set"`
+					Updateable  bool   `json:"updateable"`
+				} `json:"filter"`
+				Analyzer map[string]struct {
+					Filter []string `json:"filter"`
+				} `json:"analyzer"`
+			} `json:"analysis"`
+		} `json:"settings"`
+		Mappings struct {
+			Properties map[string]map[string]string `json:"properties"`
+		} `json:"mappings"`
+	}
+	mappings, err := pagesIndexMappings()
+	assert.NoError(t, err)
+	assert.NoError(t, json.Unmarshal([]byte(mappings), &index))
+
+	filter := index.Settings.Analysis.Filter[synonymFilterName]
+	assert.Equal(t, "synonym_graph", filter.Type)
+	assert.Equal(t, synonymSetName, filter.SynonymsSet)
+	assert.True(t, filter.Updateable, "Rule changes should reload the analyzers instead of closing the index")
+	assert.Equal(t, synonymAnalyzerName, index.Mappings.Properties["title"]["search_analyzer"])
+
+	// Language-routed fields apply the synonyms too, before stemming.
+	contentDa := index.Mappings.Properties["content_da"]
+	assert.Equal(t, "content_da", contentDa["analyzer"])
+	assert.Equal(t, []string{"lowercase", synonymFilterName, "da_stop", "da_stemmer"},
+		index.Settings.Analysis.Analyzer[contentDa["search_analyzer"]].Filter)
+	assert.NotContains(t, index.Settings.Analysis.Analyzer["content_da"].Filter, synonymFilterName)
+}
+
+func TestCreateSynonymHandler(t *testing.T) {
+	previous := currentSynonymRules()
+	defer func() { cachedSynonymRules = previous }()
+
+	mockDB, mock := setupMockDB()
+	defer mockDB.Close()
+	expectAdmin(mock)
+	now := time.Now()
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO synonym_rules").
+		WithArgs(pq.Array([]string{"kbh"}), pq.Array([]string{"københavn"}), sql.NullInt64{Int64: 1, Valid: true}).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "updated_at"}).AddRow(7, now))
+	mock.ExpectExec("INSERT INTO synonym_rule_history").
+		WithArgs(7, "create", sql.NullString{}, sqlmock.AnyArg(), sql.NullInt64{Int64: 1, Valid: true}).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery("SELECT id, terms, replacement, updated_at FROM synonym_rules").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "terms", "replacement", "updated_at"}).
+			AddRow(7, "{kbh}", "{københavn}", now))
+
+	r := mux.NewRouter()
+	r.Handle("/api/admin/synonyms", requireAdmin(http.HandlerFunc(createSynonymHandler)))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, loggedInRequest(t, "POST", "/api/admin/synonyms", `{"terms":["KBH"],"replacement":["København"]}`, 1))
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Contains(t, w.Body.String(), `"id":7,"terms":["kbh"],"replacement":["københavn"]`)
+	assert.Equal(t, []string{"københavn"}, expandQuery("kbh", currentSynonymRules()),
+		"New rules apply to searches right away")
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	w = httptest.NewRecorder()
+	expectAdmin(mock)
+	r.ServeHTTP(w, loggedInRequest(t, "POST", "/api/admin/synonyms", `{"terms":["kbh"]}`, 1))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestDeleteSynonymHandler(t *testing.T) {
+	mockDB, mock := setupMockDB()
+	defer mockDB.Close()
+	r := mux.NewRouter()
+	r.Handle("/api/admin/synonyms/{id:[0-9]+}", requireAdmin(http.HandlerFunc(deleteSynonymHandler)))
+
+	expectAdmin(mock)
+	mock.ExpectBegin()
+	mock.ExpectQuery("DELETE FROM synonym_rules").WithArgs(9).WillReturnError(sql.ErrNoRows)
+	mock.ExpectRollback()
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, loggedInRequest(t, "DELETE", "/api/admin/synonyms/9", "", 1))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	expectAdmin(mock)
+	mock.ExpectBegin()
+	mock.ExpectQuery("DELETE FROM synonym_rules").WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"terms", "replacement", "updated_at"}).AddRow("{kbh,københavn}", nil, time.Now()))
+	mock.ExpectExec("INSERT INTO synonym_rule_history").
+		WithArgs(7, "delete", sqlmock.AnyArg(), sql.NullString{}, sql.NullInt64{Int64: 1, Valid: true}).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery("FROM synonym_rules").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "terms", "replacement", "updated_at"}))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, loggedInRequest(t, "DELETE", "/api/admin/synonyms/7", "", 1))
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSynonymHistoryHandler(t *testing.T) {
+	mockDB, mock := setupMockDB()
+	defer mockDB.Close()
+
+	mock.ExpectQuery("FROM synonym_rule_history").WithArgs(defaultSynonymHistoryLimit).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "rule_id", "action", "before", "after", "username", "changed_at"}).
+			AddRow(2, 7, "delete", `{"id":7,"terms":["kbh","københavn"]}`, nil, "admin", time.Now()))
+
+	w := httptest.NewRecorder()
+	synonymHistoryHandler(w, httptest.NewRequest("GET", "/api/admin/synonyms/history", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	body := w.Body.String()
+	assert.Contains(t, body, `"action":"delete","before":{"id":7,"terms":["kbh","københavn"]},"changed_by":"admin"`)
+	assert.False(t, strings.Contains(body, `"after"`), "A deletion has no after state")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// Retention for page_versions. A page keeps at most pageVersionsMax earlier
+// versions, none older than pageVersionsMaxAge; zero disables a limit.
+// Overridden from the environment in config.go.
+var (
+	pageVersionsMax    = 20
+	pageVersionsMaxAge = 365 * 24 * time.Hour
+)
+
+var errInvalidVersion = errors.New("invalid version")
+
+// Limits for pageDiffHandler. Diffing is quadratic in the number of lines in
+// the worst case, so larger versions are refused rather than diffed, and
+// recent diffs are cached as the endpoint needs no login.
+const (
+	maxDiffLines     = 5000
+	maxDiffBytes     = 1 << 20
+	diffCacheEntries = 256
+)
+
+// diffCache holds rendered diffs keyed by the refs, content hashes and
+// capture times of both versions. When full, an arbitrary entry is evicted.
+type diffCache struct {
+	mu      sync.Mutex
+	entries map[string]string
+}
+
+var pageDiffs = &diffCache{entries: make(map[string]string)}
+
+func (c *diffCache) get(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	diff, ok := c.entries[key]
+	return diff, ok
+}
+
+func (c *diffCache) put(key, diff string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= diffCacheEntries {
+		for k := range c.entries {
+			delete(c.entries, k)
+			break
+		}
+	}
+	c.entries[key] = diff
+}
+
+// pageVersion is an earlier version of a page, as it was before being
+// overwritten by a newer fetch.
+type pageVersion struct {
+	ID          int       `json:"id,omitempty"`
+	Title       string    `json:"title"`
+	ContentHash string    `json:"content_hash"`
+	RevisionID  int64     `json:"revision_id,omitempty"`
+	Size        int       `json:"size"`
+	CapturedAt  time.Time `json:"captured_at"`
+	Content     string    `json:"-"`
+}
+
+func contentHash(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// archivePageVersion copies the stored version of the page at url into
+// page_versions before it is replaced by newContent. Nothing is archived for
+// new pages or when the content has not changed. Reports whether a version
+// was archived.
+func archivePageVersion(tx *sql.Tx, url, newContent string) (bool, error) {
+	var title, content string
+	var revisionID sql.NullInt64
+	var lastUpdated sql.NullTime
+	err := tx.QueryRow(`
+		SELECT title, content, revision_id, last_updated FROM pages WHERE url = $1 FOR UPDATE
+	`, url).Scan(&title, &content, &revisionID, &lastUpdated)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("error loading current version of %s: %v", url, err)
+	}
+	if content == newContent {
+		return false, nil
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO page_versions (url, title, content, content_hash, revision_id, captured_at)
+		VALUES ($1, $2, $3, $4, $5, COALESCE($6, NOW()))
+	`, url, title, content, contentHash(content), revisionID, lastUpdated)
+	if err != nil {
+		return false, fmt.Errorf("error archiving version of %s: %v", url, err)
+	}
+	return true, nil
+}
+
+// prunePageVersions enforces the retention limits for one page.
+func prunePageVersions(url string) error {
+	_, err := db.Exec(`
+		DELETE FROM page_versions
+		WHERE url = $1
+		  AND (captured_at < $2
+		       OR id NOT IN (
+		           SELECT id FROM page_versions WHERE url = $1
+		           ORDER BY captured_at DESC, id DESC
+		           LIMIT $3))
+	`, url, versionCutoff(),
+		sql.NullInt64{Int64: int64(pageVersionsMax), Valid: pageVersionsMax > 0})
+	return err
+}
+
+// purgeOldPageVersions drops versions past the age limit for all pages,
+// including pages that have not changed since.
+func purgeOldPageVersions() {
+	cutoff := versionCutoff()
+	if !cutoff.Valid {
+		return
+	}
+	res, err := db.Exec("DELETE FROM page_versions WHERE captured_at < $1", cutoff)
+	if err != nil {
+		log.Printf("Error purging old page versions: %v", err)
+		return
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		log.Printf("Purged %d page versions older than %s", n, pageVersionsMaxAge)
+	}
+}
+
+func versionCutoff() sql.NullTime {
+	return sql.NullTime{Time: time.Now().Add(-pageVersionsMaxAge), Valid: pageVersionsMaxAge > 0}
+}
+
+func listPageVersions(url string) ([]pageVersion, error) {
+	rows, err := db.Query(`
+		SELECT id, title, content_hash, COALESCE(revision_id, 0), LENGTH(content), captured_at
+		FROM page_versions WHERE url = $1
+		ORDER BY captured_at DESC, id DESC
+	`, url)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := []pageVersion{}
+	for rows.Next() {
+		var v pageVersion
+		if err := rows.Scan(&v.ID, &v.Title, &v.ContentHash, &v.RevisionID, &v.Size, &v.CapturedAt); err != nil {
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+	return versions, rows.Err()
+}
+
+// loadPageVersion returns an archived version of a page, or its current
+// version when ref is "current".
+func loadPageVersion(url, ref string) (*pageVersion, error) {
+	var v pageVersion
+	if ref == "current" {
+		err := db.QueryRow(`
+			SELECT title, content, COALESCE(revision_id, 0), COALESCE(last_updated, NOW())
+			FROM pages WHERE url = $1
+		`, url).Scan(&v.Title, &v.Content, &v.RevisionID, &v.CapturedAt)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		id, err := strconv.Atoi(ref)
+		if err != nil {
+			return nil, fmt.Errorf("%w %q", errInvalidVersion, ref)
+		}
+		err = db.QueryRow(`
+			SELECT id, title, content, COALESCE(revision_id, 0), captured_at
+			FROM page_versions WHERE url = $1 AND id = $2
+		`, url, id).Scan(&v.ID, &v.Title, &v.Content, &v.RevisionID, &v.CapturedAt)
+		if err != nil {
+			return nil, err
+		}
+	}
+	v.ContentHash = contentHash(v.Content)
+	v.Size = len(v.Content)
+	return &v, nil
+}
+
+// diffPageVersions renders a unified diff between two versions of a page.
+func diffPageVersions(url string, from, to *pageVersion, fromRef, toRef string) (string, error) {
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        diffLines(from.Content),
+		B:        diffLines(to.Content),
+		FromFile: fmt.Sprintf("%s@%s", url, fromRef),
+		FromDate: from.CapturedAt.Format(time.RFC3339),
+		ToFile:   fmt.Sprintf("%s@%s", url, toRef),
+		ToDate:   to.CapturedAt.Format(time.RFC3339),
+		Context:  3,
+	})
+}
+
+// diffLines splits text into newline-terminated lines. SplitLines already
+// terminates the last line, so a trailing newline would add an empty one.
+func diffLines(text string) []string {
+	return difflib.SplitLines(strings.TrimSuffix(text, "\n"))
+}
+
+// pageVersionsHandler lists the archived versions of a page, newest first.
+// GET /api/pages/versions?url=...
+func pageVersionsHandler(w http.ResponseWriter, r *http.Request) {
+	url := r.URL.Query().Get("url")
+	if url == "" {
+		http.Error(w, "url is required", http.StatusBadRequest)
+		return
+	}
+
+	current, err := loadPageVersion(url, "current")
+	if err == sql.ErrNoRows {
+		http.Error(w, "Page not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error loading page %s: %v", url, err)
+		http.Error(w, "Error loading page", http.StatusInternalServerError)
+		return
+	}
+
+	versions, err := listPageVersions(url)
+	if err != nil {
+		log.Printf("Error listing versions of %s: %v", url, err)
+		http.Error(w, "Error loading page versions", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"url":      url,
+		"current":  current,
+		"versions": versions,
+	})
+}
+
+// pageDiffHandler returns a unified diff between two versions of a page.
+// from and to are version IDs from pageVersionsHandler or "current"; to
+// defaults to the current version. Versions over maxDiffLines lines or
+// maxDiffBytes bytes are refused with 413.
+// GET /api/pages/diff?url=...&from=12&to=current
+func pageDiffHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	url, fromRef, toRef := query.Get("url"), query.Get("from"), query.Get("to")
+	if toRef == "" {
+		toRef = "current"
+	}
+	if url == "" || fromRef == "" {
+		http.Error(w, "url and from are required", http.StatusBadRequest)
+		return
+	}
+
+	var versions [2]*pageVersion
+	for i, ref := range []string{fromRef, toRef} {
+		v, err := loadPageVersion(url, ref)
+		switch {
+		case errors.Is(err, errInvalidVersion):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case err == sql.ErrNoRows:
+			http.Error(w, "Version not found: "+ref, http.StatusNotFound)
+			return
+		case err != nil:
+			log.Printf("Error loading version %s of %s: %v", ref, url, err)
+			http.Error(w, "Error loading page version", http.StatusInternalServerError)
+			return
+		}
+		if len(v.Content) > maxDiffBytes || strings.Count(v.Content, "\n") >= maxDiffLines {
+			http.Error(w, "Version "+ref+" is too large to diff", http.StatusRequestEntityTooLarge)
+			return
+		}
+		versions[i] = v
+	}
+
+	key := fmt.Sprintf("%s\x00%s\x00%s\x00%s\x00%s\x00%d\x00%d", url, fromRef, toRef,
+		versions[0].ContentHash, versions[1].ContentHash,
+		versions[0].CapturedAt.UnixNano(), versions[1].CapturedAt.UnixNano())
+	diff, ok := pageDiffs.get(key)
+	if !ok {
+		var err error
+		diff, err = diffPageVersions(url, versions[0], versions[1], fromRef, toRef)
+		if err != nil {
+			log.Printf("Error diffing %s: %v", url, err)
+			http.Error(w, "Error building diff", http.StatusInternalServerError)
+			return
+		}
+		pageDiffs.put(key, diff)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte(diff))
+}
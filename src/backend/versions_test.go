@@ -0,0 +1,139 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestArchivePageVersion(t *testing.T) {
+	const pageURL = "https://da.wikipedia.org/wiki/Aarhus"
+	testCases := []struct {
+		name       string
+		stored     *string
+		newContent string
+		archived   bool
+	}{
+		{name: "New page", stored: nil, newContent: "Aarhus er en by.", archived: false},
+		{name: "Unchanged content", stored: ptr("Aarhus er en by."), newContent: "Aarhus er en by.", archived: false},
+		{name: "Changed content", stored: ptr("Aarhus er en by."), newContent: "Aarhus er Danmarks næststørste by.", archived: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockDB, mock := setupMockDB()
+			defer mockDB.Close()
+
+			mock.ExpectBegin()
+			rows := sqlmock.NewRows([]string{"title", "content", "revision_id", "last_updated"})
+			if tc.stored != nil {
+				rows.AddRow("Aarhus", *tc.stored, 42, time.Now())
+			}
+			mock.ExpectQuery("SELECT title, content, revision_id, last_updated FROM pages").
+				WithArgs(pageURL).WillReturnRows(rows)
+			if tc.archived {
+				mock.ExpectExec("INSERT INTO page_versions").
+					WithArgs(pageURL, "Aarhus", *tc.stored, contentHash(*tc.stored), sqlmock.AnyArg(), sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+			}
+
+			tx, err := db.Begin()
+			assert.NoError(t, err)
+			archived, err := archivePageVersion(tx, pageURL, tc.newContent)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.archived, archived)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestPageDiffHandler(t *testing.T) {
+	const pageURL = "https://en.wikipedia.org/wiki/Go"
+	captured := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	testCases := []struct {
+		name       string
+		query      string
+		mockSetup  func(mock sqlmock.Sqlmock)
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "Missing from",
+			query:      "?url=" + pageURL,
+			mockSetup:  func(mock sqlmock.Sqlmock) {},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Invalid version",
+			query:      "?url=" + pageURL + "&from=abc",
+			mockSetup:  func(mock sqlmock.Sqlmock) {},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:  "Unknown version",
+			query: "?url=" + pageURL + "&from=9",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("FROM page_versions").WithArgs(pageURL, 9).WillReturnError(sql.ErrNoRows)
+			},
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:  "Too large to diff",
+			query: "?url=" + pageURL + "&from=4",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("FROM page_versions").WithArgs(pageURL, 4).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "title", "content", "revision_id", "captured_at"}).
+						AddRow(4, "Go", strings.Repeat("line\n", maxDiffLines), 1, captured))
+			},
+			wantStatus: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:  "Diff against current",
+			query: "?url=" + pageURL + "&from=3",
+			mockSetup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery("FROM page_versions").WithArgs(pageURL, 3).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "title", "content", "revision_id", "captured_at"}).
+						AddRow(3, "Go", "Go is a language.\nIt is compiled.\n", 1, captured))
+				mock.ExpectQuery("FROM pages WHERE url").WithArgs(pageURL).
+					WillReturnRows(sqlmock.NewRows([]string{"title", "content", "revision_id", "last_updated"}).
+						AddRow("Go", "Go is a language.\nIt is statically typed and compiled.\n", 2, captured))
+			},
+			wantStatus: http.StatusOK,
+			wantBody: "--- " + pageURL + "@3\t2026-01-02T03:04:05Z\n" +
+				"+++ " + pageURL + "@current\t2026-01-02T03:04:05Z\n" +
+				"@@ -1,2 +1,2 @@\n" +
+				" Go is a language.\n" +
+				"-It is compiled.\n" +
+				"+It is statically typed and compiled.\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockDB, mock := setupMockDB()
+			defer mockDB.Close()
+			tc.mockSetup(mock)
+
+			req := httptest.NewRequest(http.MethodGet, "/api/pages/diff"+tc.query, nil)
+			w := httptest.NewRecorder()
+			pageDiffHandler(w, req)
+
+			assert.Equal(t, tc.wantStatus, w.Code)
+			if tc.wantBody != "" {
+				assert.Equal(t, tc.wantBody, w.Body.String())
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
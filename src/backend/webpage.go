@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html/charset"
+)
+
+// Where a stored page came from, which decides how it is refetched.
+const (
+	sourceWikipedia = "wikipedia"
+	sourceWeb       = "web"
+)
+
+const maxWebPageBytes = 10 << 20
+
+var (
+	errNotHTML = errors.New("not an HTML page")
+	langTagRe  = regexp.MustCompile(`^[a-z]{2,3}$`)
+)
+
+// scrapeURL fetches an ordinary web page, such as one listed in a sitemap or
+// feed, and turns it into a Page. Metadata from the source entry takes
+// precedence over what is found in the HTML.
+func scrapeURL(ctx context.Context, entry ingestEntry) (Page, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, entry.URL, nil)
+	if err != nil {
+		return Page{}, err
+	}
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+
+	resp, err := scraperHTTPClient.Do(req)
+	if err != nil {
+		return Page{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Page{}, fmt.Errorf("fetching %s returned %s", entry.URL, resp.Status)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "" && !strings.Contains(ct, "html") {
+		return Page{}, fmt.Errorf("%w: %s is %s", errNotHTML, entry.URL, ct)
+	}
+
+	// Convert to UTF-8 using the charset from the Content-Type header or,
+	// failing that, a <meta> tag in the document.
+	body, err := charset.NewReader(io.LimitReader(resp.Body, maxWebPageBytes), resp.Header.Get("Content-Type"))
+	if err != nil {
+		return Page{}, fmt.Errorf("error decoding %s: %w", entry.URL, err)
+	}
+	doc, err := goquery.NewDocumentFromReader(body)
+	if err != nil {
+		return Page{}, fmt.Errorf("error parsing %s: %w", entry.URL, err)
+	}
+
+	page := pageFromHTML(doc, entry)
+	// Store the page under the URL we were redirected to.
+	page.URL = resp.Request.URL.String()
+	if canonical := canonicalURL(doc, resp.Request.URL); canonical != "" {
+		page.URL = canonical
+	}
+	page.ETag = resp.Header.Get("ETag")
+	page.LastModified = resp.Header.Get("Last-Modified")
+
+	if page.Title == "" || page.Content == "" {
+		return Page{}, fmt.Errorf("no title or text content on %s", entry.URL)
+	}
+	return page, nil
+}
+
+// pageFromHTML extracts the title, readable text, headings, description and
+// language of a document.
+func pageFromHTML(doc *goquery.Document, entry ingestEntry) Page {
+	lang := strings.ToLower(strings.SplitN(doc.Find("html").AttrOr("lang", ""), "-", 2)[0])
+	if !langTagRe.MatchString(lang) {
+		lang = ""
+	}
+	description := cleanText(doc.Find(`meta[name="description"]`).AttrOr("content", ""))
+	ogTitle := cleanText(doc.Find(`meta[property="og:title"]`).AttrOr("content", ""))
+	published := parseFeedTime(doc.Find(`meta[property="article:published_time"]`).AttrOr("content", ""))
+
+	doc.Find("script, style, noscript, template, svg, nav, header, footer, aside, form").Remove()
+
+	body := doc.Find("main, article, [role=main]").First()
+	if body.Length() == 0 {
+		body = doc.Find("body")
+	}
+
+	// Keep block structure so the first paragraph can serve as a summary.
+	var blocks []string
+	body.Find("h1, h2, h3, h4, h5, h6, p, li, pre, blockquote, dt, dd").Each(func(_ int, s *goquery.Selection) {
+		if s.Find("p, li").Length() > 0 {
+			return // the nested blocks are visited on their own
+		}
+		if text := cleanText(s.Text()); text != "" {
+			blocks = append(blocks, text)
+		}
+	})
+	content := strings.Join(blocks, "\n")
+	if content == "" {
+		content = cleanText(body.Text())
+	}
+
+	var sections []string
+	body.Find("h2, h3").Each(func(_ int, s *goquery.Selection) {
+		if heading := cleanText(s.Text()); heading != "" {
+			sections = append(sections, heading)
+		}
+	})
+
+	page := Page{
+		Title:       firstNonEmpty(entry.Title, ogTitle, cleanText(doc.Find("title").First().Text())),
+		Content:     content,
+		Summary:     firstNonEmpty(entry.Summary, description, firstParagraph(content)),
+		Sections:    sections,
+		Language:    lang,
+		Source:      sourceWeb,
+		PublishedAt: entry.Published,
+	}
+	if page.PublishedAt.IsZero() {
+		page.PublishedAt = published
+	}
+	if page.Language == "" {
+		// No lang attribute: fall back to our own guess, then the default.
+		page.Language, _ = detectLanguage(content)
+		if page.Language == "" {
+			page.Language = "en"
+		}
+	}
+	return page
+}
+
+// canonicalURL returns the absolute <link rel="canonical"> of a document, if
+// it has one on the same host.
+func canonicalURL(doc *goquery.Document, base *url.URL) string {
+	href, ok := doc.Find(`link[rel="canonical"]`).Attr("href")
+	if !ok || base == nil {
+		return ""
+	}
+	u, err := base.Parse(strings.TrimSpace(href))
+	if err != nil || u.Host != base.Host {
+		return ""
+	}
+	u.Fragment = ""
+	return u.String()
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
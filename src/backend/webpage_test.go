@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScrapeURL(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte(`<!doctype html>
+<html lang="en-GB">
+<head>
+  <title>Install | Docs</title>
+  <meta name="description" content="How to install the tool.">
+  <meta property="article:published_time" content="2026-02-01T09:00:00Z">
+  <link rel="canonical" href="/docs/install">
+</head>
+<body>
+  <nav><a href="/">Home</a></nav>
+  <main>
+    <h1>Install</h1>
+    <p>Download the latest release.</p>
+    <h2>Requirements</h2>
+    <ul><li>Go 1.24 or newer</li></ul>
+    <script>track()</script>
+  </main>
+  <footer>Copyright</footer>
+</body>
+</html>`))
+	}))
+	defer srv.Close()
+
+	previous := scraperHTTPClient
+	scraperHTTPClient = srv.Client()
+	defer func() { scraperHTTPClient = previous }()
+
+	page, err := scrapeURL(context.Background(), ingestEntry{URL: srv.URL + "/docs/install?ref=feed"})
+	assert.NoError(t, err)
+	assert.Equal(t, srv.URL+"/docs/install", page.URL)
+	assert.Equal(t, "Install | Docs", page.Title)
+	assert.Equal(t, "Install\nDownload the latest release.\nRequirements\nGo 1.24 or newer", page.Content)
+	assert.Equal(t, "How to install the tool.", page.Summary)
+	assert.Equal(t, []string{"Requirements"}, page.Sections)
+	assert.Equal(t, "en", page.Language)
+	assert.Equal(t, sourceWeb, page.Source)
+	assert.Equal(t, `"v1"`, page.ETag)
+	assert.True(t, page.PublishedAt.Equal(time.Date(2026, 2, 1, 9, 0, 0, 0, time.UTC)))
+
+	// Feed metadata wins over what the page says about itself.
+	page, err = scrapeURL(context.Background(), ingestEntry{
+		URL:     srv.URL + "/docs/install",
+		Title:   "Installing",
+		Summary: "From the feed.",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "Installing", page.Title)
+	assert.Equal(t, "From the feed.", page.Summary)
+}
+
+func TestScrapeURLRejectsNonHTML(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Write([]byte("%PDF-1.7"))
+	}))
+	defer srv.Close()
+
+	previous := scraperHTTPClient
+	scraperHTTPClient = srv.Client()
+	defer func() { scraperHTTPClient = previous }()
+
+	_, err := scrapeURL(context.Background(), ingestEntry{URL: srv.URL + "/manual.pdf"})
+	assert.ErrorIs(t, err, errNotHTML)
+}
@@ -2,17 +2,19 @@ CREATE TABLE IF NOT EXISTS users (
   id SERIAL PRIMARY KEY,
   username TEXT NOT NULL UNIQUE,
   email TEXT NOT NULL UNIQUE,
-  password TEXT NOT NULL
+  password TEXT NOT NULL,
+  is_admin BOOLEAN NOT NULL DEFAULT FALSE,
+  search_history_opt_out BOOLEAN NOT NULL DEFAULT FALSE
 );
 
-INSERT INTO users (username, email, password) 
-    VALUES ('{{ADMIN_USER}}', '{{ADMIN_EMAIL}}', '{{ADMIN_PASS_HASH}}')
+INSERT INTO users (username, email, password, is_admin) 
+    VALUES ('{{ADMIN_USER}}', '{{ADMIN_EMAIL}}', '{{ADMIN_PASS_HASH}}', TRUE)
     ON CONFLICT (username) DO NOTHING;
 
 CREATE TABLE IF NOT EXISTS pages (
     title TEXT PRIMARY KEY,
     url TEXT NOT NULL UNIQUE,
-    language TEXT NOT NULL CHECK(language IN ('en', 'da')) DEFAULT 'en',
+    language TEXT NOT NULL CHECK(language ~ '^[a-z]{2,3}$') DEFAULT 'en',
     last_updated TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     content TEXT NOT NULL
 );